@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncAtomicCopy(t *testing.T) {
+	interrupted := errors.New("interrupted")
+	// Writes half of the file and fails, like a sync killed mid-copy
+	partialCopier := func(src, dst string, mode os.FileMode) error {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, data[:len(data)/2], mode); err != nil {
+			return err
+		}
+		return interrupted
+	}
+
+	src := createTestDir(t, map[string]string{"existing.txt": "new contents", "fresh.txt": "fresh contents"})
+	dst := createTestDir(t, map[string]string{"existing.txt": "old contents"})
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, AtomicCopy: true, CopyConcurrency: 1, ContinueOnError: true, copier: partialCopier}
+	if err := syncer.SyncDirectories(); !errors.Is(err, ErrCopyFailed) {
+		t.Fatalf("Expected ErrCopyFailed, got %v", err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dst, "existing.txt")); string(data) != "old contents" {
+		t.Errorf("Expected the old file to be left intact, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "fresh.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected no partial file at the destination path, got %v", err)
+	}
+	entries, _ := os.ReadDir(dst)
+	if len(entries) != 1 {
+		t.Errorf("Expected temporary files to be cleaned up, got %d entries", len(entries))
+	}
+
+	for _, batch := range []bool{false, true} {
+		syncer = &DirectorySync{SourceDir: src, DestinationDir: dst, AtomicCopy: true, BatchSmallFiles: batch}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+		if !equalHashes(dirRoot(t, src), dirRoot(t, dst)) {
+			t.Errorf("Expected the destination to match the source after an atomic sync (batch %v)", batch)
+		}
+		os.Remove(filepath.Join(dst, "fresh.txt"))
+	}
+	info, err := os.Stat(filepath.Join(dst, "existing.txt"))
+	if err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("Expected the source permissions to be kept, got %v, %v", info.Mode(), err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// smallFileThreshold returns the size below which files are batched
+func (ds *DirectorySync) smallFileThreshold() int64 {
+	if ds.SmallFileThreshold <= 0 {
+		return defaultSmallFileThreshold
+	}
+	return ds.SmallFileThreshold
+}
+
+// splitSmallFiles separates files below the batching threshold from the rest
+func (ds *DirectorySync) splitSmallFiles(files []FileInfo) (small, large []FileInfo) {
+	for _, file := range files {
+		if file.Size < ds.smallFileThreshold() && !isSymlink(file) {
+			small = append(small, file)
+		} else {
+			large = append(large, file)
+		}
+	}
+	return small, large
+}
+
+// copyBatch streams files into a temporary tar archive inside the destination and
+// unpacks it into place. The archive is removed afterwards, even on failure. A
+// file that can't be batched or unpacked fails on its own under ContinueOnError.
+func (ds *DirectorySync) copyBatch(ctx context.Context, files []FileInfo) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	archive, err := os.CreateTemp(ds.destRoot(), ".merkle-batch-*.tar")
+	if err != nil {
+		return fmt.Errorf("error creating batch archive: %v", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	ds.logf("Batching %d small files\n", len(files))
+	for {
+		failed, err := ds.writeBatchArchive(ctx, archive, files)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && failed < 0 {
+			return fmt.Errorf("error writing batch archive: %v", err)
+		}
+		if err == nil {
+			break
+		}
+		// Leave the file out and rebuild the archive without it
+		if err := ds.tolerateCopyError(files[failed], fmt.Errorf("error batching %s: %v", files[failed].Path, err)); err != nil {
+			return err
+		}
+		files = slices.Delete(slices.Clone(files), failed, failed+1)
+		if len(files) == 0 {
+			return nil
+		}
+	}
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error rewinding batch archive: %v", err)
+	}
+	tr := tar.NewReader(archive)
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := tr.Next(); err != nil {
+			return fmt.Errorf("error reading batch archive: %v", err)
+		}
+		if err := ds.tolerateCopyError(file, ds.unpackBatchedFile(ctx, tr, file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBatchArchive writes files into archive, returning the index of the
+// file that could not be added, or -1. A half-written entry can't be taken
+// back, so retries rebuild the archive from the start.
+func (ds *DirectorySync) writeBatchArchive(ctx context.Context, archive *os.File, files []FileInfo) (int, error) {
+	failed := -1
+	err := ds.withRetries(ctx, func() error {
+		failed = -1
+		if err := archive.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := archive.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		tw := tar.NewWriter(archive)
+		for i, file := range files {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := addFileToTar(tw, file.Path, ds.sourcePath(file.Path), file.Mode); err != nil {
+				failed = i
+				return err
+			}
+		}
+		return tw.Close()
+	})
+	return failed, err
+}
+
+// unpackBatchedFile writes the current entry of tr to file's destination path
+// and finishes the copy as copyToDestination does
+func (ds *DirectorySync) unpackBatchedFile(ctx context.Context, tr *tar.Reader, file FileInfo) error {
+	if err := checkLinkFreeParents(ds.destRoot(), file.Path); err != nil {
+		return err
+	}
+	destPath := filepath.Join(ds.destRoot(), file.Path)
+	if err := os.MkdirAll(filepath.Dir(destPath), ds.dirMode()); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", filepath.Dir(destPath), err)
+	}
+	if ds.DedupeByHash {
+		// Don't truncate content shared with hard links from an earlier sync
+		if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error unlinking %s: %v", file.Path, err)
+		}
+	}
+
+	ds.logf("Copying file: %s\n", file.Path)
+	attempts := 0
+	err := ds.withRetries(ctx, func() error {
+		if attempts++; attempts == 1 {
+			return ds.writeBatchedFile(ctx, destPath, ds.limitReader(tr), file.Mode)
+		}
+		// The archive entry was consumed, so retries copy from the source
+		return ds.copy(ctx, ds.sourcePath(file.Path), destPath, file.Mode)
+	})
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("error copying %s: %v", file.Path, err)
+	}
+	if err := ds.preserveOwnership(ds.sourcePath(file.Path), destPath, file); err != nil {
+		return err
+	}
+	if err := preserveModTime(destPath, file); err != nil {
+		return fmt.Errorf("error setting modification time of %s: %v", file.Path, err)
+	}
+	if err := ds.verifyCopy(file, destPath); err != nil {
+		return err
+	}
+	if err := ds.removeResumeArtifacts(destPath); err != nil {
+		return fmt.Errorf("error deleting partial copy of %s: %v", file.Path, err)
+	}
+	if err := ds.journalCopy(file); err != nil {
+		return err
+	}
+	ds.emit(SyncEvent{Type: EventCopied, Path: file.Path, Size: file.Size})
+	return nil
+}
+
+// writeBatchedFile writes one file unpacked from a batch archive, through a
+// temporary file when AtomicCopy is set or the sync can be cancelled, as copy
+// does, so a cancelled sync leaves no half-written file
+func (ds *DirectorySync) writeBatchedFile(ctx context.Context, destPath string, r io.Reader, mode os.FileMode) error {
+	if !ds.AtomicCopy && ctx.Done() == nil {
+		return writeFile(destPath, r, mode)
+	}
+	return writeAtomically(destPath, func(tmpPath string) error {
+		return writeFile(tmpPath, &contextReader{ctx: ctx, r: r}, mode)
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSyncBatchSmallFiles(t *testing.T) {
+	src := createTestDir(t, map[string]string{
+		"tiny/a.txt": "a",
+		"tiny/b.txt": "bb",
+		"c.txt":      "ccc",
+		"large.bin":  strings.Repeat("L", 128),
+	})
+	dst := createTestDir(t, map[string]string{"c.txt": "stale"})
+
+	syncer := &DirectorySync{
+		SourceDir:          src,
+		DestinationDir:     dst,
+		BatchSmallFiles:    true,
+		SmallFileThreshold: 64,
+	}
+	small, large := syncer.splitSmallFiles([]FileInfo{{Path: "x", Size: 63}, {Path: "y", Size: 64}})
+	if len(small) != 1 || len(large) != 1 || small[0].Path != "x" {
+		t.Fatalf("Unexpected split around threshold: small=%v large=%v", small, large)
+	}
+
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if !bytes.Equal(dirRoot(t, src), dirRoot(t, dst)) {
+		t.Errorf("Destination root differs from source root after batched sync")
+	}
+
+	// The temporary archive must not be left behind
+	leftovers, err := filepath.Glob(filepath.Join(dst, ".merkle-batch-*"))
+	if err != nil {
+		t.Fatalf("Glob failed: %v", err)
+	}
+	if len(leftovers) != 0 {
+		t.Errorf("Expected batch archive to be removed, found %v", leftovers)
+	}
+}
+
+func TestSyncBatchContinueOnError(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A", "bad.txt": "B", "gone.txt": "G"})
+	dst := t.TempDir()
+
+	syncer := &DirectorySync{
+		SourceDir:       src,
+		DestinationDir:  dst,
+		BatchSmallFiles: true,
+		ContinueOnError: true,
+		VerifyAfterCopy: true,
+		hasher: func(path string) ([]byte, error) {
+			if path == filepath.Join(dst, "bad.txt") {
+				return []byte("corrupted"), nil
+			}
+			return hashFile(path)
+		},
+		OnProgress: func(event SyncEvent) {
+			// Removed between the scan and the batch
+			if event.Type == EventHashed && event.Path == "gone.txt" {
+				os.Remove(filepath.Join(src, "gone.txt"))
+			}
+		},
+	}
+	err := syncer.SyncDirectories()
+	if !errors.Is(err, ErrCopyFailed) || !slices.Equal(syncer.FailedCopies, []string{"bad.txt", "gone.txt"}) {
+		t.Fatalf("Expected ErrCopyFailed for bad.txt and gone.txt, got %v, %v", err, syncer.FailedCopies)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "a.txt")); err != nil || string(data) != "A" {
+		t.Errorf("Expected a.txt to be copied despite the failures, got %q, %v", data, err)
+	}
+}
+
+func TestWriteBatchedFileCancelled(t *testing.T) {
+	destPath := filepath.Join(t.TempDir(), "a.txt")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	syncer := &DirectorySync{}
+	if err := syncer.writeBatchedFile(ctx, destPath, strings.NewReader("data"), 0644); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no half-written file, got %v", err)
+	}
+}
+
+func BenchmarkSyncManySmallFiles(b *testing.B) {
+	src := createManyFiles(b, 500)
+	for _, batch := range []bool{false, true} {
+		name := "Individual"
+		if batch {
+			name = "Batched"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				dst := b.TempDir()
+				syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, BatchSmallFiles: batch}
+				if err := syncer.SyncDirectories(); err != nil {
+					b.Fatalf("SyncDirectories failed: %v", err)
+				}
+				os.RemoveAll(dst)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"io"
+	"math/bits"
+	"os"
+)
+
+// Default content-defined chunk sizes used by FileSimilarity
+const (
+	defaultCDCMinSize = 512
+	defaultCDCAvgSize = 2048
+	defaultCDCMaxSize = 8192
+)
+
+// gearTable maps each byte to a pseudo-random value for the gear rolling hash.
+// It is generated from a fixed seed so chunk boundaries are stable across runs.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x6d65726b6c652d63) // "merkle-c"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// cdcSplitter cuts a stream into content-defined chunks with a gear rolling
+// hash: a boundary falls where the top bits of the hash are zero, so an insert
+// or delete only moves the boundaries next to it.
+type cdcSplitter struct {
+	minSize, maxSize int
+	shift            uint // the boundary test looks at the top 64-shift bits
+}
+
+// newCDCSplitter returns a splitter whose chunks average roughly avgSize bytes
+// (rounded up to a power of two) and stay within [minSize, maxSize], except
+// for a shorter final chunk.
+func newCDCSplitter(minSize, avgSize, maxSize int) (*cdcSplitter, error) {
+	if minSize <= 0 || avgSize < minSize || maxSize < avgSize {
+		return nil, ErrInvalidChunkSize
+	}
+	return &cdcSplitter{
+		minSize: minSize,
+		maxSize: maxSize,
+		shift:   uint(64 - bits.Len(uint(avgSize-1))),
+	}, nil
+}
+
+// split reads r to the end and calls fn with each chunk. Chunks are freshly
+// allocated, so fn may keep them.
+func (s *cdcSplitter) split(r io.Reader, fn func(chunk []byte) error) error {
+	reader := bufio.NewReader(r)
+	chunk := make([]byte, 0, s.maxSize)
+	var hash uint64
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		chunk = append(chunk, b)
+		hash = hash<<1 + gearTable[b]
+		if len(chunk) >= s.minSize && hash>>s.shift == 0 || len(chunk) >= s.maxSize {
+			if err := fn(chunk); err != nil {
+				return err
+			}
+			chunk = make([]byte, 0, s.maxSize)
+			hash = 0
+		}
+	}
+	if len(chunk) > 0 {
+		return fn(chunk)
+	}
+	return nil
+}
+
+// NewTreeFromReaderCDC builds a tree over r cut into content-defined chunks
+// averaging roughly avgSize bytes within [minSize, maxSize], except for a
+// shorter final chunk. Unlike the fixed blocks of NewTreeFromReader, an
+// insert or delete only changes the chunks around it, so a small edit leaves
+// most leaves intact. Chunks are hashed as they are read. An empty stream
+// returns ErrEmptyMessage.
+func NewTreeFromReaderCDC(r io.Reader, minSize, avgSize, maxSize int) (*MerkleTree, error) {
+	splitter, err := newCDCSplitter(minSize, avgSize, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	opts := TreeOptions{}
+	var leaves [][]byte
+	err = splitter.split(r, func(chunk []byte) error {
+		leaves = append(leaves, opts.hashLeaf(chunk))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(leaves) == 0 {
+		return nil, ErrEmptyMessage
+	}
+	return newTreeFromLeaves(leaves, opts)
+}
+
+// FileSimilarity estimates how much content two files share, from 0 (nothing)
+// to 1 (identical). Both files are cut into content-defined chunks, so shared
+// content is found even when it moved, and the score is 2*shared/(sizeA+sizeB)
+// where shared counts the bytes of chunks present in both. It is a cheap hint
+// for whether a delta transfer is worth it compared to a full copy, and is
+// what DeltaCopy decides with, over the same chunks it copies.
+func FileSimilarity(pathA, pathB string) (float64, error) {
+	splitter, err := newCDCSplitter(defaultCDCMinSize, defaultCDCAvgSize, defaultCDCMaxSize)
+	if err != nil {
+		return 0, err
+	}
+	chunksA, sizeA, err := chunkCounts(splitter, pathA)
+	if err != nil {
+		return 0, err
+	}
+	chunksB, sizeB, err := chunkCounts(splitter, pathB)
+	if err != nil {
+		return 0, err
+	}
+	return similarity(chunksA, sizeA, chunksB, sizeB), nil
+}
+
+// similarity scores two chunked files as FileSimilarity does
+func similarity(chunksA map[chunkKey]int, sizeA int64, chunksB map[chunkKey]int, sizeB int64) float64 {
+	if sizeA+sizeB == 0 {
+		return 1
+	}
+	var shared int64
+	for key, countA := range chunksA {
+		if countB, ok := chunksB[key]; ok {
+			shared += int64(min(countA, countB)) * key.size
+		}
+	}
+	return float64(2*shared) / float64(sizeA+sizeB)
+}
+
+// chunkKey identifies a chunk by content hash and size
+type chunkKey struct {
+	hash [sha256.Size]byte
+	size int64
+}
+
+// chunkCounts counts the occurrences of each chunk of a file
+func chunkCounts(splitter *cdcSplitter, path string) (map[chunkKey]int, int64, error) {
+	counts := make(map[chunkKey]int)
+	size, err := eachChunk(splitter, path, func(key chunkKey, offset int64) {
+		counts[key]++
+	})
+	return counts, size, err
+}
+
+// eachChunk splits the file at path and calls fn with the key and offset of
+// every chunk, returning the file size
+func eachChunk(splitter *cdcSplitter, path string, fn func(key chunkKey, offset int64)) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var size int64
+	err = splitter.split(file, func(chunk []byte) error {
+		fn(chunkKey{hash: sha256.Sum256(chunk), size: int64(len(chunk))}, size)
+		size += int64(len(chunk))
+		return nil
+	})
+	return size, err
+}
+
+// deltaChunk is a chunk of the source file DeltaCopy writes, read from the
+// destination at destOffset when the destination holds the same chunk, or
+// from the source otherwise
+type deltaChunk struct {
+	offset, size int64
+	destOffset   int64 // -1 when the chunk is only in the source
+}
+
+// planDelta cuts both files into the content-defined chunks FileSimilarity
+// uses and returns the source as a sequence of chunks along with the files'
+// similarity score. Each file is read once.
+func planDelta(src, dst string) ([]deltaChunk, float64, error) {
+	splitter, err := newCDCSplitter(defaultCDCMinSize, defaultCDCAvgSize, defaultCDCMaxSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	destOffsets := make(map[chunkKey]int64)
+	destCounts := make(map[chunkKey]int)
+	destSize, err := eachChunk(splitter, dst, func(key chunkKey, offset int64) {
+		if _, ok := destOffsets[key]; !ok {
+			destOffsets[key] = offset
+		}
+		destCounts[key]++
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var chunks []deltaChunk
+	sourceCounts := make(map[chunkKey]int)
+	sourceSize, err := eachChunk(splitter, src, func(key chunkKey, offset int64) {
+		chunk := deltaChunk{offset: offset, size: key.size, destOffset: -1}
+		if destOffset, ok := destOffsets[key]; ok {
+			chunk.destOffset = destOffset
+		}
+		chunks = append(chunks, chunk)
+		sourceCounts[key]++
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return chunks, similarity(sourceCounts, sourceSize, destCounts, destSize), nil
+}
+
+// copyDelta rebuilds dst as a copy of src for DeltaCopy, taking the chunks dst
+// already holds from dst and only the others from src. The new file is written
+// through a temporary file and renamed into place, and reads stop once ctx is
+// cancelled. It reports false without writing anything when dst isn't a
+// regular file at least DeltaThreshold similar to src.
+func (ds *DirectorySync) copyDelta(ctx context.Context, src, dst string, mode os.FileMode) (bool, error) {
+	info, err := os.Lstat(dst)
+	if err != nil || !info.Mode().IsRegular() {
+		return false, nil
+	}
+	chunks, score, err := planDelta(src, dst)
+	if err != nil {
+		return false, err
+	}
+	threshold := ds.DeltaThreshold
+	if threshold <= 0 {
+		threshold = defaultDeltaThreshold
+	}
+	if score < threshold {
+		return false, nil
+	}
+
+	return true, writeAtomically(dst, func(tmpPath string) error {
+		return ds.writeDelta(ctx, chunks, src, dst, tmpPath, mode)
+	})
+}
+
+// writeDelta writes the chunks planDelta returned to tmpPath. The files are
+// closed again before writeAtomically renames tmpPath over dst.
+func (ds *DirectorySync) writeDelta(ctx context.Context, chunks []deltaChunk, src, dst, tmpPath string, mode os.FileMode) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+	destFile, err := os.Open(dst)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+	if mode == 0 {
+		sourceInfo, err := sourceFile.Stat()
+		if err != nil {
+			return err
+		}
+		mode = sourceInfo.Mode()
+	}
+
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	defer tmp.Close()
+	for _, chunk := range chunks {
+		var r io.Reader = ds.limitReader(io.NewSectionReader(sourceFile, chunk.offset, chunk.size))
+		if chunk.destOffset >= 0 {
+			r = io.NewSectionReader(destFile, chunk.destOffset, chunk.size)
+		}
+		if _, err := io.Copy(tmp, &contextReader{ctx: ctx, r: r}); err != nil {
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(tmpPath, mode)
+}
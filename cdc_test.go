@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func randomBytes(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestCDCSplitterBounds(t *testing.T) {
+	splitter, err := newCDCSplitter(256, 1024, 4096)
+	if err != nil {
+		t.Fatalf("newCDCSplitter failed: %v", err)
+	}
+	data := randomBytes(1, 200_000)
+	var chunks [][]byte
+	splitter.split(bytes.NewReader(data), func(chunk []byte) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if !bytes.Equal(slices.Concat(chunks...), data) {
+		t.Fatalf("Chunks do not reassemble the input")
+	}
+	for i, chunk := range chunks[:len(chunks)-1] {
+		if len(chunk) < 256 || len(chunk) > 4096 {
+			t.Errorf("Chunk %d has %d bytes, outside [256, 4096]", i, len(chunk))
+		}
+	}
+	if average := len(data) / len(chunks); average < 512 || average > 2048 {
+		t.Errorf("Expected chunks to average around 1024 bytes, got %d", average)
+	}
+
+	if _, err := newCDCSplitter(1024, 512, 4096); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("Expected ErrInvalidChunkSize, got %v", err)
+	}
+}
+
+func TestFileSimilarity(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	original := randomBytes(2, 128*1024)
+	// Insert a few bytes near the start, which shifts every later fixed-size chunk
+	edited := slices.Concat(original[:1000], []byte("inserted"), original[1000:])
+
+	a := write("a.bin", original)
+	b := write("b.bin", edited)
+	c := write("c.bin", randomBytes(3, 128*1024))
+
+	if score, err := FileSimilarity(a, b); err != nil || score < 0.9 {
+		t.Errorf("Expected a high score for a small edit, got %.2f, %v", score, err)
+	}
+	if score, err := FileSimilarity(a, c); err != nil || score > 0.1 {
+		t.Errorf("Expected a low score for unrelated files, got %.2f, %v", score, err)
+	}
+	if score, err := FileSimilarity(a, a); err != nil || score != 1 {
+		t.Errorf("Expected identical files to score 1, got %.2f, %v", score, err)
+	}
+	if _, err := FileSimilarity(a, filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
+
+func TestNewTreeFromReaderCDC(t *testing.T) {
+	for seed := int64(1); seed <= 4; seed++ {
+		data := randomBytes(seed, 256*1024)
+		edited := slices.Concat(data[:100], []byte("inserted"), data[100:])
+
+		before, err := NewTreeFromReaderCDC(bytes.NewReader(data), 256, 1024, 4096)
+		if err != nil {
+			t.Fatalf("NewTreeFromReaderCDC failed: %v", err)
+		}
+		after, _ := NewTreeFromReaderCDC(bytes.NewReader(edited), 256, 1024, 4096)
+		changed, err := DiffTrees(before, after)
+		if err != nil {
+			t.Fatalf("DiffTrees failed: %v", err)
+		}
+		if len(changed) == 0 || len(changed) > 2 {
+			t.Errorf("Seed %d: expected the insertion to change at most 2 of %d leaves, got %v", seed, len(before.Leaves), changed)
+		}
+
+		// Fixed-size blocks all shift after the insertion
+		fixedBefore, _ := NewTreeFromReader(bytes.NewReader(data), 1024)
+		fixedAfter, _ := NewTreeFromReader(bytes.NewReader(edited), 1024)
+		if fixedChanged, _ := DiffTrees(fixedBefore, fixedAfter); len(fixedChanged) < len(fixedBefore.Leaves) {
+			t.Errorf("Seed %d: expected every fixed block to change, got %d of %d", seed, len(fixedChanged), len(fixedBefore.Leaves))
+		}
+	}
+
+	if _, err := NewTreeFromReaderCDC(bytes.NewReader(nil), 256, 1024, 4096); !errors.Is(err, ErrEmptyMessage) {
+		t.Errorf("Expected ErrEmptyMessage, got %v", err)
+	}
+	if _, err := NewTreeFromReaderCDC(bytes.NewReader([]byte("data")), 1024, 256, 4096); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("Expected ErrInvalidChunkSize, got %v", err)
+	}
+}
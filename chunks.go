@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// Region is a byte range of a file
+type Region struct {
+	Offset int64
+	Length int64
+}
+
+// ChangedRegions compares two files chunk by chunk and returns the byte ranges
+// whose chunks differ. Adjacent differing chunks are merged into one region,
+// and bytes present in only one file (a size change) count as changed.
+// Identical files are detected from their chunk tree roots without a leaf scan.
+func ChangedRegions(srcPath, dstPath string, chunkSize int) ([]Region, error) {
+	if chunkSize <= 0 {
+		return nil, ErrInvalidChunkSize
+	}
+	srcTree, srcSize, err := fileChunkTree(srcPath, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	dstTree, dstSize, err := fileChunkTree(dstPath, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	if srcTree != nil && dstTree != nil && bytes.Equal(srcTree.Root, dstTree.Root) {
+		return nil, nil
+	}
+
+	var srcLeaves, dstLeaves [][]byte
+	if srcTree != nil {
+		srcLeaves = srcTree.Leaves
+	}
+	if dstTree != nil {
+		dstLeaves = dstTree.Leaves
+	}
+
+	size := max(srcSize, dstSize)
+	var regions []Region
+	for i := range max(len(srcLeaves), len(dstLeaves)) {
+		if i < len(srcLeaves) && i < len(dstLeaves) && bytes.Equal(srcLeaves[i], dstLeaves[i]) {
+			continue
+		}
+		offset := int64(i) * int64(chunkSize)
+		length := min(int64(chunkSize), size-offset)
+
+		// Extend the previous region when the chunks are adjacent
+		if n := len(regions); n > 0 && regions[n-1].Offset+regions[n-1].Length == offset {
+			regions[n-1].Length += length
+			continue
+		}
+		regions = append(regions, Region{Offset: offset, Length: length})
+	}
+	return regions, nil
+}
+
+// NewTreeFromReader builds a tree over r split into chunkSize blocks, where
+// the final block may be shorter. Chunks are hashed as they are read, so the
+// stream is never held in memory. An empty stream returns ErrEmptyMessage.
+func NewTreeFromReader(r io.Reader, chunkSize int) (*MerkleTree, error) {
+	if chunkSize <= 0 {
+		return nil, ErrInvalidChunkSize
+	}
+	opts := TreeOptions{}
+	var leaves [][]byte
+	chunk := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			leaves = append(leaves, opts.hashLeaf(chunk[:n]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(leaves) == 0 {
+		return nil, ErrEmptyMessage
+	}
+	return newTreeFromLeaves(leaves, opts)
+}
+
+// fileChunkTree builds a Merkle tree over fixed-size chunks of a file.
+// An empty file has no chunks and yields a nil tree.
+func fileChunkTree(path string, chunkSize int) (*MerkleTree, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	counter := &countingReader{r: file}
+	tree, err := NewTreeFromReader(counter, chunkSize)
+	if errors.Is(err, ErrEmptyMessage) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return tree, counter.n, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
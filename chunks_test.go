@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestChangedRegions(t *testing.T) {
+	original := strings.Repeat("0123456789", 10) // 100 bytes, 10 chunks of 10
+	middleEdit := original[:42] + "XY" + original[44:]
+	spanningEdit := original[:18] + "XXXX" + original[22:]
+
+	testCases := []struct {
+		name     string
+		src, dst string
+		expected []Region
+	}{
+		{"Identical", original, original, nil},
+		{"MiddleEdit", middleEdit, original, []Region{{Offset: 40, Length: 10}}},
+		{"EditSpanningChunks", spanningEdit, original, []Region{{Offset: 10, Length: 20}}},
+		{"Appended", original + "tail", original, []Region{{Offset: 100, Length: 4}}},
+		{"Truncated", original[:95], original, []Region{{Offset: 90, Length: 10}}},
+		{"EmptyDestination", original[:25], "", []Region{{Offset: 0, Length: 25}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := createTestDir(t, map[string]string{"src": tc.src, "dst": tc.dst})
+			regions, err := ChangedRegions(filepath.Join(dir, "src"), filepath.Join(dir, "dst"), 10)
+			if err != nil {
+				t.Fatalf("ChangedRegions failed: %v", err)
+			}
+			if !slices.Equal(regions, tc.expected) {
+				t.Errorf("Expected regions %v, got %v", tc.expected, regions)
+			}
+		})
+	}
+
+	t.Run("InvalidChunkSize", func(t *testing.T) {
+		if _, err := ChangedRegions("a", "b", 0); !errors.Is(err, ErrInvalidChunkSize) {
+			t.Errorf("Expected ErrInvalidChunkSize, got %v", err)
+		}
+	})
+}
+
+func TestNewTreeFromReader(t *testing.T) {
+	testCases := []struct {
+		name   string
+		data   string
+		chunks []string
+	}{
+		{"ExactMultiple", "aaaabbbbcccc", []string{"aaaa", "bbbb", "cccc"}},
+		{"PartialFinalChunk", "aaaabbbbcc", []string{"aaaa", "bbbb", "cc"}},
+		{"SingleShortChunk", "ab", []string{"ab"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// One byte per Read call, so chunks must be assembled across reads
+			tree, err := NewTreeFromReader(iotest.OneByteReader(strings.NewReader(tc.data)), 4)
+			if err != nil {
+				t.Fatalf("NewTreeFromReader failed: %v", err)
+			}
+			expected, _ := NewTree(createTestDataBlocks(tc.chunks...))
+			if !equalHashes(tree.Root, expected.Root) || len(tree.Leaves) != len(tc.chunks) {
+				t.Errorf("Expected the tree over chunks %q", tc.chunks)
+			}
+			proof, leafHash, _ := tree.GenerateProof(len(tc.chunks) - 1)
+			if ok, err := VerifyProof(tree.Root, proof, leafHash, len(tc.chunks)-1); err != nil || !ok {
+				t.Errorf("Expected the last chunk to verify, got %v, %v", ok, err)
+			}
+		})
+	}
+
+	if _, err := NewTreeFromReader(strings.NewReader(""), 4); !errors.Is(err, ErrEmptyMessage) {
+		t.Errorf("Expected ErrEmptyMessage, got %v", err)
+	}
+	if _, err := NewTreeFromReader(strings.NewReader("data"), 0); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("Expected ErrInvalidChunkSize, got %v", err)
+	}
+	readErr := errors.New("connection reset")
+	if _, err := NewTreeFromReader(iotest.ErrReader(readErr), 4); !errors.Is(err, readErr) {
+		t.Errorf("Expected the read error, got %v", err)
+	}
+}
+
+func TestSyncDeltaCopy(t *testing.T) {
+	original := randomBytes(5, 128*1024)
+	// An insertion shifts everything after it
+	inserted := slices.Concat(original[:70000], []byte("inserted"), original[70000:])
+
+	src := createTestDir(t, map[string]string{
+		"inserted.bin":  string(inserted),
+		"shrunk.bin":    string(original[:100000]),
+		"unrelated.bin": string(randomBytes(6, 64*1024)),
+	})
+	dst := createTestDir(t, map[string]string{
+		"inserted.bin":  string(original),
+		"shrunk.bin":    string(original),
+		"unrelated.bin": string(randomBytes(7, 64*1024)),
+	})
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, DeltaCopy: true}
+
+	// Only the chunks around the insertion are read from the source
+	chunks, score, err := planDelta(filepath.Join(src, "inserted.bin"), filepath.Join(dst, "inserted.bin"))
+	if err != nil {
+		t.Fatalf("planDelta failed: %v", err)
+	}
+	var fromSource int64
+	for _, chunk := range chunks {
+		if chunk.destOffset < 0 {
+			fromSource += chunk.size
+		}
+	}
+	if score < defaultDeltaThreshold || fromSource > 2*defaultCDCMaxSize {
+		t.Errorf("Expected a shifted file to be rebuilt mostly from the destination, got score %.2f and %d bytes from the source", score, fromSource)
+	}
+
+	// Cancelled delta copies leave the destination untouched
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	copied, err := syncer.copyDelta(ctx, filepath.Join(src, "inserted.bin"), filepath.Join(dst, "inserted.bin"), 0644)
+	if !copied || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancelled delta copy to fail with context.Canceled, got %v, %v", copied, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "inserted.bin")); !bytes.Equal(data, original) {
+		t.Errorf("Expected a cancelled delta copy to leave the destination untouched")
+	}
+
+	// Unrelated and missing destinations are copied whole
+	for _, name := range []string{"unrelated.bin", "missing.bin"} {
+		if copied, err := syncer.copyDelta(context.Background(), filepath.Join(src, "unrelated.bin"), filepath.Join(dst, name), 0644); copied || err != nil {
+			t.Errorf("%s: expected a full copy, got %v, %v", name, copied, err)
+		}
+	}
+
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if !bytes.Equal(dirRoot(t, src), dirRoot(t, dst)) {
+		t.Errorf("Expected destination to match source after a delta sync")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+)
+
+// CommonSubtrees returns the relative directory paths whose entire contents are
+// identical between source and destination. Only the largest shared subtrees are
+// reported: once a directory matches, its children are not listed separately.
+// "." is returned alone when the two directories are identical.
+//
+// The walk is top-down, descending only into directories whose subtree roots
+// disagree, so already-synced regions are never examined below their top.
+func (ds *DirectorySync) CommonSubtrees() ([]string, error) {
+	sourceFiles, err := ds.BuildDirectoryTree(ds.SourceDir)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning source directory: %v", err)
+	}
+	destFiles, err := ds.buildDirectoryTree(context.Background(), ds.destRoot())
+	if err != nil {
+		return nil, fmt.Errorf("error scanning destination directory: %v", err)
+	}
+	return commonSubtrees(sourceFiles, destFiles)
+}
+
+// commonSubtrees walks down from the root on both sides, reporting directories
+// whose subtree roots match and descending into child directories present on
+// both sides only where they differ.
+func commonSubtrees(source, dest []FileInfo) ([]string, error) {
+	sourceRoots, err := subtreeRoots(source)
+	if err != nil {
+		return nil, err
+	}
+	destRoots, err := subtreeRoots(dest)
+	if err != nil {
+		return nil, err
+	}
+	sourceChildren := childEntries(source)
+
+	var common []string
+	var walk func(dir string)
+	walk = func(dir string) {
+		if bytes.Equal(sourceRoots[dir], destRoots[dir]) {
+			common = append(common, dir)
+			return
+		}
+		for _, file := range sourceChildren[dir] {
+			if _, ok := destRoots[file.Path]; file.IsDir && ok {
+				walk(file.Path)
+			}
+		}
+	}
+	walk(".")
+	return common, nil
+}
+
+// subtreeRoots computes the Merkle root of every directory in files, including
+// ".", in a single bottom-up pass. A directory's root covers its children,
+// binding each to its path so renames inside the subtree are detected, and
+// takes in the roots of its child directories. An empty directory has a nil
+// root.
+func subtreeRoots(files []FileInfo) (map[string][]byte, error) {
+	children := childEntries(files)
+	roots := make(map[string][]byte)
+	var rootOf func(dir string) ([]byte, error)
+	rootOf = func(dir string) ([]byte, error) {
+		entries := children[dir]
+		dataBlocks := make([][]byte, len(entries))
+		for i, file := range entries {
+			if file.IsDir {
+				childRoot, err := rootOf(file.Path)
+				if err != nil {
+					return nil, err
+				}
+				dataBlocks[i] = append([]byte(file.Path+":dir:"), childRoot...)
+			} else {
+				dataBlocks[i] = append([]byte(file.Path+":"), file.Hash...)
+			}
+		}
+		var root []byte
+		if len(dataBlocks) > 0 {
+			tree, err := NewTree(dataBlocks)
+			if err != nil {
+				return nil, err
+			}
+			root = tree.Root
+		}
+		roots[dir] = root
+		return root, nil
+	}
+	if _, err := rootOf("."); err != nil {
+		return nil, err
+	}
+	return roots, nil
+}
+
+// childEntries groups entries by their parent directory, keeping their order
+func childEntries(files []FileInfo) map[string][]FileInfo {
+	children := make(map[string][]FileInfo)
+	for _, file := range files {
+		parent := path.Dir(file.Path)
+		children[parent] = append(children[parent], file)
+	}
+	return children
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCommonSubtrees(t *testing.T) {
+	t.Run("IdenticalDirectories", func(t *testing.T) {
+		files := map[string]string{"a.txt": "A", "sub/b.txt": "B"}
+		syncer := &DirectorySync{SourceDir: createTestDir(t, files), DestinationDir: createTestDir(t, files)}
+		common, err := syncer.CommonSubtrees()
+		if err != nil {
+			t.Fatalf("CommonSubtrees failed: %v", err)
+		}
+		if !slices.Equal(common, []string{"."}) {
+			t.Errorf("Expected [.], got %v", common)
+		}
+	})
+
+	t.Run("SharedSubdirectory", func(t *testing.T) {
+		src := createTestDir(t, map[string]string{
+			"top.txt":             "changed",
+			"shared/x.txt":        "X",
+			"shared/deep/y.txt":   "Y",
+			"partial/same/z.txt":  "Z",
+			"partial/differs.txt": "new",
+		})
+		dst := createTestDir(t, map[string]string{
+			"top.txt":             "original",
+			"shared/x.txt":        "X",
+			"shared/deep/y.txt":   "Y",
+			"partial/same/z.txt":  "Z",
+			"partial/differs.txt": "old",
+		})
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst}
+		common, err := syncer.CommonSubtrees()
+		if err != nil {
+			t.Fatalf("CommonSubtrees failed: %v", err)
+		}
+		expected := []string{"partial/same", "shared"}
+		if !slices.Equal(common, expected) {
+			t.Errorf("Expected %v, got %v", expected, common)
+		}
+	})
+
+	t.Run("DeepNesting", func(t *testing.T) {
+		// Only the innermost file differs, so every sibling on the way down is shared
+		deep := strings.Repeat("d/", 12)
+		src := createTestDir(t, map[string]string{deep + "leaf.txt": "new", deep + "s/x.txt": "X", "d/s/y.txt": "Y"})
+		dst := createTestDir(t, map[string]string{deep + "leaf.txt": "old", deep + "s/x.txt": "X", "d/s/y.txt": "Y"})
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst}
+		common, err := syncer.CommonSubtrees()
+		if err != nil {
+			t.Fatalf("CommonSubtrees failed: %v", err)
+		}
+		expected := []string{deep + "s", "d/s"}
+		if !slices.Equal(common, expected) {
+			t.Errorf("Expected %v, got %v", expected, common)
+		}
+	})
+
+	t.Run("RenameInsideSubtreeDiffers", func(t *testing.T) {
+		src := createTestDir(t, map[string]string{"sub/a.txt": "A", "other.txt": "1"})
+		dst := createTestDir(t, map[string]string{"sub/b.txt": "A", "other.txt": "2"})
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst}
+		common, err := syncer.CommonSubtrees()
+		if err != nil {
+			t.Fatalf("CommonSubtrees failed: %v", err)
+		}
+		if len(common) != 0 {
+			t.Errorf("Expected no common subtrees, got %v", common)
+		}
+	})
+}
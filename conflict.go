@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ConflictPolicy decides what a mirroring sync does with a modified file whose
+// destination copy was changed more recently than the source, e.g. because
+// someone edited the destination by hand
+type ConflictPolicy int
+
+const (
+	// ConflictOverwriteAlways replaces the destination file with the source,
+	// whatever its modification time. This is the default.
+	ConflictOverwriteAlways ConflictPolicy = iota
+
+	// ConflictSkipIfDestNewer leaves newer destination files as they are and
+	// reports them in Conflicts. The destination keeps differing from the
+	// source until the conflict is resolved.
+	ConflictSkipIfDestNewer
+
+	// ConflictError aborts the sync with ErrSyncConflict, listing the newer
+	// destination files, before anything is copied or deleted.
+	ConflictError
+)
+
+// String returns a readable name for the policy
+func (p ConflictPolicy) String() string {
+	switch p {
+	case ConflictOverwriteAlways:
+		return "overwrite-always"
+	case ConflictSkipIfDestNewer:
+		return "skip-if-dest-newer"
+	case ConflictError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// resolveConflicts applies the ConflictPolicy to the files about to be copied,
+// returning the ones that should still be copied
+func (ds *DirectorySync) resolveConflicts(filesToCopy, destFiles []FileInfo) ([]FileInfo, error) {
+	if ds.ConflictPolicy == ConflictOverwriteAlways {
+		return filesToCopy, nil
+	}
+	destByPath := make(map[string]FileInfo, len(destFiles))
+	for _, file := range destFiles {
+		destByPath[ds.pathKey(file.Path)] = file
+	}
+
+	var conflicts []string
+	filesToCopy = slices.DeleteFunc(filesToCopy, func(file FileInfo) bool {
+		destFile, ok := destByPath[ds.pathKey(file.Path)]
+		if !ok || file.IsDir || destFile.IsDir || !destFile.LastModified.After(file.LastModified) {
+			return false
+		}
+		conflicts = append(conflicts, file.Path)
+		return true
+	})
+	if len(conflicts) == 0 {
+		return filesToCopy, nil
+	}
+	if ds.ConflictPolicy == ConflictError {
+		return nil, fmt.Errorf("%w: %s", ErrSyncConflict, strings.Join(conflicts, ", "))
+	}
+	ds.Conflicts = conflicts
+	return filesToCopy, nil
+}
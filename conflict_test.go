@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConflictPolicy(t *testing.T) {
+	// setup returns a source and a destination where edited.txt is newer at
+	// the destination, stale.txt is older there and new.txt is missing
+	setup := func(t *testing.T) (string, string) {
+		src := createTestDir(t, map[string]string{"edited.txt": "source", "stale.txt": "source", "new.txt": "new"})
+		dst := createTestDir(t, map[string]string{"edited.txt": "edited by hand", "stale.txt": "old"})
+		now := time.Now()
+		for path, modTime := range map[string]time.Time{
+			filepath.Join(src, "edited.txt"): now.Add(-time.Hour),
+			filepath.Join(dst, "edited.txt"): now,
+			filepath.Join(src, "stale.txt"):  now,
+			filepath.Join(dst, "stale.txt"):  now.Add(-time.Hour),
+		} {
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return src, dst
+	}
+	contents := func(t *testing.T, path string) string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+
+	t.Run("OverwriteAlways", func(t *testing.T) {
+		src, dst := setup(t)
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+		if got := contents(t, filepath.Join(dst, "edited.txt")); got != "source" {
+			t.Errorf("Expected the newer destination file to be overwritten, got %q", got)
+		}
+	})
+
+	t.Run("SkipIfDestNewer", func(t *testing.T) {
+		src, dst := setup(t)
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, ConflictPolicy: ConflictSkipIfDestNewer, VerifyOnComplete: true}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+		if got := contents(t, filepath.Join(dst, "edited.txt")); got != "edited by hand" {
+			t.Errorf("Expected the newer destination file to be kept, got %q", got)
+		}
+		if got := contents(t, filepath.Join(dst, "stale.txt")); got != "source" {
+			t.Errorf("Expected the older destination file to be overwritten, got %q", got)
+		}
+		if got := contents(t, filepath.Join(dst, "new.txt")); got != "new" {
+			t.Errorf("Expected the missing file to be copied, got %q", got)
+		}
+		if !slices.Equal(syncer.Conflicts, []string{"edited.txt"}) {
+			t.Errorf("Expected edited.txt to be reported, got %v", syncer.Conflicts)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		src, dst := setup(t)
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, ConflictPolicy: ConflictError}
+		err := syncer.SyncDirectories()
+		if !errors.Is(err, ErrSyncConflict) || !strings.Contains(err.Error(), "edited.txt") {
+			t.Fatalf("Expected ErrSyncConflict listing edited.txt, got %v", err)
+		}
+		if got := contents(t, filepath.Join(dst, "stale.txt")); got != "old" {
+			t.Errorf("Expected nothing to be copied, got stale.txt %q", got)
+		}
+		if _, err := os.Stat(filepath.Join(dst, "new.txt")); !os.IsNotExist(err) {
+			t.Errorf("Expected new.txt not to be copied, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// dotLabelLength is the number of hex digits of a hash shown on each node
+const dotLabelLength = 8
+
+// ToDOT writes the tree as a Graphviz digraph, for example to render it with
+// `dot -Tpng`. Each node is labeled with the first hex digits of its hash and
+// has an edge to its parent; leaves are drawn as boxes, below the root.
+func (t *MerkleTree) ToDOT(w io.Writer) error {
+	if t == nil {
+		return ErrNilTree
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph MerkleTree {\n\trankdir=BT;\n\tnode [shape=ellipse];\n")
+	for level, nodes := range t.nodes {
+		for i, node := range nodes {
+			label := hex.EncodeToString(node)
+			if len(label) > dotLabelLength {
+				label = label[:dotLabelLength]
+			}
+			attrs := ""
+			if level == 0 {
+				attrs = ", shape=box, style=filled, fillcolor=lightgrey"
+			}
+			fmt.Fprintf(&b, "\tn%d_%d [label=%q%s];\n", level, i, label, attrs)
+		}
+	}
+	for level := range len(t.nodes) - 1 {
+		for i := range t.nodes[level] {
+			fmt.Fprintf(&b, "\tn%d_%d -> n%d_%d;\n", level, i, level+1, i/2)
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestToDOT(t *testing.T) {
+	testCases := []struct {
+		name   string
+		blocks []string
+		nodes  int
+		leaves int
+		edges  int
+	}{
+		{"FourLeaves", []string{"A", "B", "C", "D"}, 7, 4, 6},
+		{"ThreeLeaves", []string{"A", "B", "C"}, 6, 3, 5},
+		{"SingleLeaf", []string{"A"}, 1, 1, 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tree, _ := NewTree(createTestDataBlocks(tc.blocks...))
+			var b strings.Builder
+			if err := tree.ToDOT(&b); err != nil {
+				t.Fatalf("ToDOT failed: %v", err)
+			}
+			dot := b.String()
+
+			if !strings.HasPrefix(dot, "digraph MerkleTree {") || !strings.HasSuffix(dot, "}\n") {
+				t.Errorf("Expected a digraph, got:\n%s", dot)
+			}
+			if got := strings.Count(dot, "[label="); got != tc.nodes {
+				t.Errorf("Expected %d node declarations, got %d", tc.nodes, got)
+			}
+			if got := strings.Count(dot, "shape=box"); got != tc.leaves {
+				t.Errorf("Expected %d leaf declarations, got %d", tc.leaves, got)
+			}
+			if got := strings.Count(dot, " -> "); got != tc.edges {
+				t.Errorf("Expected %d edges, got %d", tc.edges, got)
+			}
+			rootLabel := tree.RootHex()[:dotLabelLength]
+			if !strings.Contains(dot, `[label="`+rootLabel+`"`) {
+				t.Errorf("Expected the root to be labeled %s", rootLabel)
+			}
+		})
+	}
+
+	var nilTree *MerkleTree
+	if err := nilTree.ToDOT(&strings.Builder{}); !errors.Is(err, ErrNilTree) {
+		t.Errorf("Expected ErrNilTree, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// EditOpType identifies how a leaf changes between two trees
+type EditOpType int
+
+const (
+	EditInsert EditOpType = iota // Leaf exists only in the new tree
+	EditDelete                   // Leaf exists only in the old tree
+	EditUpdate                   // Leaf exists in both trees with different hashes
+)
+
+// String returns a readable name for the operation type
+func (t EditOpType) String() string {
+	switch t {
+	case EditInsert:
+		return "insert"
+	case EditDelete:
+		return "delete"
+	case EditUpdate:
+		return "update"
+	default:
+		return "unknown"
+	}
+}
+
+// EditOp is a single leaf-level change in an edit script
+type EditOp struct {
+	Type  EditOpType
+	Index int    // Leaf index the operation applies to
+	Leaf  []byte // Leaf hash from the new tree (nil for EditDelete)
+}
+
+// EditScript returns the leaf operations that turn old into new, ordered by
+// index. Both trees must index the same keyspace, so leaf i of old corresponds
+// to leaf i of new; inserts and deletes can therefore only happen at the end.
+// Applying the updates, then the inserts, then truncating at the first delete
+// yields the leaves of new.
+//
+// The diff is top-down: matching subtrees are skipped at their root, so the
+// cost grows with the number of changed leaves rather than the tree size.
+func EditScript(old, new *MerkleTree) ([]EditOp, error) {
+	if old == nil || new == nil {
+		return nil, ErrNilTree
+	}
+	if len(old.nodes) == 0 || len(new.nodes) == 0 {
+		return nil, ErrZeroLeaves
+	}
+
+	var ops []EditOp
+	// Nodes at the same level and position cover the same leaves in both
+	// trees, so the diff starts at the top level they have in common
+	top := min(len(old.nodes), len(new.nodes)) - 1
+	width := max(len(old.nodes[top]), len(new.nodes[top]))
+	for i := range width {
+		ops = diffNodes(old, new, top, i, ops)
+	}
+	return ops, nil
+}
+
+// DiffTrees returns the indices of the leaves that differ between a and b,
+// including leaves only one of them has, in increasing order. Like
+// EditScript, it walks both trees top-down and skips subtrees whose roots
+// match, so identical trees are answered from the roots alone.
+func DiffTrees(a, b *MerkleTree) ([]int, error) {
+	ops, err := EditScript(a, b)
+	if err != nil {
+		return nil, err
+	}
+	indices := make([]int, 0, len(ops))
+	for _, op := range ops {
+		indices = append(indices, op.Index)
+	}
+	return indices, nil
+}
+
+// diffVisitCounter, when set by tests, counts the node pairs compared by diffNodes.
+var diffVisitCounter *atomic.Int64
+
+// diffNodes appends the operations for the leaves under node index at level
+func diffNodes(old, new *MerkleTree, level, index int, ops []EditOp) []EditOp {
+	if diffVisitCounter != nil {
+		diffVisitCounter.Add(1)
+	}
+	inOld := index < len(old.nodes[level])
+	inNew := index < len(new.nodes[level])
+	if !inOld && !inNew {
+		return ops
+	}
+
+	if inOld && inNew && bytes.Equal(old.nodes[level][index], new.nodes[level][index]) {
+		// Duplicated odd nodes let a shorter tree share hashes with a longer
+		// one, so equal subtrees must also cover the same number of leaves
+		end := (index + 1) << level
+		if min(end, len(old.Leaves)) == min(end, len(new.Leaves)) {
+			return ops
+		}
+	}
+
+	if level == 0 {
+		switch {
+		case inOld && inNew:
+			if !bytes.Equal(old.Leaves[index], new.Leaves[index]) {
+				ops = append(ops, EditOp{Type: EditUpdate, Index: index, Leaf: new.Leaves[index]})
+			}
+		case inNew:
+			ops = append(ops, EditOp{Type: EditInsert, Index: index, Leaf: new.Leaves[index]})
+		default:
+			ops = append(ops, EditOp{Type: EditDelete, Index: index})
+		}
+		return ops
+	}
+
+	ops = diffNodes(old, new, level-1, 2*index, ops)
+	return diffNodes(old, new, level-1, 2*index+1, ops)
+}
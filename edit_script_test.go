@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+// applyEditScript replays ops on a copy of leaves
+func applyEditScript(leaves [][]byte, ops []EditOp) [][]byte {
+	result := slices.Clone(leaves)
+	length := len(result)
+	for _, op := range ops {
+		switch op.Type {
+		case EditUpdate:
+			result[op.Index] = op.Leaf
+		case EditInsert:
+			result = append(result, op.Leaf)
+			length = len(result)
+		case EditDelete:
+			length = min(length, op.Index)
+		}
+	}
+	return result[:length]
+}
+
+func TestEditScript(t *testing.T) {
+	testCases := []struct {
+		name     string
+		old, new []string
+		want     []EditOpType
+	}{
+		{"Identical", []string{"A", "B", "C"}, []string{"A", "B", "C"}, nil},
+		{"Update", []string{"A", "B", "C", "D", "E"}, []string{"A", "B", "X", "D", "E"}, []EditOpType{EditUpdate}},
+		{"Insert", []string{"A", "B", "C"}, []string{"A", "B", "C", "D", "E"}, []EditOpType{EditInsert, EditInsert}},
+		{"Delete", []string{"A", "B", "C", "D", "E"}, []string{"A", "B"}, []EditOpType{EditDelete, EditDelete, EditDelete}},
+		{"UpdateAndInsert", []string{"A", "B"}, []string{"X", "B", "C"}, []EditOpType{EditUpdate, EditInsert}},
+		// [A B C] and [A B C C] share every hash above the leaves when the odd node is duplicated
+		{"DuplicatedTail", []string{"A", "B", "C"}, []string{"A", "B", "C", "C"}, []EditOpType{EditInsert}},
+	}
+
+	for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote} {
+		opts := TreeOptions{OddNodeStrategy: strategy}
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s/%s", strategy, tc.name), func(t *testing.T) {
+				oldTree, _ := NewTreeWithOptions(createTestDataBlocks(tc.old...), opts)
+				newTree, _ := NewTreeWithOptions(createTestDataBlocks(tc.new...), opts)
+
+				ops, err := EditScript(oldTree, newTree)
+				if err != nil {
+					t.Fatalf("EditScript failed: %v", err)
+				}
+				var types []EditOpType
+				for _, op := range ops {
+					types = append(types, op.Type)
+				}
+				if !slices.Equal(types, tc.want) {
+					t.Errorf("Expected operations %v, got %v", tc.want, types)
+				}
+
+				root, err := computeRoot(applyEditScript(oldTree.Leaves, ops), opts)
+				if err != nil {
+					t.Fatalf("computeRoot failed: %v", err)
+				}
+				if !bytes.Equal(root, newTree.Root) {
+					t.Errorf("Applying the edit script did not produce the new tree's root")
+				}
+			})
+		}
+	}
+
+	t.Run("Indexes", func(t *testing.T) {
+		old, _ := NewTree(createTestDataBlocks("A", "B", "C", "D", "E", "F", "G", "H"))
+		new, _ := NewTree(createTestDataBlocks("A", "X", "C", "D", "E", "F", "Y", "H"))
+		ops, _ := EditScript(old, new)
+		if len(ops) != 2 || ops[0].Index != 1 || ops[1].Index != 6 {
+			t.Errorf("Expected updates at 1 and 6, got %v", ops)
+		}
+	})
+
+	if _, err := EditScript(nil, &MerkleTree{}); !errors.Is(err, ErrNilTree) {
+		t.Errorf("Expected ErrNilTree, got %v", err)
+	}
+}
+
+func TestDiffTrees(t *testing.T) {
+	blocks := make([][]byte, 1024)
+	for i := range blocks {
+		blocks[i] = fmt.Appendf(nil, "block-%d", i)
+	}
+	base, _ := NewTree(blocks)
+	changed := func(indices ...int) *MerkleTree {
+		modified := slices.Clone(blocks)
+		for _, i := range indices {
+			modified[i] = []byte("changed")
+		}
+		tree, _ := NewTree(modified)
+		return tree
+	}
+
+	testCases := []struct {
+		name      string
+		other     *MerkleTree
+		want      []int
+		maxVisits int64
+	}{
+		{"Identical", changed(), []int{}, 1},
+		{"OneLeaf", changed(517), []int{517}, 2 * 11},
+		{"SeveralLeaves", changed(3, 4, 900, 1023), []int{3, 4, 900, 1023}, 4 * 2 * 11},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var visits atomic.Int64
+			diffVisitCounter = &visits
+			defer func() { diffVisitCounter = nil }()
+
+			indices, err := DiffTrees(base, tc.other)
+			if err != nil {
+				t.Fatalf("DiffTrees failed: %v", err)
+			}
+			if !slices.Equal(indices, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, indices)
+			}
+			// Each differing leaf costs at most both children on its path
+			if visits.Load() > tc.maxVisits {
+				t.Errorf("Expected at most %d node comparisons for %d leaves, got %d", tc.maxVisits, len(blocks), visits.Load())
+			}
+		})
+	}
+
+	t.Run("DifferentSizes", func(t *testing.T) {
+		shorter, _ := NewTree(blocks[:1000])
+		indices, err := DiffTrees(shorter, base)
+		if err != nil {
+			t.Fatalf("DiffTrees failed: %v", err)
+		}
+		if len(indices) != 24 || indices[0] != 1000 || indices[23] != 1023 {
+			t.Errorf("Expected the 24 appended leaves, got %v", indices)
+		}
+	})
+
+	if _, err := DiffTrees(base, nil); !errors.Is(err, ErrNilTree) {
+		t.Errorf("Expected ErrNilTree, got %v", err)
+	}
+}
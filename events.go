@@ -0,0 +1,77 @@
+package main
+
+import "fmt"
+
+// SyncEventType identifies what happened to a path during a sync
+type SyncEventType int
+
+const (
+	EventDiscovered SyncEventType = iota // Entry found while scanning a directory
+	EventHashed                          // File contents hashed
+	EventCopied                          // File copied (or linked) or directory created at the destination
+	EventDeleted                         // Entry removed from the destination
+	EventError                           // Sync failed; Err holds the cause
+)
+
+// String returns a readable name for the event type
+func (t SyncEventType) String() string {
+	switch t {
+	case EventDiscovered:
+		return "discovered"
+	case EventHashed:
+		return "hashed"
+	case EventCopied:
+		return "copied"
+	case EventDeleted:
+		return "deleted"
+	case EventError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// SyncEvent describes a single step of a sync for real-time monitoring
+type SyncEvent struct {
+	Type SyncEventType
+	Path string // Relative path the event refers to (empty for EventError)
+	Size int64  // Size in bytes, when known
+	Err  error  // Cause of an EventError
+	// Count is the running number of events of this Type in the current
+	// sync, including this one
+	Count int
+}
+
+// emit sends an event to the Events channel and OnProgress, if set, while a
+// sync is running. Sends block until the consumer receives them so no event
+// is lost.
+func (ds *DirectorySync) emit(event SyncEvent) {
+	if !ds.syncing || (ds.Events == nil && ds.OnProgress == nil) {
+		return
+	}
+	// progressMu keeps callbacks serialized and in Count order without holding
+	// ds.mu, which the callback may need to read reports
+	ds.progressMu.Lock()
+	ds.mu.Lock()
+	if ds.eventCounts == nil {
+		ds.eventCounts = make(map[SyncEventType]int)
+	}
+	ds.eventCounts[event.Type]++
+	event.Count = ds.eventCounts[event.Type]
+	ds.mu.Unlock()
+	if ds.OnProgress != nil {
+		ds.OnProgress(event)
+	}
+	ds.progressMu.Unlock()
+
+	if ds.Events != nil {
+		ds.Events <- event
+	}
+}
+
+// logf prints a progress line unless OnProgress reports progress instead
+func (ds *DirectorySync) logf(format string, args ...any) {
+	if ds.OnProgress == nil {
+		fmt.Printf(format, args...)
+	}
+}
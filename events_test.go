@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// collectEvents runs a sync with an Events channel and returns everything received
+func collectEvents(t *testing.T, syncer *DirectorySync) ([]SyncEvent, error) {
+	t.Helper()
+	events := make(chan SyncEvent)
+	syncer.Events = events
+
+	done := make(chan []SyncEvent)
+	go func() {
+		var received []SyncEvent
+		for event := range events {
+			received = append(received, event)
+		}
+		done <- received
+	}()
+
+	err := syncer.SyncDirectories()
+	return <-done, err
+}
+
+func TestSyncEvents(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "BB"})
+	dst := createTestDir(t, map[string]string{"stale.txt": "S"})
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, HashConcurrency: 1, CopyConcurrency: 1}
+	received, err := collectEvents(t, syncer)
+	if err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	dirInfo, err := os.Stat(filepath.Join(src, "sub"))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	expected := []SyncEvent{
+		{Type: EventDiscovered, Path: "a.txt", Size: 1, Count: 1},
+		{Type: EventDiscovered, Path: "sub", Size: dirInfo.Size(), Count: 2},
+		{Type: EventDiscovered, Path: "sub/b.txt", Size: 2, Count: 3},
+		{Type: EventHashed, Path: "a.txt", Size: 1, Count: 1},
+		{Type: EventHashed, Path: "sub/b.txt", Size: 2, Count: 2},
+		{Type: EventDiscovered, Path: "stale.txt", Size: 1, Count: 4},
+		{Type: EventHashed, Path: "stale.txt", Size: 1, Count: 3},
+		{Type: EventCopied, Path: "sub", Count: 1},
+		{Type: EventCopied, Path: "a.txt", Size: 1, Count: 2},
+		{Type: EventCopied, Path: "sub/b.txt", Size: 2, Count: 3},
+		{Type: EventDeleted, Path: "stale.txt", Count: 1},
+	}
+	if !slices.Equal(received, expected) {
+		t.Errorf("Event sequence mismatch.\nExpected: %v\nGot:      %v", expected, received)
+	}
+}
+
+func TestSyncEventsReportError(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A"})
+	copyErr := errors.New("disk full")
+	syncer := &DirectorySync{
+		SourceDir:      src,
+		DestinationDir: t.TempDir(),
+		copier:         func(string, string, os.FileMode) error { return copyErr },
+	}
+
+	received, err := collectEvents(t, syncer)
+	if err == nil {
+		t.Fatalf("Expected sync to fail")
+	}
+	last := received[len(received)-1]
+	if last.Type != EventError || last.Err == nil {
+		t.Errorf("Expected final EventError, got %+v", last)
+	}
+}
+
+func TestSyncOnProgress(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A", "b.txt": "BB", "sub/c.txt": "CCC"})
+	dst := createTestDir(t, map[string]string{"a.txt": "A", "stale.txt": "S", "old/d.txt": "D"})
+
+	var received []SyncEvent
+	syncer := &DirectorySync{
+		SourceDir:      src,
+		DestinationDir: dst,
+		OnProgress:     func(event SyncEvent) { received = append(received, event) },
+	}
+
+	// Progress goes to the callback instead of stdout
+	stdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err := syncer.SyncDirectories()
+	os.Stdout = stdout
+	w.Close()
+	printed, _ := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if len(printed) != 0 {
+		t.Errorf("Expected nothing on stdout with OnProgress set, got %q", printed)
+	}
+
+	counts := make(map[SyncEventType]int)
+	var copiedBytes int64
+	for _, event := range received {
+		counts[event.Type]++
+		if event.Count != counts[event.Type] {
+			t.Errorf("Expected %s event %d to carry that count, got %d", event.Type, counts[event.Type], event.Count)
+		}
+		if event.Type == EventCopied {
+			copiedBytes += event.Size
+		}
+	}
+	// 4 entries on each side, 3 of them files; b.txt, sub and sub/c.txt are
+	// copied and stale.txt, old and old/d.txt deleted
+	want := map[SyncEventType]int{EventDiscovered: 8, EventHashed: 6, EventCopied: 3, EventDeleted: 3}
+	if !maps.Equal(counts, want) {
+		t.Errorf("Expected event counts %v, got %v", want, counts)
+	}
+	if copiedBytes != 5 {
+		t.Errorf("Expected 5 copied bytes to be reported, got %d", copiedBytes)
+	}
+}
+
+func TestEventsOnlyDuringSync(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "B"})
+	dst := t.TempDir()
+
+	// Nobody reads the channel, so an emitted event would block forever
+	var progressed []SyncEvent
+	syncer := &DirectorySync{
+		SourceDir:      src,
+		DestinationDir: dst,
+		Events:         make(chan SyncEvent),
+		OnProgress:     func(event SyncEvent) { progressed = append(progressed, event) },
+	}
+	if _, err := syncer.Plan(); err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if _, err := syncer.Explain(); err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if err := syncer.ExportPatch(io.Discard); err != nil {
+		t.Fatalf("ExportPatch failed: %v", err)
+	}
+	if len(progressed) != 0 {
+		t.Errorf("Expected no events outside a sync, got %v", progressed)
+	}
+
+	// After a sync has closed the channel, scans must not send on it
+	if _, err := collectEvents(t, syncer); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	progressed = nil
+	if err := syncer.ExportManifest(io.Discard); err != nil {
+		t.Fatalf("ExportManifest failed: %v", err)
+	}
+	if _, err := syncer.BuildDirectoryTree(src); err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+	if len(progressed) != 0 {
+		t.Errorf("Expected no events after the sync, got %v", progressed)
+	}
+}
+
+func TestOnProgressRunsWithoutReportLock(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A", "b.txt": "B"})
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: t.TempDir()}
+
+	// The callback takes the report lock, which deadlocks if emit still holds it
+	calls := 0
+	syncer.OnProgress = func(SyncEvent) {
+		syncer.mu.Lock()
+		calls++
+		syncer.mu.Unlock()
+	}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if calls == 0 {
+		t.Errorf("Expected OnProgress to be called")
+	}
+}
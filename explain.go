@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Explain scans both directories and summarizes how they differ in a single
+// sentence, e.g. "3 files differ: 2 modified, 1 added; destination has 1
+// extra file." A new file whose content matches a file only the destination
+// has is counted as moved rather than as one addition and one extra file.
+func (ds *DirectorySync) Explain() (string, error) {
+	sourceFiles, err := ds.scanSource(context.Background())
+	if err != nil {
+		return "", err
+	}
+	destFiles, err := ds.buildDirectoryTree(context.Background(), ds.destRoot())
+	if err != nil {
+		return "", fmt.Errorf("error scanning destination directory: %v", err)
+	}
+	diff, err := ds.CompareTreesDetailed(sourceFiles, destFiles)
+	if err != nil {
+		return "", err
+	}
+
+	// Files only the destination has are candidates for moves, keyed by content hash
+	extraByHash := make(map[string]int)
+	var extraFiles, extraDirs int
+	for _, file := range diff.Deleted {
+		if file.IsDir {
+			extraDirs++
+			continue
+		}
+		extraFiles++
+		extraByHash[string(file.Hash)]++
+	}
+
+	var added, moved, missingDirs int
+	for _, file := range diff.Added {
+		switch {
+		case file.IsDir:
+			missingDirs++
+		case extraByHash[string(file.Hash)] > 0:
+			extraByHash[string(file.Hash)]--
+			extraFiles--
+			moved++
+		default:
+			added++
+		}
+	}
+	modified := len(diff.Modified)
+
+	var clauses []string
+	if differing := modified + added + moved; differing > 0 {
+		var kinds []string
+		for _, kind := range []struct {
+			count int
+			name  string
+		}{{modified, "modified"}, {added, "added"}, {moved, "moved"}} {
+			if kind.count > 0 {
+				kinds = append(kinds, fmt.Sprintf("%d %s", kind.count, kind.name))
+			}
+		}
+		clauses = append(clauses, fmt.Sprintf("%s %s: %s", plural(differing, "file", "files"),
+			verb(differing, "differs", "differ"), strings.Join(kinds, ", ")))
+	}
+	if missingDirs > 0 {
+		clauses = append(clauses, fmt.Sprintf("%s %s missing", plural(missingDirs, "directory", "directories"),
+			verb(missingDirs, "is", "are")))
+	}
+	var extras []string
+	if extraFiles > 0 {
+		extras = append(extras, plural(extraFiles, "extra file", "extra files"))
+	}
+	if extraDirs > 0 {
+		extras = append(extras, plural(extraDirs, "extra directory", "extra directories"))
+	}
+	if len(extras) > 0 {
+		clauses = append(clauses, "destination has "+strings.Join(extras, " and "))
+	}
+
+	if len(clauses) == 0 {
+		return "Directories are identical.", nil
+	}
+	sentence := strings.Join(clauses, "; ") + "."
+	return strings.ToUpper(sentence[:1]) + sentence[1:], nil
+}
+
+// plural formats a count with the singular or plural noun
+func plural(count int, singular, pluralForm string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, singular)
+	}
+	return fmt.Sprintf("%d %s", count, pluralForm)
+}
+
+// verb picks the verb agreeing with count
+func verb(count int, singular, pluralForm string) string {
+	if count == 1 {
+		return singular
+	}
+	return pluralForm
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestExplain(t *testing.T) {
+	testCases := []struct {
+		name     string
+		src, dst map[string]string
+		want     string
+	}{
+		{
+			name: "Identical",
+			src:  map[string]string{"a.txt": "A"},
+			dst:  map[string]string{"a.txt": "A"},
+			want: "Directories are identical.",
+		},
+		{
+			name: "ModifiedAddedExtra",
+			src:  map[string]string{"a.txt": "A2", "b.txt": "B2", "c.txt": "C"},
+			dst:  map[string]string{"a.txt": "A", "b.txt": "B", "old.txt": "O"},
+			want: "3 files differ: 2 modified, 1 added; destination has 1 extra file.",
+		},
+		{
+			name: "Moved",
+			src:  map[string]string{"new/name.txt": "same"},
+			dst:  map[string]string{"name.txt": "same"},
+			want: "1 file differs: 1 moved; 1 directory is missing.",
+		},
+		{
+			name: "OnlyExtras",
+			src:  map[string]string{"a.txt": "A"},
+			dst:  map[string]string{"a.txt": "A", "x.txt": "X", "y/z.txt": "Z"},
+			want: "Destination has 2 extra files and 1 extra directory.",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ds := &DirectorySync{SourceDir: createTestDir(t, tc.src), DestinationDir: createTestDir(t, tc.dst)}
+			got, err := ds.Explain()
+			if err != nil {
+				t.Fatalf("Explain failed: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("Expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
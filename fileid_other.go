@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileID uniquely identifies a file on a system by device and inode
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+// identifyFile reports that files cannot be identified on this platform,
+// which disables directory loop detection.
+func identifyFile(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,41 @@
+package main
+
+import "fmt"
+
+// Tree is a Merkle tree over typed keys. Each key is turned into a data block
+// by the serializer given to NewGenericTree, and its position is remembered so
+// membership can be proven by key instead of by index. The underlying
+// MerkleTree is embedded, so Root, GenerateProof and friends are available.
+type Tree[K comparable] struct {
+	*MerkleTree
+	indexes map[K]int
+}
+
+// NewGenericTree builds a tree over keys, in the given order, using serialize
+// to produce each leaf's data block. Keys must be unique.
+func NewGenericTree[K comparable](keys []K, serialize func(K) []byte) (*Tree[K], error) {
+	indexes := make(map[K]int, len(keys))
+	dataBlocks := make([][]byte, len(keys))
+	for i, key := range keys {
+		if _, exists := indexes[key]; exists {
+			return nil, fmt.Errorf("%w: %v", ErrDuplicateKey, key)
+		}
+		indexes[key] = i
+		dataBlocks[i] = serialize(key)
+	}
+
+	tree, err := NewTree(dataBlocks)
+	if err != nil {
+		return nil, err
+	}
+	return &Tree[K]{MerkleTree: tree, indexes: indexes}, nil
+}
+
+// ProveKey returns the inclusion proof for key, verifiable with VerifyProof.
+func (t *Tree[K]) ProveKey(key K) (*Proof, error) {
+	index, ok := t.indexes[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrKeyNotFound, key)
+	}
+	return t.GenerateProofStruct(index)
+}
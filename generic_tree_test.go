@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type accountKey struct {
+	Region string
+	ID     int
+}
+
+func serializeAccount(k accountKey) []byte {
+	return []byte(fmt.Sprintf("%s/%d", k.Region, k.ID))
+}
+
+func TestGenericTree(t *testing.T) {
+	keys := []accountKey{{"eu", 1}, {"eu", 2}, {"us", 1}}
+	tree, err := NewGenericTree(keys, serializeAccount)
+	if err != nil {
+		t.Fatalf("NewGenericTree failed: %v", err)
+	}
+
+	for i, key := range keys {
+		proof, err := tree.ProveKey(key)
+		if err != nil {
+			t.Fatalf("ProveKey(%v) failed: %v", key, err)
+		}
+		if proof.LeafIndex != i {
+			t.Errorf("Expected %v at index %d, got %d", key, i, proof.LeafIndex)
+		}
+		// The proof binds the serialized key, so verify against its hash
+		leafHash := sha256.Sum256(serializeAccount(key))
+		isValid, err := VerifyProof(tree.Root, proof.Path, leafHash[:], proof.LeafIndex)
+		if err != nil || !isValid {
+			t.Errorf("Expected %v to verify, got %v, %v", key, isValid, err)
+		}
+	}
+
+	if _, err := tree.ProveKey(accountKey{"ap", 1}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := NewGenericTree([]accountKey{{"eu", 1}, {"eu", 1}}, serializeAccount); !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("Expected ErrDuplicateKey, got %v", err)
+	}
+	if _, err := NewGenericTree(nil, serializeAccount); !errors.Is(err, ErrEmptyMessage) {
+		t.Errorf("Expected ErrEmptyMessage, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// HashCacheEntry is a file's content hash together with the size and
+// modification time the file had when it was hashed
+type HashCacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Hash    []byte    `json:"hash"`
+}
+
+// scanHash hashes a scanned entry, reusing the hash recorded by an interrupted
+// sync's journal or in HashCache when the file's size and modification time
+// are unchanged
+func (ds *DirectorySync) scanHash(path string, file FileInfo) ([]byte, error) {
+	if isSymlink(file) {
+		return ds.hashEntry(path, file)
+	}
+	if hash, ok := ds.journaledHash(path, file); ok {
+		return slices.Clone(hash), nil
+	}
+	if ds.HashCache == nil {
+		return ds.hashEntry(path, file)
+	}
+
+	ds.mu.Lock()
+	entry, ok := ds.HashCache[path]
+	ds.mu.Unlock()
+	if ok && entry.Size == file.Size && entry.ModTime.Equal(file.LastModified) {
+		return slices.Clone(entry.Hash), nil
+	}
+
+	hash, err := ds.hashEntry(path, file)
+	if err != nil {
+		return nil, err
+	}
+	ds.mu.Lock()
+	ds.HashCache[path] = HashCacheEntry{Size: file.Size, ModTime: file.LastModified, Hash: slices.Clone(hash)}
+	ds.mu.Unlock()
+	return hash, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBuildDirectoryTreeHashCache(t *testing.T) {
+	dir := createTestDir(t, map[string]string{"a.txt": "A", "b.txt": "B", "sub/c.txt": "C"})
+
+	var mu sync.Mutex
+	var hashed []string
+	ds := &DirectorySync{
+		HashCache: make(map[string]HashCacheEntry),
+		hasher: func(path string) ([]byte, error) {
+			mu.Lock()
+			hashed = append(hashed, filepath.Base(path))
+			mu.Unlock()
+			return hashFile(path)
+		},
+	}
+	scan := func() ([]FileInfo, []string) {
+		t.Helper()
+		hashed = nil
+		files, err := ds.BuildDirectoryTree(dir)
+		if err != nil {
+			t.Fatalf("BuildDirectoryTree failed: %v", err)
+		}
+		slices.Sort(hashed)
+		return files, hashed
+	}
+
+	first, hashedFirst := scan()
+	if !slices.Equal(hashedFirst, []string{"a.txt", "b.txt", "c.txt"}) {
+		t.Fatalf("Expected every file hashed on the first scan, got %v", hashedFirst)
+	}
+	if len(ds.HashCache) != 3 {
+		t.Fatalf("Expected 3 cache entries, got %d", len(ds.HashCache))
+	}
+
+	second, hashedSecond := scan()
+	if len(hashedSecond) != 0 {
+		t.Errorf("Expected unchanged files not to be rehashed, got %v", hashedSecond)
+	}
+	for i := range first {
+		if !bytes.Equal(first[i].Hash, second[i].Hash) {
+			t.Errorf("Cached hash of %s differs from the computed one", first[i].Path)
+		}
+	}
+
+	// Same size, new mtime, and new size, same mtime
+	later := time.Now().Add(time.Hour)
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("Z"), 0644); err != nil {
+		t.Fatalf("Failed to modify fixture: %v", err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "a.txt"), later, later); err != nil {
+		t.Fatalf("Failed to set fixture mtime: %v", err)
+	}
+	cInfo, err := os.Stat(filepath.Join(dir, "sub/c.txt"))
+	if err != nil {
+		t.Fatalf("Fixture missing: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub/c.txt"), []byte("longer"), 0644); err != nil {
+		t.Fatalf("Failed to modify fixture: %v", err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "sub/c.txt"), cInfo.ModTime(), cInfo.ModTime()); err != nil {
+		t.Fatalf("Failed to set fixture mtime: %v", err)
+	}
+
+	third, hashedThird := scan()
+	if !slices.Equal(hashedThird, []string{"a.txt", "c.txt"}) {
+		t.Errorf("Expected only the changed files rehashed, got %v", hashedThird)
+	}
+	fresh, err := (&DirectorySync{}).BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+	for i := range fresh {
+		if !bytes.Equal(fresh[i].Hash, third[i].Hash) {
+			t.Errorf("Hash of %s doesn't match an uncached scan", fresh[i].Path)
+		}
+	}
+}
+
+func TestHashCachePersists(t *testing.T) {
+	dir := createTestDir(t, map[string]string{"a.txt": "A"})
+	ds := &DirectorySync{HashCache: make(map[string]HashCacheEntry)}
+	if _, err := ds.BuildDirectoryTree(dir); err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+
+	data, err := json.Marshal(ds.HashCache)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var restored map[string]HashCacheEntry
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	hashCalls := 0
+	reloaded := &DirectorySync{
+		HashCache: restored,
+		hasher: func(path string) ([]byte, error) {
+			hashCalls++
+			return hashFile(path)
+		},
+	}
+	if _, err := reloaded.BuildDirectoryTree(dir); err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+	if hashCalls != 0 {
+		t.Errorf("Expected the restored cache to skip hashing, got %d calls", hashCalls)
+	}
+}
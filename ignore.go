@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ignoreRule is one compiled IgnorePatterns entry
+type ignoreRule struct {
+	segments []string // Slash-separated glob segments; "**" spans any number of them
+	dirOnly  bool     // Pattern ended in "/" and only matches directories
+	negate   bool     // Pattern started with "!" and re-includes matches
+}
+
+// compileIgnorePatterns parses gitignore-style patterns. Blank lines and lines
+// starting with "#" are skipped. A pattern without a slash (other than a
+// trailing one) matches a name at any depth; otherwise it is anchored at the
+// scanned root.
+func compileIgnorePatterns(patterns []string) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	for _, pattern := range patterns {
+		original := pattern
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(pattern, "!") {
+			rule.negate = true
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		if pattern == "" || pattern == "/" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidIgnorePattern, original)
+		}
+		if !strings.Contains(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		pattern = strings.TrimPrefix(pattern, "/")
+
+		rule.segments = strings.Split(pattern, "/")
+		for _, segment := range rule.segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidIgnorePattern, original)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// isIgnored reports whether a slash-separated relative path is excluded by
+// rules. As in gitignore, the last matching rule wins.
+func isIgnored(rules []ignoreRule, relPath string, isDir bool) bool {
+	ignored := false
+	segments := strings.Split(relPath, "/")
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(rule.segments, segments) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegments matches path segments against glob segments, where "**"
+// matches zero or more segments, or one or more when it ends the pattern
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		if len(pattern) == 1 {
+			return len(segments) > 0
+		}
+		for i := range len(segments) + 1 {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestIsIgnored(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"*.log", "debug.log", false, true},
+		{"*.log", "a/b/debug.log", false, true},
+		{"*.log", "debug.log.txt", false, false},
+		{"node_modules/", "node_modules", true, true},
+		{"node_modules/", "web/node_modules", true, true},
+		{"node_modules/", "node_modules", false, false},
+		{"/build", "build", true, true},
+		{"/build", "src/build", true, false},
+		{"docs/*.md", "docs/readme.md", false, true},
+		{"docs/*.md", "docs/api/readme.md", false, false},
+		{"docs/**/*.md", "docs/readme.md", false, true},
+		{"docs/**/*.md", "docs/api/v1/readme.md", false, true},
+		{"**/cache", "a/b/cache", true, true},
+		{"vendor/**", "vendor", true, false},
+		{"vendor/**", "vendor/lib/x.go", false, true},
+		{"# comment", "# comment", false, false},
+		{"", "anything", false, false},
+	}
+	for _, tc := range testCases {
+		rules, err := compileIgnorePatterns([]string{tc.pattern})
+		if err != nil {
+			t.Fatalf("compileIgnorePatterns(%q) failed: %v", tc.pattern, err)
+		}
+		if got := isIgnored(rules, tc.path, tc.isDir); got != tc.want {
+			t.Errorf("Pattern %q on %q (dir %v): expected %v, got %v", tc.pattern, tc.path, tc.isDir, tc.want, got)
+		}
+	}
+
+	rules, _ := compileIgnorePatterns([]string{"*.log", "!keep.log"})
+	if isIgnored(rules, "logs/keep.log", false) || !isIgnored(rules, "logs/drop.log", false) {
+		t.Errorf("Expected a later negated pattern to re-include only its match")
+	}
+
+	for _, pattern := range []string{"[", "/", "!/"} {
+		if _, err := compileIgnorePatterns([]string{pattern}); !errors.Is(err, ErrInvalidIgnorePattern) {
+			t.Errorf("Pattern %q: expected ErrInvalidIgnorePattern, got %v", pattern, err)
+		}
+	}
+}
+
+func TestBuildDirectoryTreeIgnorePatterns(t *testing.T) {
+	dir := createTestDir(t, map[string]string{
+		"main.go":                       "package main",
+		"debug.log":                     "log",
+		".git/HEAD":                     "ref",
+		"web/node_modules/lib/index.js": "js",
+		"web/app.js":                    "app",
+		"build/out.bin":                 "bin",
+		"src/build/gen.go":              "gen",
+		"docs/guide/intro.md":           "intro",
+		"docs/guide/keep.txt":           "keep",
+	})
+
+	var mu sync.Mutex
+	var hashed []string
+	ds := &DirectorySync{
+		IgnorePatterns: []string{"# generated", ".git/", "node_modules/", "*.log", "/build", "docs/**/*.md"},
+		hasher: func(path string) ([]byte, error) {
+			mu.Lock()
+			hashed = append(hashed, path)
+			mu.Unlock()
+			return hashFile(path)
+		},
+	}
+	files, err := ds.BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+
+	paths := filePaths(files)
+	want := []string{"docs", "docs/guide", "docs/guide/keep.txt", "main.go", "src", "src/build", "src/build/gen.go", "web", "web/app.js"}
+	if !slices.Equal(paths, want) {
+		t.Errorf("Expected %v, got %v", want, paths)
+	}
+	for _, path := range hashed {
+		if strings.Contains(path, "node_modules") || strings.Contains(path, ".git") {
+			t.Errorf("Expected ignored directories to be pruned, but %s was hashed", path)
+		}
+	}
+
+	ds = &DirectorySync{IgnorePatterns: []string{"[unclosed"}}
+	if _, err := ds.BuildDirectoryTree(dir); !errors.Is(err, ErrInvalidIgnorePattern) {
+		t.Errorf("Expected ErrInvalidIgnorePattern, got %v", err)
+	}
+
+	// Ignored destination entries must survive a sync
+	src := createTestDir(t, map[string]string{"a.txt": "A"})
+	dst := createTestDir(t, map[string]string{"a.txt": "A", "local.log": "keep me"})
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, IgnorePatterns: []string{"*.log"}}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if _, err := os.Stat(dst + "/local.log"); err != nil {
+		t.Errorf("Expected ignored destination file to be kept: %v", err)
+	}
+}
+
+func TestBuildDirectoryTreeSkipDirFunc(t *testing.T) {
+	fixture := map[string]string{
+		"a/b/c/keep.txt":   "keep",
+		"a/b/small/x.txt":  "x",
+		"a/b/small/y.txt":  "y",
+		"top.txt":          "top",
+		"a/b/cache/z0.bin": "z",
+	}
+	for i := 1; i < 10; i++ {
+		fixture[fmt.Sprintf("a/b/cache/z%d.bin", i)] = "z"
+	}
+	fixture["a/b/cache/nested/deep.bin"] = "deep"
+	dir := createTestDir(t, fixture)
+
+	var mu sync.Mutex
+	var hashed []string
+	seen := make(map[string]int)
+	ds := &DirectorySync{
+		SkipDirFunc: func(path string, entries int) bool {
+			seen[path] = entries
+			return entries > 5
+		},
+		hasher: func(path string) ([]byte, error) {
+			mu.Lock()
+			hashed = append(hashed, path)
+			mu.Unlock()
+			return hashFile(path)
+		},
+	}
+	files, err := ds.BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+
+	paths := filePaths(files)
+	want := []string{"a", "a/b", "a/b/c", "a/b/c/keep.txt", "a/b/small", "a/b/small/x.txt", "a/b/small/y.txt", "top.txt"}
+	if !slices.Equal(paths, want) {
+		t.Errorf("Expected %v, got %v", want, paths)
+	}
+	if seen["a/b"] != 3 || seen["a/b/cache"] != 11 {
+		t.Errorf("Expected entry counts 3 for a/b and 11 for a/b/cache, got %v", seen)
+	}
+	if _, ok := seen["a/b/cache/nested"]; ok {
+		t.Errorf("Expected the skipped directory not to be descended into")
+	}
+	for _, path := range hashed {
+		if strings.Contains(path, "cache") {
+			t.Errorf("Expected skipped directories to be pruned, but %s was hashed", path)
+		}
+	}
+}
+
+func TestSyncSkipDirFuncAcrossSides(t *testing.T) {
+	bigCache := map[string]string{"keep.txt": "K"}
+	for i := range 6 {
+		bigCache[fmt.Sprintf("cache/big%d", i)] = "B"
+	}
+	smallCache := map[string]string{"keep.txt": "K", "cache/old1": "O", "cache/old2": "O"}
+	skipBig := func(path string, entries int) bool { return entries > 5 }
+
+	testCases := []struct {
+		name     string
+		src, dst map[string]string
+		kept     []string
+	}{
+		{"SkippedInSource", bigCache, smallCache, []string{"cache/old1", "cache/old2"}},
+		{"SkippedInDestination", smallCache, bigCache, []string{"cache/big0", "cache/big5"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src := createTestDir(t, tc.src)
+			dst := createTestDir(t, tc.dst)
+			syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, SkipDirFunc: skipBig}
+			if err := syncer.SyncDirectories(); err != nil {
+				t.Fatalf("SyncDirectories failed: %v", err)
+			}
+			for _, path := range tc.kept {
+				if _, err := os.Stat(filepath.Join(dst, path)); err != nil {
+					t.Errorf("Expected %s in the skipped directory to survive, got %v", path, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syncJournal appends the operations a sync completes to the journal file at
+// the destination, one per line:
+//
+//	copy <hex hash> <size> <mtime in unix nanoseconds> <quoted path>
+//	delete <quoted path>
+//
+// Lines that don't parse, like one cut short by a crash, are ignored.
+type syncJournal struct {
+	path string
+	file *os.File
+	mu   sync.Mutex // serializes appends from parallel copy workers
+
+	// copied holds the copies recorded by earlier, interrupted syncs by full
+	// destination path
+	copied map[string]HashCacheEntry
+}
+
+// openJournal loads the journal in destRoot left by an interrupted sync, if
+// any, and opens it for appending
+func openJournal(destRoot string) (*syncJournal, error) {
+	path := filepath.Join(destRoot, journalName)
+	j := &syncJournal{path: path, copied: make(map[string]HashCacheEntry)}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(line, " ", 5)
+		if len(fields) != 5 || fields[0] != "copy" {
+			continue
+		}
+		hash, err := hex.DecodeString(fields[1])
+		if err != nil {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		modTime, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		relPath, err := strconv.Unquote(fields[4])
+		if err != nil {
+			continue
+		}
+		j.copied[filepath.Join(destRoot, filepath.FromSlash(relPath))] = HashCacheEntry{
+			Size:    size,
+			ModTime: time.Unix(0, modTime),
+			Hash:    hash,
+		}
+	}
+
+	j.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// append writes one line to the journal
+func (j *syncJournal) append(format string, args ...any) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err := fmt.Fprintf(j.file, format, args...)
+	return err
+}
+
+// remove deletes the journal once the sync it belongs to has completed
+func (j *syncJournal) remove() error {
+	j.file.Close()
+	if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// journalCopy records that file was copied to the destination
+func (ds *DirectorySync) journalCopy(file FileInfo) error {
+	if ds.journal == nil {
+		return nil
+	}
+	err := ds.journal.append("copy %s %d %d %s\n",
+		hex.EncodeToString(file.Hash), file.Size, file.LastModified.UnixNano(), strconv.Quote(file.Path))
+	if err != nil {
+		return fmt.Errorf("error writing journal: %v", err)
+	}
+	return nil
+}
+
+// journalDelete records that path was deleted from the destination
+func (ds *DirectorySync) journalDelete(path string) error {
+	if ds.journal == nil {
+		return nil
+	}
+	if err := ds.journal.append("delete %s\n", strconv.Quote(path)); err != nil {
+		return fmt.Errorf("error writing journal: %v", err)
+	}
+	return nil
+}
+
+// journaledHash returns the hash an interrupted sync recorded for the
+// destination file at path, if the file still has the size and modification
+// time it was copied with
+func (ds *DirectorySync) journaledHash(path string, file FileInfo) ([]byte, bool) {
+	if ds.journal == nil {
+		return nil, false
+	}
+	entry, ok := ds.journal.copied[path]
+	if !ok || entry.Size != file.Size || !entry.ModTime.Equal(file.LastModified) {
+		return nil, false
+	}
+	return entry.Hash, true
+}
+
+// removeJournal deletes the journal of a sync that completed
+func (ds *DirectorySync) removeJournal() error {
+	if ds.journal == nil {
+		return nil
+	}
+	if err := ds.journal.remove(); err != nil {
+		return fmt.Errorf("error removing journal: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncJournalResumesAfterInterruption(t *testing.T) {
+	fixture := make(map[string]string)
+	for i := range 6 {
+		fixture[fmt.Sprintf("f%d.txt", i)] = fmt.Sprintf("content-%d", i)
+	}
+	src := createTestDir(t, fixture)
+	dst := createTestDir(t, map[string]string{"stale.txt": "S"})
+
+	// Simulate a crash after three copies
+	errKilled := errors.New("killed")
+	copies := 0
+	crashingCopier := func(src, dst string, mode os.FileMode) error {
+		if copies == 3 {
+			return errKilled
+		}
+		copies++
+		return copyFile(src, dst, mode)
+	}
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, Journal: true, CopyConcurrency: 1, copier: crashingCopier}
+	if err := syncer.SyncDirectories(); err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Fatalf("Expected the simulated crash, got %v", err)
+	}
+	journal, err := os.ReadFile(filepath.Join(dst, journalName))
+	if err != nil {
+		t.Fatalf("Expected a journal after the interruption: %v", err)
+	}
+	if lines := strings.Count(string(journal), "\ncopy ") + 1; !strings.HasPrefix(string(journal), "copy ") || lines != 3 {
+		t.Fatalf("Expected 3 journaled copies, got:\n%s", journal)
+	}
+
+	// A journaled file that changed in the source since must be copied again
+	later := time.Now().Add(time.Hour)
+	if err := os.WriteFile(filepath.Join(src, "f1.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatalf("Failed to modify fixture: %v", err)
+	}
+	if err := os.Chtimes(filepath.Join(src, "f1.txt"), later, later); err != nil {
+		t.Fatalf("Failed to set fixture mtime: %v", err)
+	}
+
+	var mu sync.Mutex
+	var copied, hashedDest []string
+	syncer = &DirectorySync{
+		SourceDir:        src,
+		DestinationDir:   dst,
+		Journal:          true,
+		VerifyOnComplete: true,
+		copier: func(src, dst string, mode os.FileMode) error {
+			mu.Lock()
+			copied = append(copied, filepath.Base(src))
+			mu.Unlock()
+			return copyFile(src, dst, mode)
+		},
+		hasher: func(path string) ([]byte, error) {
+			if strings.HasPrefix(path, dst) {
+				mu.Lock()
+				hashedDest = append(hashedDest, filepath.Base(path))
+				mu.Unlock()
+			}
+			return hashFile(path)
+		},
+	}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("Resumed sync failed: %v", err)
+	}
+
+	slices.Sort(copied)
+	if want := []string{"f1.txt", "f3.txt", "f4.txt", "f5.txt"}; !slices.Equal(copied, want) {
+		t.Errorf("Expected only the remaining copies %v, got %v", want, copied)
+	}
+	for _, name := range hashedDest {
+		if name == "f0.txt" || name == "f2.txt" {
+			t.Errorf("Expected journaled copy %s not to be rehashed", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "stale.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected stale.txt to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, journalName)); !os.IsNotExist(err) {
+		t.Errorf("Expected the journal to be removed after completion, got %v", err)
+	}
+}
+
+func TestSyncJournalListsCopiesFinishedBeforeCancel(t *testing.T) {
+	fixture := make(map[string]string)
+	for i := range 6 {
+		fixture[fmt.Sprintf("f%d.txt", i)] = fmt.Sprintf("content-%d", i)
+	}
+	src := createTestDir(t, fixture)
+	dst := t.TempDir()
+
+	// Cancel once the third copy has finished, as Ctrl-C would
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var copied []string
+	syncer := &DirectorySync{
+		SourceDir:       src,
+		DestinationDir:  dst,
+		Journal:         true,
+		CopyConcurrency: 1,
+		copier: func(src, dst string, mode os.FileMode) error {
+			if err := copyFile(src, dst, mode); err != nil {
+				return err
+			}
+			if copied = append(copied, filepath.Base(src)); len(copied) == 3 {
+				cancel()
+			}
+			return nil
+		},
+	}
+	if err := syncer.SyncDirectoriesContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	journal, err := openJournal(dst)
+	if err != nil {
+		t.Fatalf("openJournal failed: %v", err)
+	}
+	defer journal.file.Close()
+	var journaled []string
+	for path := range journal.copied {
+		journaled = append(journaled, filepath.Base(path))
+	}
+	slices.Sort(journaled)
+	if !slices.Equal(journaled, copied) {
+		t.Errorf("Expected the journal to list exactly the finished copies %v, got %v", copied, journaled)
+	}
+}
+
+func TestSyncJournalIgnoresTornLines(t *testing.T) {
+	dst := t.TempDir()
+	content := "copy 00ff 3 100 \"a.txt\"\ncopy zz 1 1 \"b.txt\"\ndelete \"c.txt\"\ncopy 00ff 3 100 \"d.t"
+	if err := os.WriteFile(filepath.Join(dst, journalName), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write journal: %v", err)
+	}
+	journal, err := openJournal(dst)
+	if err != nil {
+		t.Fatalf("openJournal failed: %v", err)
+	}
+	defer journal.file.Close()
+
+	entry, ok := journal.copied[filepath.Join(dst, "a.txt")]
+	if len(journal.copied) != 1 || !ok || entry.Size != 3 || entry.ModTime.UnixNano() != 100 {
+		t.Errorf("Expected only a.txt to be loaded, got %v", journal.copied)
+	}
+}
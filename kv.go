@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// KeyValue is a single entry of a key/value tree
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// NewTreeKV creates a Merkle Tree whose leaves bind each key to its value.
+// Like NewTree, it assumes pairs are already deterministically ordered
+// (e.g. sorted by key). Proofs are verified with VerifyKV.
+func NewTreeKV(pairs []KeyValue) (*MerkleTree, error) {
+	dataBlocks := make([][]byte, len(pairs))
+	for i, pair := range pairs {
+		dataBlocks[i] = kvLeafData(pair.Key, pair.Value)
+	}
+	return NewTree(dataBlocks)
+}
+
+// VerifyKV checks that key and value are stored together at index in the
+// tree with the given root, so a value cannot be proven under another key.
+// The proof comes from GenerateProof on a tree built by NewTreeKV.
+func VerifyKV(root []byte, proof [][]byte, key, value []byte, index int) (bool, error) {
+	leafHash := sha256.Sum256(kvLeafData(key, value))
+	return VerifyProof(root, proof, leafHash[:], index)
+}
+
+// kvLeafData encodes a pair as len(key) || key || value. The length prefix
+// keeps the boundary between key and value unambiguous, so ("ab", "c") and
+// ("a", "bc") produce different leaves.
+func kvLeafData(key, value []byte) []byte {
+	data := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(key)+len(value)), uint64(len(key)))
+	data = append(data, key...)
+	return append(data, value...)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyKV(t *testing.T) {
+	pairs := []KeyValue{
+		{Key: []byte("alice"), Value: []byte("100")},
+		{Key: []byte("bob"), Value: []byte("250")},
+		{Key: []byte("carol"), Value: []byte("75")},
+	}
+	tree, err := NewTreeKV(pairs)
+	if err != nil {
+		t.Fatalf("NewTreeKV failed: %v", err)
+	}
+
+	for i, pair := range pairs {
+		proof, _, _ := tree.GenerateProof(i)
+		isValid, err := VerifyKV(tree.Root, proof, pair.Key, pair.Value, i)
+		if err != nil || !isValid {
+			t.Errorf("Expected %s to verify, got %v, %v", pair.Key, isValid, err)
+		}
+	}
+
+	proof, _, _ := tree.GenerateProof(1)
+	testCases := []struct {
+		name       string
+		key, value string
+	}{
+		{"WrongKey", "alice", "250"},
+		{"WrongValue", "bob", "251"},
+		// Shifting bytes between key and value must not produce the same leaf
+		{"ShiftedBoundary", "bob2", "50"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			isValid, err := VerifyKV(tree.Root, proof, []byte(tc.key), []byte(tc.value), 1)
+			if err != nil || isValid {
+				t.Errorf("Expected (%s, %s) to be rejected, got %v, %v", tc.key, tc.value, isValid, err)
+			}
+		})
+	}
+
+	if _, err := NewTreeKV(nil); !errors.Is(err, ErrEmptyMessage) {
+		t.Errorf("Expected ErrEmptyMessage, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import "sync"
+
+// LazyTree is a Merkle Tree that only hashes its leaves on construction.
+// Internal nodes are computed the first time Root or GenerateProof is called
+// and cached for later calls, trading a first-call cost for cheap builds when
+// most trees never need a proof. It is safe for concurrent use.
+type LazyTree struct {
+	leaves [][]byte
+
+	once sync.Once
+	tree *MerkleTree
+	err  error
+}
+
+// NewLazyTree hashes the ordered data blocks into leaves without building
+// the levels above them.
+func NewLazyTree(dataBlocks [][]byte) (*LazyTree, error) {
+	if len(dataBlocks) == 0 {
+		return nil, ErrEmptyMessage
+	}
+	return &LazyTree{leaves: hashLeaves(dataBlocks, TreeOptions{})}, nil
+}
+
+// GetLeaves returns the ordered slice of leaf hashes.
+func (t *LazyTree) GetLeaves() [][]byte {
+	if t == nil {
+		return nil
+	}
+	return (&MerkleTree{Leaves: t.leaves}).GetLeaves()
+}
+
+// Root returns the root hash, computing the tree levels on first use.
+func (t *LazyTree) Root() ([]byte, error) {
+	tree, err := t.build()
+	if err != nil {
+		return nil, err
+	}
+	return tree.GetRoot(), nil
+}
+
+// GenerateProof creates the Merkle proof for the leaf at leafIndex, computing
+// the tree levels on first use. See MerkleTree.GenerateProof.
+func (t *LazyTree) GenerateProof(leafIndex int) (proofPath [][]byte, leafHash []byte, err error) {
+	tree, err := t.build()
+	if err != nil {
+		return nil, nil, err
+	}
+	return tree.GenerateProof(leafIndex)
+}
+
+// build computes and caches the full tree exactly once.
+func (t *LazyTree) build() (*MerkleTree, error) {
+	if t == nil {
+		return nil, ErrNilTree
+	}
+	t.once.Do(func() {
+		t.tree, t.err = newTreeFromLeaves(t.leaves, TreeOptions{})
+	})
+	return t.tree, t.err
+}
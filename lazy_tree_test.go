@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestLazyTreeMatchesEagerTree(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		items := make([]string, size)
+		for i := range items {
+			items[i] = fmt.Sprintf("block-%d", i)
+		}
+		blocks := createTestDataBlocks(items...)
+
+		eager, err := NewTree(blocks)
+		if err != nil {
+			t.Fatalf("NewTree failed: %v", err)
+		}
+		lazy, err := NewLazyTree(blocks)
+		if err != nil {
+			t.Fatalf("NewLazyTree failed: %v", err)
+		}
+		if lazy.tree != nil {
+			t.Fatalf("Expected no levels to be computed before first use")
+		}
+
+		for index := range size {
+			lazyProof, lazyLeaf, err := lazy.GenerateProof(index)
+			if err != nil {
+				t.Fatalf("LazyTree.GenerateProof failed: %v", err)
+			}
+			eagerProof, eagerLeaf, _ := eager.GenerateProof(index)
+			if !bytes.Equal(lazyLeaf, eagerLeaf) || !slices.EqualFunc(lazyProof, eagerProof, bytes.Equal) {
+				t.Errorf("Size %d, index %d: lazy proof differs from eager proof", size, index)
+			}
+		}
+
+		cached := lazy.tree
+		root, err := lazy.Root()
+		if err != nil {
+			t.Fatalf("LazyTree.Root failed: %v", err)
+		}
+		if !bytes.Equal(root, eager.Root) {
+			t.Errorf("Size %d: lazy root %x differs from eager root %x", size, root, eager.Root)
+		}
+		if lazy.tree != cached {
+			t.Errorf("Expected cached levels to be reused")
+		}
+	}
+
+	t.Run("EmptyInput", func(t *testing.T) {
+		if _, err := NewLazyTree(nil); !errors.Is(err, ErrEmptyMessage) {
+			t.Errorf("Expected ErrEmptyMessage, got %v", err)
+		}
+	})
+}
+
+func BenchmarkBuildWithoutProof(b *testing.B) {
+	blocks := make([][]byte, 10000)
+	for i := range blocks {
+		blocks[i] = []byte(fmt.Sprintf("block-%d", i))
+	}
+
+	b.Run("Eager", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := NewTree(blocks); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Lazy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := NewLazyTree(blocks); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package main
+
+import "bytes"
+
+// FindLeafIndex returns the index of the first leaf equal to leafHash, for use
+// with GenerateProof, and whether one was found. The first call indexes every
+// leaf, so later lookups take constant time; the index follows AppendLeaf and
+// UpdateLeaf but not direct changes to Leaves.
+func (t *MerkleTree) FindLeafIndex(leafHash []byte) (int, bool) {
+	if t == nil {
+		return 0, false
+	}
+	if t.leafIndexesMu == nil {
+		for i, leaf := range t.Leaves {
+			if bytes.Equal(leaf, leafHash) {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+	t.leafIndexesMu.Lock()
+	defer t.leafIndexesMu.Unlock()
+
+	if t.leafIndexes == nil {
+		t.leafIndexes = make(map[string]int, len(t.Leaves))
+		for i, leaf := range t.Leaves {
+			if _, exists := t.leafIndexes[string(leaf)]; !exists {
+				t.leafIndexes[string(leaf)] = i
+			}
+		}
+	}
+	index, ok := t.leafIndexes[string(leafHash)]
+	return index, ok
+}
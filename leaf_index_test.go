@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestFindLeafIndex(t *testing.T) {
+	tree, _ := NewTree(createTestDataBlocks("A", "B", "C", "B", "D"))
+	hashOf := func(data string) []byte {
+		hash := sha256.Sum256([]byte(data))
+		return hash[:]
+	}
+
+	testCases := []struct {
+		name  string
+		data  string
+		index int
+		found bool
+	}{
+		{"First", "A", 0, true},
+		{"Last", "D", 4, true},
+		{"DuplicateReturnsFirst", "B", 1, true},
+		{"Absent", "Z", 0, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			index, found := tree.FindLeafIndex(hashOf(tc.data))
+			if index != tc.index || found != tc.found {
+				t.Errorf("Expected (%d, %v), got (%d, %v)", tc.index, tc.found, index, found)
+			}
+		})
+	}
+
+	// The index follows updates and appends
+	if err := tree.UpdateLeaf(1, []byte("E")); err != nil {
+		t.Fatalf("UpdateLeaf failed: %v", err)
+	}
+	if err := tree.AppendLeaf([]byte("F")); err != nil {
+		t.Fatalf("AppendLeaf failed: %v", err)
+	}
+	if index, _ := tree.FindLeafIndex(hashOf("B")); index != 3 {
+		t.Errorf("Expected the remaining B at index 3, got %d", index)
+	}
+	if index, found := tree.FindLeafIndex(hashOf("F")); !found || index != 5 {
+		t.Errorf("Expected the appended leaf at index 5, got (%d, %v)", index, found)
+	}
+
+	var nilTree *MerkleTree
+	if _, found := nilTree.FindLeafIndex(hashOf("A")); found {
+		t.Errorf("Expected a nil tree to contain nothing")
+	}
+}
+
+func TestFindLeafIndexOnCopiedTree(t *testing.T) {
+	tree, err := NewTree(createTestDataBlocks("A", "B", "C"))
+	if err != nil {
+		t.Fatalf("NewTree failed: %v", err)
+	}
+	copied := *tree
+	literal := &MerkleTree{Leaves: tree.Leaves}
+	for _, tr := range []*MerkleTree{tree, &copied, literal} {
+		if index, found := tr.FindLeafIndex(tree.Leaves[2]); !found || index != 2 {
+			t.Errorf("Expected leaf C at index 2, got (%d, %v)", index, found)
+		}
+	}
+}
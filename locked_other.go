@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package main
+
+// isLockedError reports whether err means the file is locked. Platforms without
+// file locking never report locked files.
+func isLockedError(err error) bool {
+	return false
+}
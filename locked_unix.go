@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isLockedError reports whether err means another process holds a lock on the file.
+// On Unix only mandatory locks and busy executables block access.
+func isLockedError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK) || errors.Is(err, syscall.ETXTBSY)
+}
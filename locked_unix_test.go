@@ -0,0 +1,48 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+func TestSyncSkipLocked(t *testing.T) {
+	src := createTestDir(t, map[string]string{"locked.txt": "new", "free.txt": "F"})
+	dst := createTestDir(t, map[string]string{"locked.txt": "old"})
+
+	// Simulate a file held under a mandatory lock
+	hasher := func(path string) ([]byte, error) {
+		if filepath.Base(path) == "locked.txt" && filepath.Dir(path) == src {
+			return nil, &os.PathError{Op: "open", Path: path, Err: syscall.EAGAIN}
+		}
+		return hashFile(path)
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, hasher: hasher}
+		if err := syncer.SyncDirectories(); err == nil {
+			t.Fatalf("Expected locked file to abort the sync")
+		}
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, SkipLocked: true, hasher: hasher}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+		if !slices.Equal(syncer.SkippedLocked, []string{"locked.txt"}) {
+			t.Errorf("Expected locked.txt to be reported, got %v", syncer.SkippedLocked)
+		}
+		if _, err := os.Stat(filepath.Join(dst, "free.txt")); err != nil {
+			t.Errorf("Expected free.txt to be copied: %v", err)
+		}
+		content, err := os.ReadFile(filepath.Join(dst, "locked.txt"))
+		if err != nil || string(content) != "old" {
+			t.Errorf("Expected destination copy of locked.txt to be untouched, got %q, %v", content, err)
+		}
+	})
+}
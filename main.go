@@ -2,253 +2,1760 @@ package main
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
 	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
+const (
+	// exitInterrupted is the conventional exit code for a process stopped by SIGINT
+	exitInterrupted = 130
+
+	// defaultCopyConcurrency is the number of parallel copies when unset
+	defaultCopyConcurrency = 4
+
+	// defaultSmallFileThreshold is the batching size cutoff when unset
+	defaultSmallFileThreshold = 64 * 1024
+
+	// defaultDeltaThreshold is the similarity from which DeltaCopy patches files when unset
+	defaultDeltaThreshold = 0.5
+
+	// rootXattrName is the extended attribute holding a stamped source root
+	rootXattrName = "user.merkle.root"
+)
+
+var (
+	ErrRootMismatch           = errors.New("directorySync: local root does not match trusted root")
+	ErrSyncVerificationFailed = errors.New("directorySync: destination does not match source after sync")
+	ErrUnsafePath             = errors.New("directorySync: path escapes destination directory")
+	ErrProofCount             = errors.New("directorySync: expected one proof per file")
+	ErrPathCollision          = errors.New("directorySync: several source paths map to the same destination path")
+	ErrCopyFailed             = errors.New("directorySync: some files could not be copied, deletions were skipped")
+	ErrInvalidManifest        = errors.New("directorySync: malformed manifest")
+	ErrXattrUnsupported       = errors.New("directorySync: extended attributes are not supported on this platform")
+	ErrInvalidIgnorePattern   = errors.New("directorySync: invalid ignore pattern")
+	ErrCopyVerification       = errors.New("directorySync: copied file does not match its source hash")
+	ErrRemoteListing          = errors.New("directorySync: remote listing does not match the remote root")
+	ErrSyncConflict           = errors.New("directorySync: destination files are newer than the source")
+	ErrIncompatibleOptions    = errors.New("directorySync: options cannot be combined")
+)
+
 // DirectorySync uses Merkle trees to efficiently sync directories
 type DirectorySync struct {
 	SourceDir      string
 	DestinationDir string
+
+	// Mode selects between mirroring the source (SyncMirror, the default) and
+	// merging both directories without deleting anything (SyncMerge).
+	// Batching, deduplication, QuiescenceWindow and VerifyOnComplete only
+	// apply when mirroring.
+	Mode SyncMode
+
+	// DestPrefix mirrors the source into this relative subdirectory of
+	// DestinationDir (e.g. "backup-2024"). Scanning and deletions are scoped
+	// to the prefix, so destination content outside it is never touched.
+	DestPrefix string
+
+	// TreeOptions controls how Merkle trees are built for comparison.
+	// Both directories are always built with the same options.
+	TreeOptions TreeOptions
+
+	// DedupeByHash hard-links destination files whose content hash was already
+	// copied during this sync instead of copying the bytes again.
+	DedupeByHash bool
+
+	// BatchSmallFiles streams files smaller than SmallFileThreshold into a single
+	// archive at the destination and unpacks it, instead of copying them one by one.
+	BatchSmallFiles bool
+
+	// SmallFileThreshold is the size below which files are batched. Zero means
+	// defaultSmallFileThreshold.
+	SmallFileThreshold int64
+
+	// HashConcurrency bounds how many files are hashed in parallel while scanning.
+	// Hashing is CPU-bound, so zero means runtime.NumCPU().
+	HashConcurrency int
+
+	// CopyConcurrency bounds how many files are copied in parallel. Copying is
+	// I/O-bound, so zero means a small constant (defaultCopyConcurrency).
+	CopyConcurrency int
+
+	// SkipLocked skips files that are open or locked by another process instead
+	// of aborting the sync. Skipped paths are reported in SkippedLocked.
+	SkipLocked bool
+
+	// SkippedLocked lists the relative paths skipped by the last sync because
+	// they were locked. It is only populated when SkipLocked is set.
+	SkippedLocked []string
+
+	// Events, when set, receives a SyncEvent for every discovered, hashed, copied
+	// and deleted path, plus an EventError if the sync fails. Sends block, so the
+	// consumer must keep draining; the channel is closed when the sync returns,
+	// which means a channel can only be used for a single sync. Only syncs emit
+	// events; scans for Plan, Explain, ExportPatch and the like don't.
+	Events chan<- SyncEvent
+
+	// OnProgress, when set, is called with the same events as Events, each
+	// carrying a running Count per event type, and replaces the progress lines
+	// a sync otherwise prints. Calls are serialized, so the callback does not
+	// need to be safe for concurrent use, but it should return quickly since
+	// scanning and copying workers wait for it.
+	OnProgress func(event SyncEvent)
+
+	// DecompressBeforeHash hashes the decompressed contents of .gz files, so a
+	// file and its gzipped form get the same hash. This changes leaf semantics:
+	// two .gz files with equal contents but different compression compare equal
+	// and are not recopied, and roots differ from those built without it.
+	// .gz files that are not valid gzip are hashed as they are.
+	DecompressBeforeHash bool
+
+	// PreserveSparse recreates runs of zero bytes as holes in destination files,
+	// so sparse files (like VM disk images) don't balloon to their apparent size.
+	// It cannot be combined with ResumeLargeFiles.
+	PreserveSparse bool
+
+	// ResumeLargeFiles copies files of at least LargeFileThreshold bytes through a
+	// checkpointed partial file, so a failed copy resumes on the next attempt
+	// instead of restarting. Partial files and checkpoints are left out of scans
+	// on both sides, so they are neither synced nor deleted as destination
+	// extras, and are removed once their file is copied or deleted. Syncs
+	// setting both this and PreserveSparse fail with ErrIncompatibleOptions.
+	ResumeLargeFiles bool
+
+	// LargeFileThreshold is the size from which copies are resumable. Zero means
+	// defaultLargeFileThreshold.
+	LargeFileThreshold int64
+
+	// VerifyOnComplete re-scans the destination after the sync and returns
+	// ErrSyncVerificationFailed, listing the mismatched paths, if its root does
+	// not equal the source root. This catches copies that silently went wrong.
+	VerifyOnComplete bool
+
+	// DirectoryLoops lists the relative paths of directories skipped while
+	// scanning because they had already been visited (e.g. via bind mounts).
+	DirectoryLoops []string
+
+	// Unstable lists the relative paths of files that kept changing while they
+	// were hashed during a scan, even after one re-hash. Their listed size and
+	// modification time may not match their hash, so callers may want to scan
+	// again once they have settled.
+	Unstable []string
+
+	// MaxOpenFiles bounds how many copies may hold their source and destination
+	// files open at once across all workers, independent of CopyConcurrency,
+	// to stay under the process file-descriptor limit. Zero means unlimited.
+	MaxOpenFiles int
+
+	// StampRootXattr writes the source root into the user.merkle.root extended
+	// attribute of the destination directory after a successful sync, so other
+	// tools can read it with ReadStampedRoot. It is a no-op on platforms and
+	// file systems without extended attributes.
+	StampRootXattr bool
+
+	// QuiescenceWindow defers copying files modified less than this long ago,
+	// since they are likely still being written; they are picked up by a later
+	// sync once they settle. Deferred paths are reported in Deferred.
+	QuiescenceWindow time.Duration
+
+	// Deferred lists the relative paths the last sync left for later because
+	// they changed within QuiescenceWindow.
+	Deferred []string
+
+	// ConflictPolicy decides whether mirroring overwrites destination files
+	// modified more recently than their source. Merging always keeps the
+	// newer side.
+	ConflictPolicy ConflictPolicy
+
+	// Conflicts lists the relative paths the last sync left alone under
+	// ConflictSkipIfDestNewer because the destination file was newer, or,
+	// when merging, because the two sides hold entries of different types.
+	Conflicts []string
+
+	// LeafEncoder turns each entry into the data block hashed as its leaf by
+	// BuildMerkleTree, e.g. to bind paths or modes into the root. Nil means
+	// the content hash for files and the hashed path for directories. Trees
+	// are only comparable when both sides use the same encoder.
+	LeafEncoder func(FileInfo) []byte
+
+	// ContinueOnError keeps copying the remaining files when a copy fails
+	// instead of stopping at the first failure. Failed paths are reported in
+	// FailedCopies and the sync still returns ErrCopyFailed.
+	ContinueOnError bool
+
+	// FailedCopies lists the relative paths whose copy failed during the last
+	// sync. It is only populated when ContinueOnError is set.
+	FailedCopies []string
+
+	// PathMapper, when set, maps each source path to the path it is synced to
+	// below the destination (e.g. path.Base to flatten a tree). Returning ""
+	// leaves the entry out of the sync. Comparisons and deletions happen on
+	// mapped paths, and two files mapping to the same path are an error.
+	PathMapper func(relPath string) string
+
+	// CaseInsensitivePaths compares source and destination paths by their
+	// case folded form, for destinations on case-insensitive file systems
+	// (macOS, Windows): "Readme.md" and "README.md" are the same entry, so
+	// neither is copied nor deleted because of case alone. Files keep their
+	// original paths for every copy and delete, and source paths differing
+	// only in case are reported as an ErrPathCollision.
+	CaseInsensitivePaths bool
+
+	// IgnoreDirectories leaves directory entries out of trees and comparisons,
+	// so only file paths and contents matter: empty directories are neither
+	// created nor deleted, and parents are created as files are copied.
+	IgnoreDirectories bool
+
+	// IgnorePatterns excludes matching entries from every scan, using gitignore
+	// syntax: "*.log" matches at any depth, "/build" only at the root, "dir/"
+	// only directories, "**" spans directories and "!" re-includes a path.
+	// Ignored directories are not descended into, so their contents are never
+	// hashed, and ignored destination entries are never deleted.
+	IgnorePatterns []string
+
+	// SkipDirFunc, when set, is called for every directory found while
+	// scanning with its slash-separated relative path and number of direct
+	// entries. Returning true leaves the directory and its contents out of
+	// the scan, like an ignore pattern, so giant directories (e.g. caches)
+	// can be skipped without hashing them. A directory skipped in the source
+	// is skipped in the destination too, and skipped directories are never
+	// deleted.
+	SkipDirFunc func(path string, entries int) bool
+
+	// FollowSymlinks scans the files and directories symbolic links point to as
+	// if they were stored at the link's path. By default links are recorded as
+	// links, hashed by their target path, and recreated as links at the
+	// destination. Links that would lead back into a directory they were
+	// reached through are skipped and reported in DirectoryLoops.
+	FollowSymlinks bool
+
+	// CacheLeafHashes remembers the leaf hash computed for each content hash
+	// (and directory path) across BuildMerkleTree calls on this DirectorySync,
+	// so rebuilding after files are added or removed only hashes new leaves.
+	// The cache grows with the distinct contents seen and is never pruned.
+	CacheLeafHashes bool
+
+	// VerifyAfterCopy re-hashes every copied file at the destination and fails
+	// the copy with ErrCopyVerification if it doesn't match the source hash,
+	// catching corruption that a later sync would otherwise only notice by chance.
+	VerifyAfterCopy bool
+
+	// AtomicCopy writes each copy to a temporary file in the destination
+	// directory, flushes it to disk and renames it into place, so an
+	// interrupted sync never leaves a partially written file at a synced path.
+	AtomicCopy bool
+
+	// DeltaCopy rebuilds a changed file that already exists at the destination
+	// from the content-defined chunks it shares with the source, reading only
+	// the other chunks from the source, when FileSimilarity rates the two at
+	// least DeltaThreshold similar; other files are copied whole. The rebuilt
+	// file is written through a temporary file like any atomic copy. It is
+	// ignored with PreserveSparse and for resumable copies, which always copy
+	// the whole file.
+	DeltaCopy bool
+
+	// DeltaThreshold is the FileSimilarity score from which DeltaCopy rebuilds
+	// a file from its destination copy. Zero means defaultDeltaThreshold.
+	DeltaThreshold float64
+
+	// DetectDuplicates makes BuildDirectoryTree and source scans report files
+	// with identical contents in Duplicates, e.g. to find candidates for
+	// deduplication.
+	DetectDuplicates bool
+
+	// Duplicates maps the hex content hash of every set of files sharing the
+	// same contents to their paths, for the directory most recently scanned by
+	// BuildDirectoryTree or the source of the most recent sync. Destination
+	// scans leave it alone. It is only populated when DetectDuplicates is set.
+	Duplicates map[string][]string
+
+	// HashCache, when non-nil, remembers the content hash of every file
+	// scanned, keyed by its full path, and BuildDirectoryTree reuses it
+	// instead of rehashing files whose size and modification time are
+	// unchanged. Callers can persist it (e.g. as JSON) between runs. Entries
+	// of removed files are kept, and hashes are only valid for the same
+	// hashing options (like DecompressBeforeHash) they were computed with.
+	HashCache map[string]HashCacheEntry
+
+	// Journal records every file copied and path deleted while mirroring in
+	// a .merkle-journal file at the destination. If the sync is interrupted,
+	// the next one trusts the recorded hashes of copied files that still have
+	// the size and modification time they were copied with instead of
+	// rehashing them, so only the remaining operations cost any work. The
+	// journal is removed once a sync completes.
+	Journal bool
+
+	// PreserveOwnership gives copied files and links the owner and group of
+	// their source, and keeps the setuid, setgid and sticky bits that a change
+	// of owner would clear. Changing the owner usually requires privileges.
+	// It has no effect on non-Unix platforms.
+	PreserveOwnership bool
+
+	// RateLimitBytesPerSec caps the combined read rate of all copies running
+	// at once, so a sync over a slow or metered link leaves bandwidth for
+	// others. Copies made for PreserveSparse and ResumeLargeFiles are not
+	// limited. Zero means unlimited.
+	RateLimitBytesPerSec int64
+
+	// MaxRetries retries a file copy or deletion that fails with a transient
+	// error (a timeout, EAGAIN or EINTR, as network file systems sometimes
+	// return) up to this many times before giving up. Permanent errors like
+	// permission denied fail immediately. Zero means no retries.
+	MaxRetries int
+
+	// RetryBackoff is the wait before the first retry, doubled for each
+	// further retry.
+	RetryBackoff time.Duration
+
+	// DefaultDirMode is used for destination directories whose source mode
+	// is unknown (e.g. implicitly created parents). Zero means 0755.
+	DefaultDirMode os.FileMode
+
+	// hasher and copier replace hashFile and copyFile when set (used by tests)
+	hasher func(path string) ([]byte, error)
+	copier func(src, dst string, mode os.FileMode) error
+
+	// identifier replaces identifyFile when set (used by tests)
+	identifier func(info os.FileInfo) (fileID, bool)
+
+	openFiles chan struct{} // semaphore enforcing MaxOpenFiles during a sync
+
+	limiter *rateLimiter // shared by every copy, when RateLimitBytesPerSec is set
+
+	sourcePaths map[string]string // original source path by mapped path, when PathMapper is set
+
+	skippedDirs map[string]bool // directories left out by SkipDirFunc since the source scan
+
+	leafCache     map[string][]byte // leaf hashes by data block, when CacheLeafHashes is set
+	leafCacheOpts TreeOptions       // options the cached leaf hashes were computed with
+
+	journal *syncJournal // progress of the running sync, when Journal is set
+
+	eventCounts map[SyncEventType]int // events emitted so far, for SyncEvent.Count
+	syncing     bool                  // set while SyncDirectoriesContext runs, so only syncs emit events
+
+	dirModes map[string]os.FileMode // modes makeDir left to apply once a directory's contents are in place
+
+	mu         sync.Mutex // guards reports written by parallel workers
+	progressMu sync.Mutex // serializes OnProgress calls
 }
 
 // FileInfo stores metadata about a file used for syncing
 type FileInfo struct {
-	Path         string    // Relative path from root directory
-	Size         int64     // File size in bytes
-	LastModified time.Time // Last modification time
-	IsDir        bool      // Is this a directory
-	Hash         []byte    // Hash of file contents (nil for directories)
+	Path         string      // Relative path from root directory
+	Size         int64       // File size in bytes
+	LastModified time.Time   // Last modification time
+	IsDir        bool        // Is this a directory
+	Mode         os.FileMode // File mode and permission bits
+	Hash         []byte      // Hash of file contents, or of the target for links (nil for directories)
 }
 
 // BuildDirectoryTree scans a directory and builds a list of FileInfo
 func (ds *DirectorySync) BuildDirectoryTree(rootDir string) ([]FileInfo, error) {
+	files, err := ds.buildDirectoryTree(context.Background(), rootDir)
+	if err != nil {
+		return nil, err
+	}
+	if ds.DetectDuplicates {
+		ds.Duplicates = FindDuplicates(files)
+	}
+	return files, nil
+}
+
+// buildDirectoryTree scans like BuildDirectoryTree, stopping the walk and the
+// hashing once ctx is cancelled and returning ctx.Err()
+func (ds *DirectorySync) buildDirectoryTree(ctx context.Context, rootDir string) ([]FileInfo, error) {
+	ignoreRules, err := compileIgnorePatterns(ds.IgnorePatterns)
+	if err != nil {
+		return nil, err
+	}
+
 	var files []FileInfo
+	var fullPaths []string
+
+	// walk scans walkRoot, reporting entries below prefix. Followed directory
+	// links are walked recursively with the resolved directories of the links
+	// that led to them in ancestors.
+	var walk func(walkRoot, prefix string, ancestors []string) error
+	walk = func(walkRoot, prefix string, ancestors []string) error {
+		visitedDirs := make(map[fileID]bool)
+		return filepath.Walk(walkRoot, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// Get path relative to root directory
+			relPath, err := filepath.Rel(walkRoot, path)
+			if err != nil {
+				return err
+			}
+			relPath = filepath.Join(prefix, relPath)
+
+			// Bind mounts can make a directory reappear below itself; walk it only once
+			if info.IsDir() {
+				if id, ok := ds.identify(info); ok {
+					if visitedDirs[id] {
+						ds.recordDirectoryLoop(filepath.ToSlash(relPath))
+						return filepath.SkipDir
+					}
+					visitedDirs[id] = true
+				}
+			}
+
+			// Skip the root directory itself
+			if relPath == "." {
+				return nil
+			}
+
+			// Normalize path separator for consistency
+			slashPath := filepath.ToSlash(relPath)
+
+			// The package's own metadata, partial copies and ignored entries are
+			// neither synced nor deleted
+			if isMetadataFile(slashPath) || (ds.ResumeLargeFiles && !info.IsDir() && isResumeArtifact(slashPath)) ||
+				isIgnored(ignoreRules, slashPath, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if info.IsDir() {
+				skip, err := ds.skipDir(path, slashPath)
+				if err != nil {
+					return err
+				}
+				if skip {
+					return filepath.SkipDir
+				}
+			}
+
+			// Links are recorded as links unless they can be followed; dangling
+			// and self-referencing links are never followed
+			if info.Mode()&os.ModeSymlink != 0 && ds.FollowSymlinks {
+				if target, err := filepath.EvalSymlinks(path); err == nil {
+					targetInfo, err := os.Stat(target)
+					if err != nil {
+						return err
+					}
+					if targetInfo.IsDir() {
+						linkDir, err := filepath.EvalSymlinks(filepath.Dir(path))
+						if err != nil {
+							return err
+						}
+						if symlinkLoops(target, linkDir, ancestors) {
+							ds.recordDirectoryLoop(slashPath)
+							return nil
+						}
+						return walk(target, relPath, append(slices.Clone(ancestors), linkDir))
+					}
+					info = targetInfo
+				}
+			}
+
+			fileInfo := FileInfo{
+				Path:         slashPath,
+				Size:         info.Size(),
+				LastModified: info.ModTime(),
+				IsDir:        info.IsDir(),
+				Mode:         info.Mode(),
+			}
 
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+			files = append(files, fileInfo)
+			fullPaths = append(fullPaths, path)
+			ds.emit(SyncEvent{Type: EventDiscovered, Path: slashPath, Size: fileInfo.Size})
+			return nil
+		})
+	}
+	if err := walk(rootDir, "", nil); err != nil {
+		return nil, err
+	}
+
+	// Calculate hashes for files, not directories, across a worker pool
+	err = runParallel(ctx, ds.hashConcurrency(), len(files), func(i int) error {
+		if files[i].IsDir {
+			return nil
+		}
+		file, unstable, err := ds.hashStable(fullPaths[i], files[i])
 		if err != nil {
+			if ds.SkipLocked && isLockedError(err) {
+				ds.recordSkippedLocked(files[i].Path)
+				files[i].Hash = nil
+				return nil
+			}
+			return err
+		}
+		if unstable {
+			ds.recordUnstable(file.Path)
+		}
+		files[i] = file
+		ds.emit(SyncEvent{Type: EventHashed, Path: files[i].Path, Size: files[i].Size})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop locked files that could not be hashed
+	files = slices.DeleteFunc(files, func(file FileInfo) bool {
+		return !file.IsDir && file.Hash == nil
+	})
+
+	// Sort files by path for consistent ordering
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+// skipDir reports whether the scanned directory at path is left out, because
+// SkipDirFunc says so or because the source scan already skipped it, and
+// records it as skipped
+func (ds *DirectorySync) skipDir(path, slashPath string) (bool, error) {
+	if ds.SkipDirFunc == nil {
+		return false, nil
+	}
+	ds.mu.Lock()
+	skipped := ds.skippedDirs[slashPath]
+	ds.mu.Unlock()
+	if skipped {
+		return true, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return false, err
+	}
+	if !ds.SkipDirFunc(slashPath, len(entries)) {
+		return false, nil
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.skippedDirs == nil {
+		ds.skippedDirs = make(map[string]bool)
+	}
+	ds.skippedDirs[slashPath] = true
+	return true, nil
+}
+
+// inSkippedDir reports whether relPath is a directory skipped by SkipDirFunc
+// or lies below one
+func (ds *DirectorySync) inSkippedDir(relPath string) bool {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for dir := relPath; dir != "."; dir = path.Dir(dir) {
+		if ds.skippedDirs[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// FindDuplicates groups the files of a listing by content and returns the
+// paths of every group with more than one file, keyed by hex content hash.
+// Directories and symbolic links are not compared.
+func FindDuplicates(files []FileInfo) map[string][]string {
+	byHash := make(map[string][]string)
+	for _, file := range files {
+		if file.IsDir || isSymlink(file) || file.Hash == nil {
+			continue
+		}
+		key := hex.EncodeToString(file.Hash)
+		byHash[key] = append(byHash[key], file.Path)
+	}
+	maps.DeleteFunc(byHash, func(_ string, paths []string) bool {
+		return len(paths) < 2
+	})
+	return byHash
+}
+
+// hashGzipFile calculates the SHA-256 hash of a gzip file's decompressed
+// contents, or of its raw contents if it is not valid gzip (e.g. corrupt or
+// merely named .gz)
+func hashGzipFile(filePath string) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	reader, err := gzip.NewReader(file)
+	if err == nil {
+		defer reader.Close()
+		_, err = io.Copy(hash, reader)
+	}
+	if isInvalidGzip(err) {
+		return hashFile(filePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// isInvalidGzip reports whether a gzip read failed because of the data rather
+// than the file system
+func isInvalidGzip(err error) bool {
+	var corrupt flate.CorruptInputError
+	return errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &corrupt)
+}
+
+// hashFile calculates the SHA-256 hash of a file's contents
+func hashFile(filePath string) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+
+	return hash.Sum(nil), nil
+}
+
+// BuildMerkleTree creates a Merkle tree from file info list
+func (ds *DirectorySync) BuildMerkleTree(files []FileInfo) (*MerkleTree, error) {
+	files = ds.comparedEntries(files)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files to build tree from")
+	}
+
+	// Create data blocks from file info
+	empty := emptyDirs(files)
+	dataBlocks := make([][]byte, len(files))
+	for i, file := range files {
+		dataBlocks[i] = ds.encodeLeaf(file, empty)
+	}
+
+	if ds.CacheLeafHashes {
+		return newTreeFromLeaves(ds.cachedLeafHashes(dataBlocks), ds.TreeOptions)
+	}
+
+	// Build the Merkle tree
+	return NewTreeWithOptions(dataBlocks, ds.TreeOptions)
+}
+
+// cachedLeafHashes hashes the data blocks into leaves, reusing the leaf hash of
+// every block already seen by this DirectorySync. The cache is dropped when
+// TreeOptions changed the way leaves are hashed since it was filled.
+func (ds *DirectorySync) cachedLeafHashes(dataBlocks [][]byte) [][]byte {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.leafCache == nil || !sameLeafHashing(ds.leafCacheOpts, ds.TreeOptions) {
+		ds.leafCache = make(map[string][]byte)
+		ds.leafCacheOpts = ds.TreeOptions
+	}
+
+	leaves := make([][]byte, len(dataBlocks))
+	var missing [][]byte
+	var missingAt []int
+	for i, block := range dataBlocks {
+		if leaf, ok := ds.leafCache[string(block)]; ok {
+			leaves[i] = leaf
+			continue
+		}
+		missing = append(missing, block)
+		missingAt = append(missingAt, i)
+	}
+	for j, leaf := range hashLeaves(missing, ds.TreeOptions) {
+		leaves[missingAt[j]] = leaf
+		ds.leafCache[string(missing[j])] = leaf
+	}
+	return leaves
+}
+
+// sameLeafHashing reports whether two sets of options hash leaves the same
+// way. Hash functions are told apart by their code.
+func sameLeafHashing(a, b TreeOptions) bool {
+	if a.DomainSeparation != b.DomainSeparation || (a.Hash == nil) != (b.Hash == nil) {
+		return false
+	}
+	return a.Hash == nil || reflect.ValueOf(a.Hash).Pointer() == reflect.ValueOf(b.Hash).Pointer()
+}
+
+// encodeLeaf returns the data block for a file using LeafEncoder, if set.
+// empty holds the listing's empty directories, as returned by emptyDirs.
+func (ds *DirectorySync) encodeLeaf(file FileInfo, empty map[string]bool) []byte {
+	if ds.LeafEncoder != nil {
+		return ds.LeafEncoder(file)
+	}
+	if file.IsDir {
+		return dirLeafData(file.Path, empty[file.Path])
+	}
+	return leafData(file)
+}
+
+// leafData returns the default data block a file or non-empty directory
+// contributes to the tree.
+//
+// A file's block is its content hash, so an empty file is an ordinary entry
+// hashed as sha256("") and differs from a missing file, which has no leaf.
+// A directory's block binds its path and whether anything is listed below
+// it (see dirLeafData). CompareTrees matches directories by path alone, so
+// an empty directory against one with files only plans the files' copies or
+// deletions, and an empty directory against a missing one is added or deleted
+// like any other entry.
+func leafData(file FileInfo) []byte {
+	if file.IsDir {
+		return dirLeafData(file.Path, false)
+	}
+	return file.Hash
+}
+
+// dirLeafData hashes a directory's path and emptiness, so emptying a
+// directory changes its own leaf as well as removing its entries' leaves
+func dirLeafData(path string, empty bool) []byte {
+	h := sha256.New()
+	if empty {
+		h.Write([]byte(path + ":emptydir"))
+	} else {
+		h.Write([]byte(path + ":dir"))
+	}
+	return h.Sum(nil)
+}
+
+// emptyDirs returns the directories of a listing with no entries listed
+// below them. Directories whose contents were all ignored count as empty.
+func emptyDirs(files []FileInfo) map[string]bool {
+	parents := make(map[string]bool, len(files))
+	for _, file := range files {
+		parents[path.Dir(file.Path)] = true
+	}
+	empty := make(map[string]bool)
+	for _, file := range files {
+		if file.IsDir && !parents[file.Path] {
+			empty[file.Path] = true
+		}
+	}
+	return empty
+}
+
+// AuditDirectory builds the Merkle tree of dir and checks it against a trusted root.
+// A root hash alone does not reveal which leaves are wrong, so on mismatch every
+// local path is reported as unverified together with ErrRootMismatch.
+// A nil slice and nil error mean the directory matches the trusted root.
+func AuditDirectory(dir string, trustedRoot []byte) ([]string, error) {
+	ds := &DirectorySync{}
+	files, err := ds.BuildDirectoryTree(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning directory: %v", err)
+	}
+
+	tree, err := ds.BuildMerkleTree(files)
+	if err != nil {
+		return nil, fmt.Errorf("error building tree: %v", err)
+	}
+
+	if bytes.Equal(tree.Root, trustedRoot) {
+		return nil, nil
+	}
+
+	mismatched := make([]string, 0, len(files))
+	for _, file := range files {
+		mismatched = append(mismatched, file.Path)
+	}
+	return mismatched, ErrRootMismatch
+}
+
+// ExportChecksums writes a sha256sum-compatible listing of the source files,
+// one "<hex>  <path>" line per file, so it can be checked with `sha256sum -c`.
+// Paths containing a backslash or newline are escaped the way coreutils does.
+// Symlinks are left out: their leaves hash the link target, not file contents.
+func (ds *DirectorySync) ExportChecksums(w io.Writer) error {
+	files, err := ds.BuildDirectoryTree(ds.SourceDir)
+	if err != nil {
+		return fmt.Errorf("error scanning source directory: %v", err)
+	}
+
+	for _, file := range files {
+		if file.IsDir || isSymlink(file) {
+			continue
+		}
+		prefix := ""
+		path := file.Path
+		if strings.ContainsAny(path, "\\\n") {
+			prefix = "\\"
+			path = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(path)
+		}
+		if _, err := fmt.Fprintf(w, "%s%s  %s\n", prefix, hex.EncodeToString(file.Hash), path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// comparedEntries drops directories from a listing when IgnoreDirectories is set
+func (ds *DirectorySync) comparedEntries(files []FileInfo) []FileInfo {
+	if !ds.IgnoreDirectories {
+		return files
+	}
+	return slices.DeleteFunc(slices.Clone(files), func(file FileInfo) bool {
+		return file.IsDir
+	})
+}
+
+// DirDiff classifies every path of two directory listings
+type DirDiff struct {
+	Added     []FileInfo // Only in the source
+	Modified  []FileInfo // In both, with different contents (source entry)
+	Deleted   []FileInfo // Only in the destination
+	Unchanged []FileInfo // In both, with the same contents
+}
+
+// diffFiles compares the source listing against the destination listing,
+// keeping the order of each listing. Entries are matched by key(path).
+func diffFiles(sourceFiles, destFiles []FileInfo, key func(path string) string) *DirDiff {
+	sourceMap := make(map[string]FileInfo)
+	destMap := make(map[string]FileInfo)
+	for _, file := range sourceFiles {
+		sourceMap[key(file.Path)] = file
+	}
+	for _, file := range destFiles {
+		destMap[key(file.Path)] = file
+	}
+
+	diff := &DirDiff{}
+	for _, file := range sourceFiles {
+		destFile, exists := destMap[key(file.Path)]
+		switch {
+		case !exists:
+			diff.Added = append(diff.Added, file)
+		case !sameEntryType(file, destFile) || !file.IsDir && !bytes.Equal(file.Hash, destFile.Hash):
+			diff.Modified = append(diff.Modified, file)
+		default:
+			diff.Unchanged = append(diff.Unchanged, file)
+		}
+	}
+	for _, file := range destFiles {
+		if _, exists := sourceMap[key(file.Path)]; !exists {
+			diff.Deleted = append(diff.Deleted, file)
+		}
+	}
+	return diff
+}
+
+// sameEntryType reports whether two entries are both files, both directories
+// or both links
+func sameEntryType(a, b FileInfo) bool {
+	return a.IsDir == b.IsDir && isSymlink(a) == isSymlink(b)
+}
+
+// CompareTreesDetailed classifies every entry of the source and destination
+// listings as added, modified, deleted or unchanged by comparing paths and
+// content hashes
+func (ds *DirectorySync) CompareTreesDetailed(sourceFiles, destFiles []FileInfo) (*DirDiff, error) {
+	return diffFiles(ds.comparedEntries(sourceFiles), ds.comparedEntries(destFiles), ds.pathKey), nil
+}
+
+// pathKey returns the key a path is compared by: the path itself, or its
+// case folded form when CaseInsensitivePaths is set
+func (ds *DirectorySync) pathKey(path string) string {
+	if !ds.CaseInsensitivePaths {
+		return path
+	}
+	// Upper-casing first folds characters with several lower-case forms,
+	// like 'ſ' and 's', onto one
+	return strings.ToLower(strings.ToUpper(path))
+}
+
+// CompareTrees identifies differences between source and destination: the
+// source entries to copy (added or modified) and the destination paths to delete
+func (ds *DirectorySync) CompareTrees(sourceFiles, destFiles []FileInfo) ([]FileInfo, []string, error) {
+	diff, err := ds.CompareTreesDetailed(sourceFiles, destFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Everything that isn't unchanged is copied, in source order
+	unchanged := make(map[string]bool, len(diff.Unchanged))
+	for _, file := range diff.Unchanged {
+		unchanged[file.Path] = true
+	}
+	var filesToCopy []FileInfo
+	for _, file := range ds.comparedEntries(sourceFiles) {
+		if !unchanged[file.Path] {
+			filesToCopy = append(filesToCopy, file)
+		}
+	}
+
+	var filesToDelete []string
+	for _, file := range diff.Deleted {
+		filesToDelete = append(filesToDelete, file.Path)
+	}
+	return filesToCopy, filesToDelete, nil
+}
+
+// SyncDirectories synchronizes files from source to destination
+func (ds *DirectorySync) SyncDirectories() error {
+	return ds.SyncDirectoriesContext(context.Background())
+}
+
+// SyncDirectoriesContext synchronizes files like SyncDirectories but stops between
+// operations once ctx is cancelled, returning ctx.Err(). Scanning stops between
+// entries, and in-flight copies stop between reads. Copies are written through
+// a temporary file, as with AtomicCopy, so none is left half-written; large
+// resumable copies keep their partial file to resume from instead.
+func (ds *DirectorySync) SyncDirectoriesContext(ctx context.Context) (err error) {
+	ds.SkippedLocked = nil
+	ds.DirectoryLoops = nil
+	ds.Unstable = nil
+	ds.Deferred = nil
+	ds.Conflicts = nil
+	ds.FailedCopies = nil
+	ds.openFiles = nil
+	ds.eventCounts = nil
+	ds.syncing = true
+	if ds.MaxOpenFiles > 0 {
+		ds.openFiles = make(chan struct{}, ds.MaxOpenFiles)
+	}
+	ds.limiter = nil
+	if ds.RateLimitBytesPerSec > 0 {
+		ds.limiter = newRateLimiter(ds.RateLimitBytesPerSec)
+	}
+
+	defer func() {
+		if modeErr := ds.applyDirModes(); err == nil {
+			err = modeErr
+		}
+		if err != nil {
+			ds.emit(SyncEvent{Type: EventError, Err: err})
+		}
+		ds.syncing = false
+		if ds.Events != nil {
+			close(ds.Events)
+		}
+	}()
+	return ds.syncDirectories(ctx)
+}
+
+// syncDirectories performs the scan, compare, copy and delete phases of a sync
+func (ds *DirectorySync) syncDirectories(ctx context.Context) error {
+	if ds.PreserveSparse && ds.ResumeLargeFiles {
+		return fmt.Errorf("%w: PreserveSparse and ResumeLargeFiles", ErrIncompatibleOptions)
+	}
+	if ds.DestPrefix != "" {
+		if !filepath.IsLocal(filepath.FromSlash(ds.DestPrefix)) {
+			return fmt.Errorf("%w: %s", ErrUnsafePath, ds.DestPrefix)
+		}
+		if err := os.MkdirAll(ds.destRoot(), ds.dirMode()); err != nil {
+			return fmt.Errorf("error creating destination prefix: %v", err)
+		}
+	}
+
+	if ds.Journal && ds.Mode == SyncMirror {
+		journal, err := openJournal(ds.destRoot())
+		if err != nil {
+			return fmt.Errorf("error opening journal: %v", err)
+		}
+		ds.journal = journal
+		defer func() {
+			journal.file.Close()
+			ds.journal = nil
+		}()
+	}
+
+	ds.logf("Building source directory tree...\n")
+	sourceFiles, err := ds.scanSource(ctx)
+	if err != nil {
+		return err
+	}
+
+	ds.logf("Building destination directory tree...\n")
+	destFiles, err := ds.buildDirectoryTree(ctx, ds.destRoot())
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("error scanning destination directory: %v", err)
+	}
+
+	if ds.Mode == SyncMerge {
+		return ds.mergeDirectories(ctx, sourceFiles, destFiles)
+	}
+
+	ds.logf("Building Merkle trees...\n")
+	sourceTree, err := ds.BuildMerkleTree(sourceFiles)
+	if err != nil {
+		return fmt.Errorf("error building source tree: %v", err)
+	}
+
+	destTree, err := ds.BuildMerkleTree(destFiles)
+	if err != nil {
+		// If destination is empty, just copy everything
+		if strings.Contains(err.Error(), "no files") {
+			destTree = nil
+		} else {
+			return fmt.Errorf("error building destination tree: %v", err)
+		}
+	}
+
+	// Quick check - if root hashes match, directories are identical
+	if destTree != nil && bytes.Equal(sourceTree.Root, destTree.Root) {
+		ds.logf("Directories are already in sync.\n")
+		if err := ds.removeJournal(); err != nil {
+			return err
+		}
+		return ds.stampRoot(sourceTree.Root)
+	}
+
+	ds.logf("Finding differences...\n")
+	filesToCopy, filesToDelete, err := ds.CompareTrees(sourceFiles, destFiles)
+	if err != nil {
+		return fmt.Errorf("error comparing trees: %v", err)
+	}
+
+	filesToDelete = ds.protectFromDeletion(filesToDelete)
+	filesToCopy = ds.deferUnsettled(filesToCopy)
+	for _, path := range ds.Deferred {
+		ds.logf("Deferring recently modified file: %s\n", path)
+	}
+	filesToCopy, err = ds.resolveConflicts(filesToCopy, destFiles)
+	if err != nil {
+		return err
+	}
+	for _, path := range ds.Conflicts {
+		ds.logf("Skipping file newer at the destination: %s\n", path)
+	}
+
+	filesToDelete, err = ds.removeReplaced(filesToCopy, destFiles, filesToDelete)
+	if err != nil {
+		return err
+	}
+
+	// First create directories
+	for _, file := range filesToCopy {
+		if file.IsDir {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := checkLinkFreeParents(ds.destRoot(), file.Path); err != nil {
+				return err
+			}
+			destPath := filepath.Join(ds.destRoot(), file.Path)
+			ds.logf("Creating directory: %s\n", file.Path)
+			if err := ds.makeDir(destPath, file.Mode); err != nil {
+				return fmt.Errorf("error creating directory %s: %v", destPath, err)
+			}
+			ds.emit(SyncEvent{Type: EventCopied, Path: file.Path})
+		}
+	}
+
+	// Then copy files. Repeated content is only copied once when deduplicating
+	// and linked afterwards, once every first occurrence is in place.
+	var toCopy, duplicates []FileInfo
+	seenHashes := make(map[string]bool)
+	for _, file := range filesToCopy {
+		if file.IsDir {
+			continue
+		}
+		if ds.DedupeByHash && !isSymlink(file) && seenHashes[string(file.Hash)] {
+			duplicates = append(duplicates, file)
+			continue
+		}
+		seenHashes[string(file.Hash)] = true
+		toCopy = append(toCopy, file)
+	}
+
+	// Small files can be streamed through a single archive instead of copied one by one
+	var batched []FileInfo
+	if ds.BatchSmallFiles {
+		batched, toCopy = ds.splitSmallFiles(toCopy)
+		if err := ds.copyBatch(ctx, batched); err != nil {
+			return err
+		}
+	}
+
+	err = runParallel(ctx, ds.copyConcurrency(), len(toCopy), func(i int) error {
+		return ds.tolerateCopyError(toCopy[i], ds.copyToDestination(ctx, toCopy[i]))
+	})
+	if err != nil {
+		return err
+	}
+
+	copiedByHash := make(map[string]string)
+	for _, file := range slices.Concat(batched, toCopy) {
+		copiedByHash[string(file.Hash)] = filepath.Join(ds.destRoot(), file.Path)
+	}
+	for _, file := range duplicates {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := checkLinkFreeParents(ds.destRoot(), file.Path); err != nil {
+			return err
+		}
+		destPath := filepath.Join(ds.destRoot(), file.Path)
+		if err := os.MkdirAll(filepath.Dir(destPath), ds.dirMode()); err != nil {
+			return fmt.Errorf("error creating directory %s: %v", filepath.Dir(destPath), err)
+		}
+		if err := linkFile(copiedByHash[string(file.Hash)], destPath); err == nil {
+			ds.logf("Linking file: %s\n", file.Path)
+			if err := ds.journalCopy(file); err != nil {
+				return err
+			}
+			ds.emit(SyncEvent{Type: EventCopied, Path: file.Path, Size: file.Size})
+			continue
+		}
+		// Linking is not supported everywhere, fall back to a plain copy
+		if err := ds.tolerateCopyError(file, ds.copyToDestination(ctx, file)); err != nil {
+			return err
+		}
+	}
+
+	// Deleting is only safe once everything was copied: a file renamed in the
+	// source whose new copy failed would otherwise lose its old destination copy
+	if len(ds.FailedCopies) > 0 {
+		sort.Strings(ds.FailedCopies)
+		return fmt.Errorf("%w: %s", ErrCopyFailed, strings.Join(ds.FailedCopies, ", "))
+	}
+
+	// Delete files that don't exist in source
+	for _, path := range filesToDelete {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := checkLinkFreeParents(ds.destRoot(), path); err != nil {
 			return err
 		}
-
-		// Get path relative to root directory
-		relPath, err := filepath.Rel(rootDir, path)
+		fullPath := filepath.Join(ds.destRoot(), path)
+		ds.logf("Deleting: %s\n", path)
+		err := ds.withRetries(ctx, func() error {
+			return os.RemoveAll(fullPath)
+		})
 		if err != nil {
+			return fmt.Errorf("error deleting %s: %v", path, err)
+		}
+		if err := ds.removeResumeArtifacts(fullPath); err != nil {
+			return fmt.Errorf("error deleting partial copy of %s: %v", path, err)
+		}
+		if err := ds.journalDelete(path); err != nil {
 			return err
 		}
+		ds.emit(SyncEvent{Type: EventDeleted, Path: path})
+	}
 
-		// Skip the root directory itself
-		if relPath == "." {
-			return nil
+	if ds.VerifyOnComplete {
+		ds.logf("Verifying destination...\n")
+		if err := ds.verifyDestination(sourceFiles, sourceTree); err != nil {
+			return err
 		}
+	}
 
-		// Normalize path separator for consistency
-		relPath = filepath.ToSlash(relPath)
+	if err := ds.removeJournal(); err != nil {
+		return err
+	}
+	if err := ds.stampRoot(sourceTree.Root); err != nil {
+		return err
+	}
 
-		fileInfo := FileInfo{
-			Path:         relPath,
-			Size:         info.Size(),
-			LastModified: info.ModTime(),
-			IsDir:        info.IsDir(),
-		}
+	ds.logf("Sync complete!\n")
+	return nil
+}
 
-		// Calculate hash for files, not directories
-		if !info.IsDir() {
-			hash, err := hashFile(path)
-			if err != nil {
-				return err
-			}
-			fileInfo.Hash = hash
+// removeReplaced deletes the destination entries the source replaces with an
+// entry of another type, e.g. a directory that became a link, so the
+// replacement is never created into or through the old entry. It returns
+// filesToDelete without the paths that were removed along with them.
+func (ds *DirectorySync) removeReplaced(filesToCopy, destFiles []FileInfo, filesToDelete []string) ([]string, error) {
+	destByPath := make(map[string]FileInfo, len(destFiles))
+	for _, file := range destFiles {
+		destByPath[ds.pathKey(file.Path)] = file
+	}
+
+	var replaced []string
+	for _, file := range filesToCopy {
+		destFile, ok := destByPath[ds.pathKey(file.Path)]
+		if !ok || sameEntryType(file, destFile) {
+			continue
+		}
+		if err := checkLinkFreeParents(ds.destRoot(), destFile.Path); err != nil {
+			return nil, err
 		}
+		ds.logf("Replacing: %s\n", destFile.Path)
+		// RemoveAll removes a link itself, never what it points to
+		if err := os.RemoveAll(filepath.Join(ds.destRoot(), destFile.Path)); err != nil {
+			return nil, fmt.Errorf("error removing %s: %v", destFile.Path, err)
+		}
+		if err := ds.journalDelete(destFile.Path); err != nil {
+			return nil, err
+		}
+		replaced = append(replaced, destFile.Path)
+	}
+	if len(replaced) == 0 {
+		return filesToDelete, nil
+	}
+	return slices.DeleteFunc(filesToDelete, func(path string) bool {
+		return slices.ContainsFunc(replaced, func(parent string) bool {
+			return strings.HasPrefix(path, parent+"/")
+		})
+	}), nil
+}
 
-		files = append(files, fileInfo)
+// stampRoot records the source root on the destination when StampRootXattr is set
+func (ds *DirectorySync) stampRoot(root []byte) error {
+	if !ds.StampRootXattr {
 		return nil
-	})
+	}
+	if err := setRootXattr(ds.destRoot(), root); err != nil {
+		return fmt.Errorf("error stamping root on destination: %v", err)
+	}
+	return nil
+}
+
+// verifyDestination re-scans the destination and checks it now matches the source.
+// Paths the sync deliberately left alone (locked files, partial copies) are not
+// counted as mismatches.
+func (ds *DirectorySync) verifyDestination(sourceFiles []FileInfo, sourceTree *MerkleTree) error {
+	destFiles, err := ds.buildDirectoryTree(context.Background(), ds.destRoot())
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("error re-scanning destination directory: %v", err)
+	}
+	destTree, err := ds.BuildMerkleTree(destFiles)
+	if err == nil && bytes.Equal(sourceTree.Root, destTree.Root) {
+		return nil
 	}
 
-	// Sort files by path for consistent ordering
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].Path < files[j].Path
+	filesToCopy, filesToDelete, err := ds.CompareTrees(sourceFiles, destFiles)
+	if err != nil {
+		return fmt.Errorf("error comparing trees: %v", err)
+	}
+	var mismatched []string
+	for _, file := range filesToCopy {
+		mismatched = append(mismatched, file.Path)
+	}
+	mismatched = append(mismatched, ds.protectFromDeletion(filesToDelete)...)
+	mismatched = slices.DeleteFunc(mismatched, func(path string) bool {
+		return slices.Contains(ds.SkippedLocked, path) || slices.Contains(ds.Deferred, path) ||
+			slices.Contains(ds.Conflicts, path)
 	})
+	if len(mismatched) == 0 {
+		return nil
+	}
+	sort.Strings(mismatched)
+	return fmt.Errorf("%w: %s", ErrSyncVerificationFailed, strings.Join(mismatched, ", "))
+}
 
-	return files, nil
+// protectFromDeletion drops destination paths the sync must keep even though
+// they are missing from the source scan
+func (ds *DirectorySync) protectFromDeletion(filesToDelete []string) []string {
+	return slices.DeleteFunc(filesToDelete, func(path string) bool {
+		// Directories skipped on either side were not compared
+		if ds.inSkippedDir(path) {
+			return true
+		}
+		// A locked source file is missing from the scan, but its destination copy must survive
+		return slices.Contains(ds.SkippedLocked, path)
+	})
 }
 
-// hashFile calculates the SHA-256 hash of a file's contents
-func hashFile(filePath string) ([]byte, error) {
-	file, err := os.Open(filePath)
+// scanSource scans SourceDir and applies PathMapper, so the listing uses
+// destination paths
+func (ds *DirectorySync) scanSource(ctx context.Context) ([]FileInfo, error) {
+	ds.skippedDirs = nil
+	files, err := ds.buildDirectoryTree(ctx, ds.SourceDir)
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("error scanning source directory: %v", err)
 	}
-	defer file.Close()
-
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return nil, err
+	if ds.DetectDuplicates {
+		ds.Duplicates = FindDuplicates(files)
+	}
+	ds.sourcePaths = nil
+	if ds.PathMapper == nil && !ds.CaseInsensitivePaths {
+		return files, nil
 	}
 
-	return hash.Sum(nil), nil
+	if ds.PathMapper != nil {
+		ds.sourcePaths = make(map[string]string, len(files))
+	}
+	mapped := make(map[string]FileInfo, len(files))
+	originals := make(map[string]string, len(files))
+	var result []FileInfo
+	for _, file := range files {
+		original := file.Path
+		if ds.PathMapper != nil {
+			file.Path = ds.PathMapper(original)
+			if file.Path == "" {
+				continue
+			}
+			if !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+				return nil, fmt.Errorf("%w: %s maps to %s", ErrUnsafePath, original, file.Path)
+			}
+		}
+		key := ds.pathKey(file.Path)
+		if existing, ok := mapped[key]; ok {
+			// Directories mapping to the same path are simply merged
+			if existing.IsDir && file.IsDir {
+				continue
+			}
+			return nil, fmt.Errorf("%w: %s and %s both map to %s", ErrPathCollision, originals[key], original, file.Path)
+		}
+		mapped[key] = file
+		originals[key] = original
+		if ds.sourcePaths != nil {
+			ds.sourcePaths[file.Path] = original
+		}
+		result = append(result, file)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result, nil
 }
 
-// BuildMerkleTree creates a Merkle tree from file info list
-func (ds *DirectorySync) BuildMerkleTree(files []FileInfo) (*MerkleTree, error) {
-	if len(files) == 0 {
-		return nil, fmt.Errorf("no files to build tree from")
+// sourcePath returns the full source path of an entry known by its
+// (possibly mapped) relative path
+func (ds *DirectorySync) sourcePath(relPath string) string {
+	if original, ok := ds.sourcePaths[relPath]; ok {
+		relPath = original
 	}
+	return filepath.Join(ds.SourceDir, filepath.FromSlash(relPath))
+}
 
-	// Create data blocks from file info
-	dataBlocks := make([][]byte, len(files))
-	for i, file := range files {
-		// For directories, create a special hash based on path + isDir flag
-		if file.IsDir {
-			h := sha256.New()
-			h.Write([]byte(file.Path + ":dir"))
-			dataBlocks[i] = h.Sum(nil)
-		} else {
-			// For files, use the pre-calculated file hash
-			dataBlocks[i] = file.Hash
+// deferUnsettled removes files modified within QuiescenceWindow from the copy
+// list and records them in Deferred
+func (ds *DirectorySync) deferUnsettled(filesToCopy []FileInfo) []FileInfo {
+	if ds.QuiescenceWindow <= 0 {
+		return filesToCopy
+	}
+	settledBefore := time.Now().Add(-ds.QuiescenceWindow)
+	return slices.DeleteFunc(filesToCopy, func(file FileInfo) bool {
+		if file.IsDir || !file.LastModified.After(settledBefore) {
+			return false
 		}
+		ds.Deferred = append(ds.Deferred, file.Path)
+		return true
+	})
+}
+
+// copyToDestination copies a single source file to its destination path
+func (ds *DirectorySync) copyToDestination(ctx context.Context, file FileInfo) error {
+	if err := checkLinkFreeParents(ds.destRoot(), file.Path); err != nil {
+		return err
 	}
+	srcPath := ds.sourcePath(file.Path)
+	destPath := filepath.Join(ds.destRoot(), file.Path)
 
-	// Build the Merkle tree
-	return NewTree(dataBlocks)
-}
+	// Ensure the destination directory exists
+	destDir := filepath.Dir(destPath)
+	if err := os.MkdirAll(destDir, ds.dirMode()); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", destDir, err)
+	}
 
-// CompareTrees identifies differences between source and destination
-func (ds *DirectorySync) CompareTrees(sourceFiles, destFiles []FileInfo) ([]FileInfo, []string, error) {
-	// Create maps for quick lookup
-	sourceMap := make(map[string]FileInfo)
-	destMap := make(map[string]FileInfo)
+	if ds.DedupeByHash {
+		// The destination may be a hard link from an earlier sync, so unlink it
+		// rather than truncating content shared with other paths
+		if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error unlinking %s: %v", file.Path, err)
+		}
+	}
 
-	for _, file := range sourceFiles {
-		sourceMap[file.Path] = file
+	if isSymlink(file) {
+		ds.logf("Creating link: %s\n", file.Path)
+		if err := copySymlink(srcPath, destPath); err != nil {
+			return fmt.Errorf("error copying link %s: %v", file.Path, err)
+		}
+		if err := ds.preserveOwnership(srcPath, destPath, file); err != nil {
+			return err
+		}
+		ds.emit(SyncEvent{Type: EventCopied, Path: file.Path, Size: file.Size})
+		return nil
 	}
 
-	for _, file := range destFiles {
-		destMap[file.Path] = file
+	ds.logf("Copying file: %s\n", file.Path)
+	err := ds.withRetries(ctx, func() error {
+		return ds.copy(ctx, srcPath, destPath, file.Mode)
+	})
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		if ds.SkipLocked && isLockedError(err) {
+			ds.logf("Skipping locked file: %s\n", file.Path)
+			ds.recordSkippedLocked(file.Path)
+			return nil
+		}
+		return fmt.Errorf("error copying %s: %v", file.Path, err)
+	}
+	if err := ds.preserveOwnership(srcPath, destPath, file); err != nil {
+		return err
+	}
+	if err := preserveModTime(destPath, file); err != nil {
+		return fmt.Errorf("error setting modification time of %s: %v", file.Path, err)
 	}
+	if err := ds.verifyCopy(file, destPath); err != nil {
+		return err
+	}
+	if err := ds.removeResumeArtifacts(destPath); err != nil {
+		return fmt.Errorf("error deleting partial copy of %s: %v", file.Path, err)
+	}
+	if err := ds.journalCopy(file); err != nil {
+		return err
+	}
+	ds.emit(SyncEvent{Type: EventCopied, Path: file.Path, Size: file.Size})
+	return nil
+}
 
-	// Find files to copy (new or modified)
-	var filesToCopy []FileInfo
-	var filesToDelete []string
+// verifyCopy re-hashes a copied file when VerifyAfterCopy is set
+func (ds *DirectorySync) verifyCopy(file FileInfo, destPath string) error {
+	if !ds.VerifyAfterCopy {
+		return nil
+	}
+	hash, err := ds.hash(destPath)
+	if err != nil {
+		return fmt.Errorf("error verifying %s: %v", file.Path, err)
+	}
+	if !bytes.Equal(hash, file.Hash) {
+		return fmt.Errorf("%w: %s", ErrCopyVerification, file.Path)
+	}
+	return nil
+}
 
-	// Find files in source that need to be copied to destination
-	for _, file := range sourceFiles {
-		destFile, exists := destMap[file.Path]
+// hashStable hashes a scanned file and re-stats it, re-hashing once with the
+// new size and modification time if they changed while it was read. It
+// returns the entry with its hash and reports whether the file changed again.
+func (ds *DirectorySync) hashStable(path string, file FileInfo) (FileInfo, bool, error) {
+	for attempt := 0; ; attempt++ {
+		hash, err := ds.scanHash(path, file)
+		if err != nil {
+			return file, false, err
+		}
+		file.Hash = hash
+		if isSymlink(file) {
+			return file, false, nil
+		}
 
-		// If file doesn't exist in destination or is different, copy it
-		if !exists {
-			filesToCopy = append(filesToCopy, file)
-		} else if !file.IsDir && !bytes.Equal(file.Hash, destFile.Hash) {
-			filesToCopy = append(filesToCopy, file)
+		info, err := os.Stat(path)
+		if err != nil {
+			return file, false, err
+		}
+		if info.Size() == file.Size && info.ModTime().Equal(file.LastModified) {
+			return file, false, nil
+		}
+		file.Size, file.LastModified = info.Size(), info.ModTime()
+		if attempt > 0 {
+			return file, true, nil
 		}
 	}
+}
 
-	// Find files in destination that don't exist in source (to be deleted)
-	for _, file := range destFiles {
-		_, exists := sourceMap[file.Path]
-		if !exists {
-			filesToDelete = append(filesToDelete, file.Path)
-		}
+// recordUnstable adds a path to the Unstable report
+func (ds *DirectorySync) recordUnstable(path string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.Unstable = append(ds.Unstable, path)
+}
+
+// recordDirectoryLoop adds a path to the DirectoryLoops report
+func (ds *DirectorySync) recordDirectoryLoop(path string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.DirectoryLoops = append(ds.DirectoryLoops, path)
+}
+
+// identify returns the device and inode of a file using the injected
+// identifier, if any
+func (ds *DirectorySync) identify(info os.FileInfo) (fileID, bool) {
+	if ds.identifier != nil {
+		return ds.identifier(info)
 	}
+	return identifyFile(info)
+}
 
-	return filesToCopy, filesToDelete, nil
+// tolerateCopyError records a failed copy in FailedCopies and swallows the error
+// when ContinueOnError is set
+func (ds *DirectorySync) tolerateCopyError(file FileInfo, err error) error {
+	if err == nil || !ds.ContinueOnError {
+		return err
+	}
+	ds.logf("Failed to copy %s: %v\n", file.Path, err)
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.FailedCopies = append(ds.FailedCopies, file.Path)
+	return nil
 }
 
-// SyncDirectories synchronizes files from source to destination
-func (ds *DirectorySync) SyncDirectories() error {
-	fmt.Println("Building source directory tree...")
-	sourceFiles, err := ds.BuildDirectoryTree(ds.SourceDir)
-	if err != nil {
-		return fmt.Errorf("error scanning source directory: %v", err)
+// recordSkippedLocked adds a path to the SkippedLocked report
+func (ds *DirectorySync) recordSkippedLocked(path string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.SkippedLocked = append(ds.SkippedLocked, path)
+}
+
+// hash calculates a file hash using the injected hasher, if any
+func (ds *DirectorySync) hash(path string) ([]byte, error) {
+	if ds.hasher != nil {
+		return ds.hasher(path)
+	}
+	if ds.DecompressBeforeHash && strings.EqualFold(filepath.Ext(path), ".gz") {
+		return hashGzipFile(path)
 	}
+	return hashFile(path)
+}
 
-	fmt.Println("Building destination directory tree...")
-	destFiles, err := ds.BuildDirectoryTree(ds.DestinationDir)
-	if err != nil {
-		return fmt.Errorf("error scanning destination directory: %v", err)
+// hashEntry hashes a listed file, or the target of a listed link
+func (ds *DirectorySync) hashEntry(path string, file FileInfo) ([]byte, error) {
+	if isSymlink(file) {
+		return hashSymlink(path)
+	}
+	return ds.hash(path)
+}
+
+// copy copies a file using the injected copier, if any. Copies read their
+// source through ctx, and when ctx can be cancelled they are written through a
+// temporary file, so a copy stopped midway never leaves a partial file behind.
+func (ds *DirectorySync) copy(ctx context.Context, src, dst string, mode os.FileMode) error {
+	// Each copy holds a source and destination descriptor pair open
+	if ds.openFiles != nil {
+		select {
+		case ds.openFiles <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-ds.openFiles }()
+	}
+	copyFn := func(src, dst string, mode os.FileMode) error {
+		return ds.copyFileContext(ctx, src, dst, mode)
+	}
+	switch {
+	case ds.copier != nil:
+		copyFn = ds.copier
+	case ds.PreserveSparse:
+		copyFn = func(src, dst string, mode os.FileMode) error {
+			return copySparseFileContext(ctx, src, dst, mode)
+		}
+	case ds.ResumeLargeFiles:
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if info.Size() >= ds.largeFileThreshold() {
+			// Resumable copies already write a partial file and rename it into
+			// place; a cancelled one resumes on the next sync
+			return copyResumableFile(ctx, src, dst, mode)
+		}
+	}
+	if ds.DeltaCopy && ds.copier == nil && !ds.PreserveSparse {
+		if copied, err := ds.copyDelta(ctx, src, dst, mode); copied || err != nil {
+			return err
+		}
+	}
+	if ds.AtomicCopy || ctx.Done() != nil {
+		return writeAtomically(dst, func(tmpPath string) error {
+			return copyFn(src, tmpPath, mode)
+		})
 	}
+	return copyFn(src, dst, mode)
+}
 
-	fmt.Println("Building Merkle trees...")
-	sourceTree, err := ds.BuildMerkleTree(sourceFiles)
+// copyFileContext copies a file like copyFile, reading it through the sync's
+// rate limiter, if any, and stopping with ctx.Err() once ctx is cancelled
+func (ds *DirectorySync) copyFileContext(ctx context.Context, src, dst string, mode os.FileMode) error {
+	sourceFile, err := os.Open(src)
 	if err != nil {
-		return fmt.Errorf("error building source tree: %v", err)
+		return err
 	}
+	defer sourceFile.Close()
 
-	destTree, err := ds.BuildMerkleTree(destFiles)
-	if err != nil {
-		// If destination is empty, just copy everything
-		if strings.Contains(err.Error(), "no files") {
-			destTree = nil
-		} else {
-			return fmt.Errorf("error building destination tree: %v", err)
+	if mode == 0 {
+		sourceInfo, err := sourceFile.Stat()
+		if err != nil {
+			return err
 		}
+		mode = sourceInfo.Mode()
 	}
+	return writeFile(dst, &contextReader{ctx: ctx, r: ds.limitReader(sourceFile)}, mode)
+}
 
-	// Quick check - if root hashes match, directories are identical
-	if destTree != nil && bytes.Equal(sourceTree.Root, destTree.Root) {
-		fmt.Println("Directories are already in sync.")
-		return nil
+// largeFileThreshold returns the size from which copies are resumable
+func (ds *DirectorySync) largeFileThreshold() int64 {
+	if ds.LargeFileThreshold <= 0 {
+		return defaultLargeFileThreshold
 	}
+	return ds.LargeFileThreshold
+}
 
-	fmt.Println("Finding differences...")
-	filesToCopy, filesToDelete, err := ds.CompareTrees(sourceFiles, destFiles)
-	if err != nil {
-		return fmt.Errorf("error comparing trees: %v", err)
+// hashConcurrency returns the number of files hashed in parallel
+func (ds *DirectorySync) hashConcurrency() int {
+	if ds.HashConcurrency <= 0 {
+		return runtime.NumCPU()
 	}
+	return ds.HashConcurrency
+}
 
-	// First create directories
-	for _, file := range filesToCopy {
-		if file.IsDir {
-			destPath := filepath.Join(ds.DestinationDir, file.Path)
-			fmt.Printf("Creating directory: %s\n", file.Path)
-			if err := os.MkdirAll(destPath, 0755); err != nil {
-				return fmt.Errorf("error creating directory %s: %v", destPath, err)
+// copyConcurrency returns the number of files copied in parallel
+func (ds *DirectorySync) copyConcurrency() int {
+	if ds.CopyConcurrency <= 0 {
+		return defaultCopyConcurrency
+	}
+	return ds.CopyConcurrency
+}
+
+// runParallel calls fn for every index in [0, count) using at most workers goroutines.
+// It stops handing out work after the first error or once ctx is cancelled,
+// and returns that error.
+func runParallel(ctx context.Context, workers, count int, fn func(i int) error) error {
+	workers = max(1, min(workers, count))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	indices := make(chan int)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				if err := fn(i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
 			}
+		}()
+	}
+
+feed:
+	for i := range count {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+			break feed
 		}
 	}
+	close(indices)
+	wg.Wait()
 
-	// Then copy files
-	for _, file := range filesToCopy {
-		if !file.IsDir {
-			srcPath := filepath.Join(ds.SourceDir, file.Path)
-			destPath := filepath.Join(ds.DestinationDir, file.Path)
-
-			// Ensure the destination directory exists
-			destDir := filepath.Dir(destPath)
-			if err := os.MkdirAll(destDir, 0755); err != nil {
-				return fmt.Errorf("error creating directory %s: %v", destDir, err)
-			}
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
 
-			fmt.Printf("Copying file: %s\n", file.Path)
-			if err := copyFile(srcPath, destPath); err != nil {
-				return fmt.Errorf("error copying %s: %v", file.Path, err)
-			}
+// destRoot returns the directory the source is mirrored into: DestinationDir,
+// or DestPrefix below it when set
+func (ds *DirectorySync) destRoot() string {
+	return filepath.Join(ds.DestinationDir, filepath.FromSlash(ds.DestPrefix))
+}
+
+// dirMode returns the mode used for directories without a known source mode
+func (ds *DirectorySync) dirMode() os.FileMode {
+	if ds.DefaultDirMode == 0 {
+		return 0755
+	}
+	return ds.DefaultDirMode
+}
+
+// makeDir creates a destination directory, preserving the source mode when known.
+// A mode that denies the owner access, such as 0555, would stop the directory's
+// contents from being copied into it, so the directory is left owner-writable
+// and its mode recorded for applyDirModes.
+func (ds *DirectorySync) makeDir(path string, mode os.FileMode) error {
+	perm := mode.Perm()
+	if perm == 0 {
+		perm = ds.dirMode()
+	}
+	if err := os.MkdirAll(path, perm|0700); err != nil {
+		return err
+	}
+	// MkdirAll is subject to the umask, so apply the exact permissions afterwards
+	if err := os.Chmod(path, perm|0700); err != nil {
+		return err
+	}
+	if perm&0700 != 0700 {
+		if ds.dirModes == nil {
+			ds.dirModes = make(map[string]os.FileMode)
 		}
+		ds.dirModes[path] = perm
 	}
+	return nil
+}
 
-	// Delete files that don't exist in source
-	for _, path := range filesToDelete {
-		fullPath := filepath.Join(ds.DestinationDir, path)
-		fmt.Printf("Deleting: %s\n", path)
-		if err := os.RemoveAll(fullPath); err != nil {
-			return fmt.Errorf("error deleting %s: %v", path, err)
+// applyDirModes applies the modes makeDir held back, deepest directories first
+// so a parent never loses access before its children are done
+func (ds *DirectorySync) applyDirModes() error {
+	paths := make([]string, 0, len(ds.dirModes))
+	for path := range ds.dirModes {
+		paths = append(paths, path)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	modes := ds.dirModes
+	ds.dirModes = nil
+	for _, path := range paths {
+		if err := os.Chmod(path, modes[path]); err != nil {
+			return fmt.Errorf("error setting mode of directory %s: %v", path, err)
 		}
 	}
+	return nil
+}
+
+// linkFile hard-links dst to an existing file, replacing whatever is at dst
+func linkFile(existing, dst string) error {
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(existing, dst)
+}
 
-	fmt.Println("Sync complete!")
+// preserveOwnership copies the owner and group of a copied entry when
+// PreserveOwnership is set
+func (ds *DirectorySync) preserveOwnership(src, dst string, file FileInfo) error {
+	if !ds.PreserveOwnership {
+		return nil
+	}
+	if err := copyOwnership(src, dst, file.Mode); err != nil {
+		return fmt.Errorf("error setting owner of %s: %v", file.Path, err)
+	}
 	return nil
 }
 
-// copyFile copies a file from src to dst
-func copyFile(src, dst string) error {
+// preserveModTime stamps dst with the modification time scanned for file,
+// so the next sync sees the same LastModified on both sides
+func preserveModTime(dst string, file FileInfo) error {
+	if file.LastModified.IsZero() {
+		return nil
+	}
+	return os.Chtimes(dst, file.LastModified, file.LastModified)
+}
+
+// copyFile copies a file from src to dst.
+// The destination gets the given mode; a zero mode falls back to the source's current mode.
+func copyFile(src, dst string, mode os.FileMode) error {
 	sourceFile, err := os.Open(src)
 	if err != nil {
 		return err
@@ -267,30 +1774,55 @@ func copyFile(src, dst string) error {
 	}
 
 	// Copy file permissions
-	sourceInfo, err := os.Stat(src)
-	if err != nil {
-		return err
+	if mode == 0 {
+		sourceInfo, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		mode = sourceInfo.Mode()
 	}
-	return os.Chmod(dst, sourceInfo.Mode())
+	return os.Chmod(dst, mode)
 }
 
 // Main function to show usage
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Println("Usage: go run merkle_sync.go <source_dir> <destination_dir>")
+	oddNode := flag.String("odd-node", OddNodeDuplicate.String(), "odd node strategy: duplicate (Bitcoin) or promote (RFC 6962)")
+	journal := flag.Bool("journal", true, "record finished copies at the destination so an interrupted sync resumes cheaply")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		fmt.Println("Usage: go run merkle_sync.go [-odd-node duplicate|promote] [-journal=false] <source_dir> <destination_dir>")
+		os.Exit(1)
+	}
+
+	strategy, err := ParseOddNodeStrategy(*oddNode)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	sourceDir := os.Args[1]
-	destDir := os.Args[2]
+	sourceDir := flag.Arg(0)
+	destDir := flag.Arg(1)
 
 	syncer := &DirectorySync{
 		SourceDir:      sourceDir,
 		DestinationDir: destDir,
+		TreeOptions:    TreeOptions{OddNodeStrategy: strategy},
+		Journal:        *journal,
 	}
 
-	if err := syncer.SyncDirectories(); err != nil {
+	// Cancel the sync on Ctrl-C or SIGTERM so the current file can finish cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := syncer.SyncDirectoriesContext(ctx); err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Println("Sync interrupted.")
+			stop()
+			os.Exit(exitInterrupted)
+		}
 		fmt.Printf("Error: %v\n", err)
+		stop()
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,1405 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// filePaths returns the paths of a listing, in order
+func filePaths(files []FileInfo) []string {
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	return paths
+}
+
+// Helper to create a fixture directory from a map of relative path -> content
+func createTestDir(t testing.TB, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for relPath, content := range files {
+		fullPath := filepath.Join(root, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create fixture directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write fixture file: %v", err)
+		}
+	}
+	return root
+}
+
+// Helper to compute the Merkle root of a directory
+func dirRoot(t *testing.T, dir string) []byte {
+	t.Helper()
+	ds := &DirectorySync{}
+	files, err := ds.BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+	tree, err := ds.BuildMerkleTree(files)
+	if err != nil {
+		t.Fatalf("BuildMerkleTree failed: %v", err)
+	}
+	return tree.Root
+}
+
+func TestAuditDirectory(t *testing.T) {
+	t.Run("Matching", func(t *testing.T) {
+		dir := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "B"})
+		mismatched, err := AuditDirectory(dir, dirRoot(t, dir))
+		if err != nil {
+			t.Fatalf("Expected no error for matching root, got %v", err)
+		}
+		if len(mismatched) != 0 {
+			t.Errorf("Expected no mismatches, got %v", mismatched)
+		}
+	})
+
+	t.Run("Mismatching", func(t *testing.T) {
+		trusted := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "B"})
+		local := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "tampered"})
+		mismatched, err := AuditDirectory(local, dirRoot(t, trusted))
+		if !errors.Is(err, ErrRootMismatch) {
+			t.Fatalf("Expected ErrRootMismatch, got %v", err)
+		}
+		expected := []string{"a.txt", "sub", "sub/b.txt"}
+		if !slices.Equal(mismatched, expected) {
+			t.Errorf("Expected report %v, got %v", expected, mismatched)
+		}
+	})
+}
+
+func TestSyncPreservesDirectoryMode(t *testing.T) {
+	src := createTestDir(t, map[string]string{"private/secret.txt": "S"})
+	if err := os.Chmod(filepath.Join(src, "private"), 0700); err != nil {
+		t.Fatalf("Failed to chmod fixture directory: %v", err)
+	}
+	dst := t.TempDir()
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dst, "private"))
+	if err != nil {
+		t.Fatalf("Destination directory missing: %v", err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("Expected destination directory mode 0700, got %o", info.Mode().Perm())
+	}
+}
+
+func TestSyncAppliesReadOnlyDirectoryModeAfterContents(t *testing.T) {
+	src := createTestDir(t, map[string]string{"ro/a.txt": "A", "ro/inner/b.txt": "B"})
+	for _, dir := range []string{"ro/inner", "ro"} {
+		if err := os.Chmod(filepath.Join(src, dir), 0555); err != nil {
+			t.Fatalf("Failed to chmod fixture directory: %v", err)
+		}
+	}
+	dst := t.TempDir()
+	t.Cleanup(func() {
+		for _, root := range []string{src, dst} {
+			os.Chmod(filepath.Join(root, "ro"), 0755)
+			os.Chmod(filepath.Join(root, "ro", "inner"), 0755)
+		}
+	})
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst}
+	syncer.copier = func(srcPath, dstPath string, mode os.FileMode) error {
+		info, err := os.Stat(filepath.Dir(dstPath))
+		if err != nil {
+			return err
+		}
+		if info.Mode().Perm()&0200 == 0 {
+			t.Errorf("Directory of %s not writable while copying, mode %o", dstPath, info.Mode().Perm())
+		}
+		data, err := os.ReadFile(srcPath)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dstPath, data, mode)
+	}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	for _, dir := range []string{"ro", "ro/inner"} {
+		info, err := os.Stat(filepath.Join(dst, dir))
+		if err != nil {
+			t.Fatalf("Destination directory missing: %v", err)
+		}
+		if info.Mode().Perm() != 0555 {
+			t.Errorf("Expected %s mode 0555, got %o", dir, info.Mode().Perm())
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "ro", "inner", "b.txt")); err != nil || string(data) != "B" {
+		t.Errorf("Expected ro/inner/b.txt to be copied, got %q, %v", data, err)
+	}
+}
+
+func TestMakeDirFallsBackToDefaultDirMode(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "created")
+	syncer := &DirectorySync{DefaultDirMode: 0750}
+	if err := syncer.makeDir(dir, 0); err != nil {
+		t.Fatalf("makeDir failed: %v", err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("Directory missing: %v", err)
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Errorf("Expected fallback mode 0750, got %o", info.Mode().Perm())
+	}
+}
+
+func TestBuildDirectoryTreePopulatesMode(t *testing.T) {
+	dir := createTestDir(t, map[string]string{"run.sh": "#!/bin/sh", "sub/data.txt": "D"})
+	if err := os.Chmod(filepath.Join(dir, "run.sh"), 0751); err != nil {
+		t.Fatalf("Failed to chmod fixture file: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "sub"), 0710); err != nil {
+		t.Fatalf("Failed to chmod fixture directory: %v", err)
+	}
+
+	ds := &DirectorySync{}
+	files, err := ds.BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+
+	expected := map[string]os.FileMode{
+		"run.sh":       0751,
+		"sub":          os.ModeDir | 0710,
+		"sub/data.txt": 0644,
+	}
+	for _, file := range files {
+		if file.Mode != expected[file.Path] {
+			t.Errorf("Mode mismatch for %s. Expected %v, got %v", file.Path, expected[file.Path], file.Mode)
+		}
+	}
+}
+
+func TestCopyFileUsesMode(t *testing.T) {
+	dir := createTestDir(t, map[string]string{"src.txt": "content"})
+	dst := filepath.Join(dir, "dst.txt")
+	if err := copyFile(filepath.Join(dir, "src.txt"), dst, 0600); err != nil {
+		t.Fatalf("copyFile failed: %v", err)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatalf("Destination file missing: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected destination mode 0600, got %o", info.Mode().Perm())
+	}
+}
+
+func TestSyncPreservesModTime(t *testing.T) {
+	src := createTestDir(t, map[string]string{"old.txt": "O", "sub/older.txt": "P"})
+	modTime := time.Now().Add(-72 * time.Hour).Truncate(time.Second)
+	for _, name := range []string{"old.txt", "sub/older.txt"} {
+		if err := os.Chtimes(filepath.Join(src, name), modTime, modTime); err != nil {
+			t.Fatalf("Failed to set fixture mtime: %v", err)
+		}
+	}
+	dst := t.TempDir()
+
+	for _, batch := range []bool{false, true} {
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, BatchSmallFiles: batch}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+		for _, name := range []string{"old.txt", "sub/older.txt"} {
+			info, err := os.Stat(filepath.Join(dst, name))
+			if err != nil {
+				t.Fatalf("Destination file missing: %v", err)
+			}
+			if diff := info.ModTime().Sub(modTime).Abs(); diff > time.Second {
+				t.Errorf("Expected %s mtime %v, got %v (batch=%v)", name, modTime, info.ModTime(), batch)
+			}
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			t.Fatalf("Failed to reset destination: %v", err)
+		}
+		if err := os.Mkdir(dst, 0755); err != nil {
+			t.Fatalf("Failed to reset destination: %v", err)
+		}
+	}
+}
+
+func TestSyncDedupeByHash(t *testing.T) {
+	src := createTestDir(t, map[string]string{
+		"a.txt":     "same",
+		"b.txt":     "same",
+		"sub/c.txt": "same",
+		"d.txt":     "different",
+	})
+	dst := t.TempDir()
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, DedupeByHash: true}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	stat := func(relPath string) os.FileInfo {
+		info, err := os.Stat(filepath.Join(dst, relPath))
+		if err != nil {
+			t.Fatalf("Destination file %s missing: %v", relPath, err)
+		}
+		return info
+	}
+	first := stat("a.txt")
+	for _, relPath := range []string{"b.txt", "sub/c.txt"} {
+		if !os.SameFile(first, stat(relPath)) {
+			t.Errorf("Expected %s to be hard-linked to a.txt", relPath)
+		}
+	}
+	if os.SameFile(first, stat("d.txt")) {
+		t.Errorf("Expected d.txt to be a separate copy")
+	}
+	if !slices.Equal(dirRoot(t, src), dirRoot(t, dst)) {
+		t.Errorf("Destination root differs from source root after deduplicated sync")
+	}
+}
+
+func TestSyncDirectoriesContextCancelled(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "B"})
+	dst := createTestDir(t, map[string]string{"stale.txt": "S"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst}
+	if err := syncer.SyncDirectoriesContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected no files copied after cancellation, got err=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "stale.txt")); err != nil {
+		t.Errorf("Expected no deletions after cancellation, got err=%v", err)
+	}
+}
+
+func TestSyncDirectoriesContextCancelledAfterFirstCopy(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A", "b.txt": "B", "c.txt": "C", "d.txt": "D"})
+	dst := createTestDir(t, map[string]string{"stale.txt": "S"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var copies atomic.Int32
+	syncer := &DirectorySync{
+		SourceDir:       src,
+		DestinationDir:  dst,
+		CopyConcurrency: 1,
+		copier: func(src, dst string, mode os.FileMode) error {
+			copies.Add(1)
+			defer cancel()
+			return copyFile(src, dst, mode)
+		},
+	}
+	if err := syncer.SyncDirectoriesContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	if n := copies.Load(); n != 1 {
+		t.Errorf("Expected only the first copy to run, got %d", n)
+	}
+	entries, _ := os.ReadDir(dst)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if !slices.Equal(names, []string{"a.txt", "stale.txt"}) {
+		t.Errorf("Expected only a.txt to be copied and nothing deleted, got %v", names)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "a.txt")); string(data) != "A" {
+		t.Errorf("Expected the in-flight copy to finish, got %q", data)
+	}
+}
+
+func TestSyncDirectoriesContextCancelledDuringCopy(t *testing.T) {
+	data := strings.Repeat("0123456789abcdef", 64*1024) // 1 MiB
+	src := createTestDir(t, map[string]string{"big.bin": data})
+	dst := createTestDir(t, map[string]string{"big.bin": "old contents"})
+
+	// At 1 MiB/s the copy takes about a second, so it is cancelled midway
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, RateLimitBytesPerSec: 1 << 20}
+	start := time.Now()
+	if err := syncer.SyncDirectoriesContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 600*time.Millisecond {
+		t.Errorf("Expected the copy to stop soon after cancellation, took %v", elapsed)
+	}
+
+	// The old file is untouched and no temporary file is left behind
+	if got, _ := os.ReadFile(filepath.Join(dst, "big.bin")); string(got) != "old contents" {
+		t.Errorf("Expected the destination file to be left as it was, got %d bytes", len(got))
+	}
+	entries, _ := os.ReadDir(dst)
+	if len(entries) != 1 {
+		t.Errorf("Expected only big.bin in the destination, got %v", entries)
+	}
+}
+
+func TestSyncDirectoriesContextCancelledDuringScan(t *testing.T) {
+	src := createManyFiles(t, 16)
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var hashed atomic.Int32
+	syncer := &DirectorySync{
+		SourceDir:      src,
+		DestinationDir: t.TempDir(),
+		SkipDirFunc: func(path string, entries int) bool {
+			cancel()
+			return false
+		},
+		hasher: func(path string) ([]byte, error) {
+			hashed.Add(1)
+			return hashFile(path)
+		},
+	}
+	if err := syncer.SyncDirectoriesContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if n := hashed.Load(); n != 0 {
+		t.Errorf("Expected no files hashed after the walk was cancelled, got %d", n)
+	}
+}
+
+func TestExportChecksums(t *testing.T) {
+	// Expected lines produced by coreutils `sha256sum` for the same files
+	src := createTestDir(t, map[string]string{"hello.txt": "hello\n", `sub/a\b`: "x"})
+
+	var buf bytes.Buffer
+	syncer := &DirectorySync{SourceDir: src}
+	if err := syncer.ExportChecksums(&buf); err != nil {
+		t.Fatalf("ExportChecksums failed: %v", err)
+	}
+
+	expected := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03  hello.txt\n" +
+		`\2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881  sub/a\\b` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Checksum output mismatch.\nExpected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
+
+// concurrencyObserver records the highest number of simultaneous calls it sees
+type concurrencyObserver struct {
+	current atomic.Int32
+	peak    atomic.Int32
+}
+
+func (o *concurrencyObserver) enter() {
+	n := o.current.Add(1)
+	for {
+		peak := o.peak.Load()
+		if n <= peak || o.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	// Hold the slot briefly so overlapping calls are observable
+	time.Sleep(5 * time.Millisecond)
+}
+
+func (o *concurrencyObserver) exit() {
+	o.current.Add(-1)
+}
+
+// Helper to create a flat fixture directory with count distinct files
+func createManyFiles(t testing.TB, count int) string {
+	t.Helper()
+	files := make(map[string]string, count)
+	for i := range count {
+		files[fmt.Sprintf("file%03d.txt", i)] = fmt.Sprintf("content %d", i)
+	}
+	return createTestDir(t, files)
+}
+
+func TestHashConcurrencyLimit(t *testing.T) {
+	dir := createManyFiles(t, 24)
+	for _, limit := range []int{1, 3} {
+		observer := &concurrencyObserver{}
+		ds := &DirectorySync{
+			HashConcurrency: limit,
+			hasher: func(path string) ([]byte, error) {
+				observer.enter()
+				defer observer.exit()
+				return hashFile(path)
+			},
+		}
+		if _, err := ds.BuildDirectoryTree(dir); err != nil {
+			t.Fatalf("BuildDirectoryTree failed: %v", err)
+		}
+		if peak := observer.peak.Load(); peak != int32(limit) {
+			t.Errorf("Expected peak hash concurrency %d, got %d", limit, peak)
+		}
+	}
+}
+
+func TestCopyConcurrencyLimit(t *testing.T) {
+	src := createManyFiles(t, 24)
+	for _, limit := range []int{1, 3} {
+		observer := &concurrencyObserver{}
+		ds := &DirectorySync{
+			SourceDir:       src,
+			DestinationDir:  t.TempDir(),
+			CopyConcurrency: limit,
+			copier: func(src, dst string, mode os.FileMode) error {
+				observer.enter()
+				defer observer.exit()
+				return copyFile(src, dst, mode)
+			},
+		}
+		if err := ds.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+		if peak := observer.peak.Load(); peak != int32(limit) {
+			t.Errorf("Expected peak copy concurrency %d, got %d", limit, peak)
+		}
+		if !slices.Equal(dirRoot(t, src), dirRoot(t, ds.DestinationDir)) {
+			t.Errorf("Destination root differs from source root after parallel sync")
+		}
+	}
+}
+
+func TestBuildDirectoryTreeHashErrorStopsScan(t *testing.T) {
+	dir := createManyFiles(t, 8)
+	hashErr := errors.New("hash failed")
+	ds := &DirectorySync{hasher: func(string) ([]byte, error) { return nil, hashErr }}
+	if _, err := ds.BuildDirectoryTree(dir); !errors.Is(err, hashErr) {
+		t.Errorf("Expected hash error to be returned, got %v", err)
+	}
+}
+
+func TestBuildDirectoryTreeFileModifiedDuringScan(t *testing.T) {
+	// swapHasher replaces the file's contents before hashing it, on every call
+	// up to swaps, as if a writer raced the scan
+	swapHasher := func(swaps int) func(string) ([]byte, error) {
+		calls := 0
+		return func(path string) ([]byte, error) {
+			if calls < swaps {
+				calls++
+				if err := os.WriteFile(path, []byte(strings.Repeat("x", 10+calls)), 0644); err != nil {
+					return nil, err
+				}
+			}
+			return hashFile(path)
+		}
+	}
+
+	t.Run("RehashedOnce", func(t *testing.T) {
+		dir := createTestDir(t, map[string]string{"file.txt": "original"})
+		ds := &DirectorySync{hasher: swapHasher(1)}
+		files, err := ds.BuildDirectoryTree(dir)
+		if err != nil {
+			t.Fatalf("BuildDirectoryTree failed: %v", err)
+		}
+		want := sha256.Sum256([]byte(strings.Repeat("x", 11)))
+		if files[0].Size != 11 || !bytes.Equal(files[0].Hash, want[:]) {
+			t.Errorf("Expected the entry to describe the new contents, got size %d", files[0].Size)
+		}
+		if len(ds.Unstable) != 0 {
+			t.Errorf("Expected no unstable entries, got %v", ds.Unstable)
+		}
+	})
+
+	t.Run("FlaggedUnstable", func(t *testing.T) {
+		dir := createTestDir(t, map[string]string{"file.txt": "original", "calm.txt": "calm"})
+		swap := swapHasher(2)
+		ds := &DirectorySync{hasher: func(path string) ([]byte, error) {
+			if filepath.Base(path) == "file.txt" {
+				return swap(path)
+			}
+			return hashFile(path)
+		}}
+		if _, err := ds.BuildDirectoryTree(dir); err != nil {
+			t.Fatalf("BuildDirectoryTree failed: %v", err)
+		}
+		if !slices.Equal(ds.Unstable, []string{"file.txt"}) {
+			t.Errorf("Expected file.txt to be flagged unstable, got %v", ds.Unstable)
+		}
+	})
+}
+
+func TestBuildDirectoryTreeParallelMatchesSerial(t *testing.T) {
+	dir := createManyFiles(t, 64)
+	if err := os.MkdirAll(filepath.Join(dir, "nested", "deeper"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "nested", "deeper", "leaf.txt"), []byte("leaf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := (&DirectorySync{HashConcurrency: 1}).BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("Serial BuildDirectoryTree failed: %v", err)
+	}
+	parallel, err := (&DirectorySync{HashConcurrency: 8}).BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("Parallel BuildDirectoryTree failed: %v", err)
+	}
+	if !reflect.DeepEqual(serial, parallel) {
+		t.Errorf("Expected parallel hashing to return the same listing as serial hashing")
+	}
+}
+
+func BenchmarkBuildDirectoryTree(b *testing.B) {
+	dir := createManyFiles(b, 200)
+	for _, concurrency := range []int{1, runtime.NumCPU()} {
+		b.Run(fmt.Sprintf("HashConcurrency%d", concurrency), func(b *testing.B) {
+			ds := &DirectorySync{HashConcurrency: concurrency}
+			for i := 0; i < b.N; i++ {
+				if _, err := ds.BuildDirectoryTree(dir); err != nil {
+					b.Fatalf("BuildDirectoryTree failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecompressBeforeHash(t *testing.T) {
+	dir := createTestDir(t, map[string]string{"data.txt": "compressible compressible compressible"})
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte("compressible compressible compressible"))
+	gz.Close()
+	if err := os.WriteFile(filepath.Join(dir, "data.txt.gz"), compressed.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write gzip fixture: %v", err)
+	}
+
+	hashes := func(ds *DirectorySync) map[string][]byte {
+		files, err := ds.BuildDirectoryTree(dir)
+		if err != nil {
+			t.Fatalf("BuildDirectoryTree failed: %v", err)
+		}
+		byPath := make(map[string][]byte)
+		for _, file := range files {
+			byPath[file.Path] = file.Hash
+		}
+		return byPath
+	}
+
+	plain := hashes(&DirectorySync{})
+	if bytes.Equal(plain["data.txt"], plain["data.txt.gz"]) {
+		t.Errorf("Expected file and gzip to differ without DecompressBeforeHash")
+	}
+	decompressed := hashes(&DirectorySync{DecompressBeforeHash: true})
+	if !bytes.Equal(decompressed["data.txt"], decompressed["data.txt.gz"]) {
+		t.Errorf("Expected file and gzip to compare equal with DecompressBeforeHash")
+	}
+
+	// Invalid gzip files don't abort the scan but are hashed as they are
+	truncated := compressed.Bytes()[:compressed.Len()-4]
+	invalid := map[string][]byte{"mislabelled.gz": []byte("not gzip"), "truncated.gz": truncated, "empty.gz": nil}
+	for name, data := range invalid {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decompressed = hashes(&DirectorySync{DecompressBeforeHash: true})
+	for name, data := range invalid {
+		if raw := sha256.Sum256(data); !bytes.Equal(decompressed[name], raw[:]) {
+			t.Errorf("Expected %s to be hashed as raw bytes", name)
+		}
+	}
+}
+
+func TestSyncVerifyOnComplete(t *testing.T) {
+	src := createTestDir(t, map[string]string{"good.txt": "good", "bad.txt": "expected"})
+
+	// Silently corrupts one file while reporting success
+	corruptingCopier := func(src, dst string, mode os.FileMode) error {
+		if filepath.Base(src) == "bad.txt" {
+			return os.WriteFile(dst, []byte("garbage"), mode)
+		}
+		return copyFile(src, dst, mode)
+	}
+
+	t.Run("CatchesCorruptCopy", func(t *testing.T) {
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: t.TempDir(), VerifyOnComplete: true, copier: corruptingCopier}
+		err := syncer.SyncDirectories()
+		if !errors.Is(err, ErrSyncVerificationFailed) {
+			t.Fatalf("Expected ErrSyncVerificationFailed, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "bad.txt") || strings.Contains(err.Error(), "good.txt") {
+			t.Errorf("Expected only bad.txt to be reported, got %v", err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: t.TempDir(), copier: corruptingCopier}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Errorf("Expected corruption to go unnoticed without verification, got %v", err)
+		}
+	})
+
+	t.Run("CleanSync", func(t *testing.T) {
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: t.TempDir(), VerifyOnComplete: true}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Errorf("Expected verified sync to succeed, got %v", err)
+		}
+	})
+}
+
+func TestSyncDestPrefix(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "B"})
+	dst := createTestDir(t, map[string]string{
+		"unrelated.txt":            "keep me",
+		"backup-2024/stale.txt":    "delete me",
+		"backup-2023/old/data.txt": "keep me too",
+	})
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, DestPrefix: "backup-2024"}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	if !bytes.Equal(dirRoot(t, src), dirRoot(t, filepath.Join(dst, "backup-2024"))) {
+		t.Errorf("Prefixed destination does not mirror the source")
+	}
+	for _, relPath := range []string{"unrelated.txt", "backup-2023/old/data.txt"} {
+		if _, err := os.Stat(filepath.Join(dst, relPath)); err != nil {
+			t.Errorf("Expected %s outside the prefix to survive: %v", relPath, err)
+		}
+	}
+
+	t.Run("NewPrefix", func(t *testing.T) {
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, DestPrefix: "nested/new"}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dst, "nested", "new", "sub", "b.txt")); err != nil {
+			t.Errorf("Expected files under new prefix: %v", err)
+		}
+	})
+
+	t.Run("EscapingPrefix", func(t *testing.T) {
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, DestPrefix: "../outside"}
+		if err := syncer.SyncDirectories(); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("Expected ErrUnsafePath, got %v", err)
+		}
+	})
+}
+
+func TestBuildDirectoryTreeDetectsDirectoryLoops(t *testing.T) {
+	dir := createTestDir(t, map[string]string{
+		"a.txt":          "A",
+		"real/b.txt":     "should not be walked",
+		"mount/loop.txt": "L",
+	})
+
+	// Pretend "real" is a bind mount of "mount" by reporting the same inode.
+	// The walk is lexical, so "mount" is seen first and "real" is the revisit.
+	ds := &DirectorySync{identifier: func(info os.FileInfo) (fileID, bool) {
+		if info.IsDir() && (info.Name() == "mount" || info.Name() == "real") {
+			return fileID{device: 1, inode: 42}, true
+		}
+		return identifyFile(info)
+	}}
+
+	files, err := ds.BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+	paths := filePaths(files)
+	expected := []string{"a.txt", "mount", "mount/loop.txt"}
+	if !slices.Equal(paths, expected) {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+	if !slices.Equal(ds.DirectoryLoops, []string{"real"}) {
+		t.Errorf("Expected loop at real to be reported, got %v", ds.DirectoryLoops)
+	}
+}
+
+func TestSyncMaxOpenFiles(t *testing.T) {
+	src := createManyFiles(t, 24)
+	observer := &concurrencyObserver{}
+	ds := &DirectorySync{
+		SourceDir:       src,
+		DestinationDir:  t.TempDir(),
+		CopyConcurrency: 8,
+		MaxOpenFiles:    2,
+		copier: func(src, dst string, mode os.FileMode) error {
+			observer.enter()
+			defer observer.exit()
+			return copyFile(src, dst, mode)
+		},
+	}
+	if err := ds.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if peak := observer.peak.Load(); peak > 2 {
+		t.Errorf("Expected at most 2 open file pairs, observed %d", peak)
+	}
+	if !bytes.Equal(dirRoot(t, src), dirRoot(t, ds.DestinationDir)) {
+		t.Errorf("Destination root differs from source root")
+	}
+}
+
+func TestSyncMaxOpenFilesCancelledWhileWaiting(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A", "b.txt": "B"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first copy holds the only slot until after the cancellation, so the
+	// second one is still waiting for it when ctx is cancelled
+	entered := make(chan struct{}, 2)
+	var copies atomic.Int32
+	ds := &DirectorySync{
+		SourceDir:       src,
+		DestinationDir:  t.TempDir(),
+		CopyConcurrency: 2,
+		MaxOpenFiles:    1,
+		copier: func(src, dst string, mode os.FileMode) error {
+			copies.Add(1)
+			entered <- struct{}{}
+			<-ctx.Done()
+			time.Sleep(20 * time.Millisecond)
+			return copyFile(src, dst, mode)
+		},
+	}
+	go func() {
+		<-entered
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if err := ds.SyncDirectoriesContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if n := copies.Load(); n != 1 {
+		t.Errorf("Expected the waiting copy to give up on cancellation, got %d copies", n)
+	}
+}
+
+func TestBuildMerkleTreeCacheLeafHashes(t *testing.T) {
+	dir := createManyFiles(t, 8)
+	ds := &DirectorySync{CacheLeafHashes: true}
+	files, err := ds.BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+	if _, err := ds.BuildMerkleTree(files); err != nil {
+		t.Fatalf("BuildMerkleTree failed: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "added.txt"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, err = ds.BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+
+	counter := &atomic.Int64{}
+	hashOpCounter = counter
+	defer func() { hashOpCounter = nil }()
+	tree, err := ds.BuildMerkleTree(files)
+	if err != nil {
+		t.Fatalf("BuildMerkleTree failed: %v", err)
+	}
+	// Only the added file's leaf is hashed; the 8 cached leaves are reused
+	if want := int64(HashOpCount(len(files)) - 8); counter.Load() != want {
+		t.Errorf("Expected %d hash operations, got %d", want, counter.Load())
+	}
+	if !bytes.Equal(tree.Root, dirRoot(t, dir)) {
+		t.Errorf("Cached tree root differs from an uncached build")
+	}
+
+	// Leaves cached under other options must not be reused
+	for _, opts := range []TreeOptions{{DomainSeparation: true}, {Hash: sha512.New}, {}} {
+		ds.TreeOptions = opts
+		tree, err := ds.BuildMerkleTree(files)
+		if err != nil {
+			t.Fatalf("BuildMerkleTree failed: %v", err)
+		}
+		uncached, _ := (&DirectorySync{TreeOptions: opts}).BuildMerkleTree(files)
+		if !bytes.Equal(tree.Root, uncached.Root) {
+			t.Errorf("%+v: expected the cached root to follow the new options", opts)
+		}
+	}
+}
+
+func BenchmarkBuildMerkleTreeOneFileAdded(b *testing.B) {
+	files := make([]FileInfo, 20000)
+	for i := range files {
+		files[i] = FileInfo{Path: fmt.Sprintf("file%05d.txt", i), Hash: hashData([]byte(fmt.Sprintf("content %d", i)))}
+	}
+	added := append(slices.Clone(files), FileInfo{Path: "zzz.txt", Hash: hashData([]byte("new"))})
+
+	for _, cached := range []bool{false, true} {
+		b.Run(fmt.Sprintf("CacheLeafHashes=%v", cached), func(b *testing.B) {
+			ds := &DirectorySync{CacheLeafHashes: cached}
+			ds.BuildMerkleTree(files)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := ds.BuildMerkleTree(added); err != nil {
+					b.Fatalf("BuildMerkleTree failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncQuiescenceWindow(t *testing.T) {
+	src := createTestDir(t, map[string]string{"settled.txt": "old", "writing.txt": "in progress"})
+	dst := t.TempDir()
+	anHourAgo := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "settled.txt"), anHourAgo, anHourAgo); err != nil {
+		t.Fatal(err)
+	}
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, QuiescenceWindow: time.Minute, VerifyOnComplete: true}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "settled.txt")); err != nil {
+		t.Errorf("Expected settled file to be copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "writing.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected recently modified file to be deferred, got %v", err)
+	}
+	if !slices.Equal(syncer.Deferred, []string{"writing.txt"}) {
+		t.Errorf("Expected writing.txt to be reported as deferred, got %v", syncer.Deferred)
+	}
+
+	// Once the file settles, the next sync copies it
+	if err := os.Chtimes(filepath.Join(src, "writing.txt"), anHourAgo, anHourAgo); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if len(syncer.Deferred) != 0 || !bytes.Equal(dirRoot(t, src), dirRoot(t, dst)) {
+		t.Errorf("Expected the settled file to sync, deferred %v", syncer.Deferred)
+	}
+}
+
+func TestBuildMerkleTreeLeafEncoder(t *testing.T) {
+	dir := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "B"})
+	pathEncoder := func(file FileInfo) []byte {
+		return append([]byte(file.Path+"\x00"), leafData(file)...)
+	}
+	root := func(encoder func(FileInfo) []byte) []byte {
+		ds := &DirectorySync{LeafEncoder: encoder}
+		files, err := ds.BuildDirectoryTree(dir)
+		if err != nil {
+			t.Fatalf("BuildDirectoryTree failed: %v", err)
+		}
+		tree, err := ds.BuildMerkleTree(files)
+		if err != nil {
+			t.Fatalf("BuildMerkleTree failed: %v", err)
+		}
+		return tree.Root
+	}
+
+	defaultRoot, encodedRoot := root(nil), root(pathEncoder)
+	if !bytes.Equal(defaultRoot, dirRoot(t, dir)) {
+		t.Errorf("Expected a nil encoder to keep the default leaves")
+	}
+	if bytes.Equal(encodedRoot, defaultRoot) {
+		t.Errorf("Expected the custom encoder to produce a different root")
+	}
+	if !bytes.Equal(encodedRoot, root(pathEncoder)) {
+		t.Errorf("Expected the custom encoder to produce a stable root")
+	}
+
+	// Renaming keeps every content hash, so only the path-binding encoder notices
+	if err := os.Rename(filepath.Join(dir, "a.txt"), filepath.Join(dir, "renamed.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(root(nil), defaultRoot) {
+		t.Errorf("Expected the default root to ignore the rename")
+	}
+	if bytes.Equal(root(pathEncoder), encodedRoot) {
+		t.Errorf("Expected the path-binding encoder to detect the rename")
+	}
+}
+
+func TestBuildMerkleTreeLeafEncoderMetadata(t *testing.T) {
+	dir := createTestDir(t, map[string]string{"run.sh": "#!/bin/sh"})
+	metadataEncoder := func(file FileInfo) []byte {
+		return fmt.Appendf(leafData(file), "\x00%s\x00%d\x00%o\x00%d", file.Path, file.Size, file.Mode, file.LastModified.UnixNano())
+	}
+	roots := func() (defaultRoot, encodedRoot []byte) {
+		ds := &DirectorySync{}
+		files, err := ds.BuildDirectoryTree(dir)
+		if err != nil {
+			t.Fatalf("BuildDirectoryTree failed: %v", err)
+		}
+		defaultTree, _ := ds.BuildMerkleTree(files)
+		ds.LeafEncoder = metadataEncoder
+		encodedTree, _ := ds.BuildMerkleTree(files)
+		return defaultTree.Root, encodedTree.Root
+	}
+
+	defaultBefore, encodedBefore := roots()
+	if err := os.Chmod(filepath.Join(dir, "run.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defaultChmod, encodedChmod := roots()
+	if !bytes.Equal(defaultChmod, defaultBefore) {
+		t.Errorf("Expected the default root to ignore the chmod")
+	}
+	if bytes.Equal(encodedChmod, encodedBefore) {
+		t.Errorf("Expected the metadata encoder to detect the chmod")
+	}
+
+	touched := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "run.sh"), touched, touched); err != nil {
+		t.Fatal(err)
+	}
+	defaultTouch, encodedTouch := roots()
+	if !bytes.Equal(defaultTouch, defaultBefore) {
+		t.Errorf("Expected the default root to ignore the new mtime")
+	}
+	if bytes.Equal(encodedTouch, encodedChmod) {
+		t.Errorf("Expected the metadata encoder to detect the new mtime")
+	}
+}
+
+func TestIgnoreDirectories(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "B"})
+	dst := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "B"})
+	if err := os.MkdirAll(filepath.Join(src, "empty", "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dst, "extra"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	roots := func(ds *DirectorySync) ([]byte, []byte) {
+		var roots [][]byte
+		for _, dir := range []string{src, dst} {
+			files, _ := ds.BuildDirectoryTree(dir)
+			tree, err := ds.BuildMerkleTree(files)
+			if err != nil {
+				t.Fatalf("BuildMerkleTree failed: %v", err)
+			}
+			roots = append(roots, tree.Root)
+		}
+		return roots[0], roots[1]
+	}
+	if srcRoot, dstRoot := roots(&DirectorySync{}); bytes.Equal(srcRoot, dstRoot) {
+		t.Errorf("Expected directory differences to change the root by default")
+	}
+	if srcRoot, dstRoot := roots(&DirectorySync{IgnoreDirectories: true}); !bytes.Equal(srcRoot, dstRoot) {
+		t.Errorf("Expected equal roots when directories are ignored")
+	}
+
+	t.Run("Sync", func(t *testing.T) {
+		fresh := t.TempDir()
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: fresh, IgnoreDirectories: true, VerifyOnComplete: true}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(fresh, "sub", "b.txt")); err != nil {
+			t.Errorf("Expected parent directory to be created with the file: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(fresh, "empty")); !os.IsNotExist(err) {
+			t.Errorf("Expected empty directory to be ignored, got %v", err)
+		}
+	})
+}
+
+func TestSyncCopyFailureSkipsDeletions(t *testing.T) {
+	failingCopier := func(src, dst string, mode os.FileMode) error {
+		if filepath.Base(src) == "unreadable.txt" {
+			return errors.New("input/output error")
+		}
+		return copyFile(src, dst, mode)
+	}
+
+	for _, continueOnError := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ContinueOnError=%v", continueOnError), func(t *testing.T) {
+			// "unreadable.txt" was renamed from "old.txt" in the source
+			src := createTestDir(t, map[string]string{"unreadable.txt": "data", "a.txt": "A", "b.txt": "B"})
+			dst := createTestDir(t, map[string]string{"old.txt": "data", "stale.txt": "S"})
+			syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, ContinueOnError: continueOnError, copier: failingCopier}
+
+			err := syncer.SyncDirectories()
+			if err == nil {
+				t.Fatalf("Expected the failed copy to be reported")
+			}
+			for _, kept := range []string{"old.txt", "stale.txt"} {
+				if _, err := os.Stat(filepath.Join(dst, kept)); err != nil {
+					t.Errorf("Expected %s to survive a failed sync: %v", kept, err)
+				}
+			}
+			if !continueOnError {
+				return
+			}
+			if !errors.Is(err, ErrCopyFailed) || !slices.Equal(syncer.FailedCopies, []string{"unreadable.txt"}) {
+				t.Errorf("Expected ErrCopyFailed for unreadable.txt, got %v, %v", err, syncer.FailedCopies)
+			}
+			for _, copied := range []string{"a.txt", "b.txt"} {
+				if _, err := os.Stat(filepath.Join(dst, copied)); err != nil {
+					t.Errorf("Expected %s to be copied despite the failure: %v", copied, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncKeepsMetadataFiles(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A"})
+	dst := createTestDir(t, map[string]string{
+		journalName:          "journal",
+		"stale.txt":          "S",
+		"sub/" + journalName: "not metadata below the root",
+	})
+
+	plan, err := (&DirectorySync{SourceDir: src, DestinationDir: dst}).Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	for _, action := range plan {
+		if action.Action == "delete" && isMetadataFile(action.Path) {
+			t.Errorf("Metadata file %s flagged for deletion", action.Path)
+		}
+	}
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, VerifyOnComplete: true}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	for _, name := range metadataNames {
+		if _, err := os.Stat(filepath.Join(dst, name)); err != nil {
+			t.Errorf("Expected %s to survive the sync: %v", name, err)
+		}
+	}
+	for _, removed := range []string{"stale.txt", "sub"} {
+		if _, err := os.Stat(filepath.Join(dst, removed)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be deleted, got %v", removed, err)
+		}
+	}
+	if !bytes.Equal(dirRoot(t, src), dirRoot(t, dst)) {
+		t.Errorf("Expected metadata files to be left out of the destination root")
+	}
+}
+
+func TestSyncPathMapper(t *testing.T) {
+	t.Run("Flatten", func(t *testing.T) {
+		src := createTestDir(t, map[string]string{"a/b/c.txt": "C", "d/e.txt": "E"})
+		dst := createTestDir(t, map[string]string{"stale.txt": "S", "c.txt": "old"})
+		flatten := func(relPath string) string {
+			if filepath.Ext(relPath) == "" {
+				return "" // Leave directories out
+			}
+			return filepath.Base(relPath)
+		}
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, PathMapper: flatten, VerifyOnComplete: true}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+		for name, want := range map[string]string{"c.txt": "C", "e.txt": "E"} {
+			got, err := os.ReadFile(filepath.Join(dst, name))
+			if err != nil || string(got) != want {
+				t.Errorf("Expected %s to contain %q, got %q, %v", name, want, got, err)
+			}
+		}
+		entries, _ := os.ReadDir(dst)
+		if len(entries) != 2 {
+			t.Errorf("Expected only the flattened files in the destination, got %d entries", len(entries))
+		}
+	})
+
+	t.Run("Collision", func(t *testing.T) {
+		src := createTestDir(t, map[string]string{"x/same.txt": "1", "y/same.txt": "2"})
+		dst := t.TempDir()
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, PathMapper: filepath.Base}
+		if err := syncer.SyncDirectories(); !errors.Is(err, ErrPathCollision) {
+			t.Fatalf("Expected ErrPathCollision, got %v", err)
+		}
+		if entries, _ := os.ReadDir(dst); len(entries) != 0 {
+			t.Errorf("Expected nothing to be copied after a collision, got %d entries", len(entries))
+		}
+	})
+
+	t.Run("Identity", func(t *testing.T) {
+		src := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "B"})
+		dst := createTestDir(t, map[string]string{"stale/c.txt": "C"})
+		identity := func(relPath string) string { return relPath }
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, PathMapper: identity}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+		if !bytes.Equal(dirRoot(t, src), dirRoot(t, dst)) {
+			t.Errorf("Expected an identity mapper to mirror the source")
+		}
+	})
+}
+
+func TestCompareTreesDetailed(t *testing.T) {
+	src := createTestDir(t, map[string]string{"same.txt": "S", "changed.txt": "new", "added.txt": "A", "dir/inner.txt": "I"})
+	dst := createTestDir(t, map[string]string{"same.txt": "S", "changed.txt": "old", "removed.txt": "R", "dir/inner.txt": "I"})
+
+	ds := &DirectorySync{}
+	sourceFiles, _ := ds.BuildDirectoryTree(src)
+	destFiles, _ := ds.BuildDirectoryTree(dst)
+	diff, err := ds.CompareTreesDetailed(sourceFiles, destFiles)
+	if err != nil {
+		t.Fatalf("CompareTreesDetailed failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		got, want []string
+	}{
+		{"Added", filePaths(diff.Added), []string{"added.txt"}},
+		{"Modified", filePaths(diff.Modified), []string{"changed.txt"}},
+		{"Deleted", filePaths(diff.Deleted), []string{"removed.txt"}},
+		{"Unchanged", filePaths(diff.Unchanged), []string{"dir", "dir/inner.txt", "same.txt"}},
+	} {
+		if !slices.Equal(tc.got, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, tc.got)
+		}
+	}
+
+	// The wrapper keeps returning the copy and delete lists
+	filesToCopy, filesToDelete, err := ds.CompareTrees(sourceFiles, destFiles)
+	if err != nil {
+		t.Fatalf("CompareTrees failed: %v", err)
+	}
+	if got := filePaths(filesToCopy); !slices.Equal(got, []string{"added.txt", "changed.txt"}) {
+		t.Errorf("Expected added and modified files to be copied, got %v", got)
+	}
+	if !slices.Equal(filesToDelete, []string{"removed.txt"}) {
+		t.Errorf("Expected removed.txt to be deleted, got %v", filesToDelete)
+	}
+}
+
+func TestCompareTreesCaseInsensitivePaths(t *testing.T) {
+	hashOf := func(content string) []byte {
+		hash := sha256.Sum256([]byte(content))
+		return hash[:]
+	}
+	sourceFiles := []FileInfo{
+		{Path: "Docs", IsDir: true},
+		{Path: "Docs/Guide.md", Hash: hashOf("guide")},
+		{Path: "Notes.txt", Hash: hashOf("new notes")},
+		{Path: "Readme.md", Hash: hashOf("readme")},
+	}
+	destFiles := []FileInfo{
+		{Path: "README.md", Hash: hashOf("readme")},
+		{Path: "docs", IsDir: true},
+		{Path: "docs/GUIDE.md", Hash: hashOf("guide")},
+		{Path: "notes.txt", Hash: hashOf("old notes")},
+	}
+
+	ds := &DirectorySync{}
+	filesToCopy, filesToDelete, _ := ds.CompareTrees(sourceFiles, destFiles)
+	if len(filesToCopy) != 4 || len(filesToDelete) != 4 {
+		t.Errorf("Expected case-sensitive comparison to copy and delete everything, got %d and %d", len(filesToCopy), len(filesToDelete))
+	}
+
+	ds = &DirectorySync{CaseInsensitivePaths: true}
+	filesToCopy, filesToDelete, err := ds.CompareTrees(sourceFiles, destFiles)
+	if err != nil {
+		t.Fatalf("CompareTrees failed: %v", err)
+	}
+	if len(filesToCopy) != 1 || filesToCopy[0].Path != "Notes.txt" {
+		t.Errorf("Expected only the modified Notes.txt to be copied under its source path, got %v", filesToCopy)
+	}
+	if len(filesToDelete) != 0 {
+		t.Errorf("Expected nothing to be deleted, got %v", filesToDelete)
+	}
+
+	// Two source files would land on the same case-insensitive destination file
+	src := createTestDir(t, map[string]string{"a.txt": "lower", "A.txt": "upper"})
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: t.TempDir(), CaseInsensitivePaths: true}
+	if err := syncer.SyncDirectories(); !errors.Is(err, ErrPathCollision) {
+		t.Errorf("Expected ErrPathCollision, got %v", err)
+	}
+}
+
+func TestEmptyEntries(t *testing.T) {
+	compare := func(src, dst string) ([]string, []string) {
+		t.Helper()
+		ds := &DirectorySync{}
+		sourceFiles, _ := ds.BuildDirectoryTree(src)
+		destFiles, _ := ds.BuildDirectoryTree(dst)
+		filesToCopy, filesToDelete, err := ds.CompareTrees(sourceFiles, destFiles)
+		if err != nil {
+			t.Fatalf("CompareTrees failed: %v", err)
+		}
+		return filePaths(filesToCopy), filesToDelete
+	}
+	mkdir := func(dir, name string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("EmptyFileVsMissingFile", func(t *testing.T) {
+		withEmpty := createTestDir(t, map[string]string{"keep.txt": "K", "empty.txt": ""})
+		without := createTestDir(t, map[string]string{"keep.txt": "K"})
+		if toCopy, toDelete := compare(withEmpty, without); !slices.Equal(toCopy, []string{"empty.txt"}) || len(toDelete) != 0 {
+			t.Errorf("Expected the empty file to be copied, got %v and %v", toCopy, toDelete)
+		}
+		if toCopy, toDelete := compare(without, withEmpty); len(toCopy) != 0 || !slices.Equal(toDelete, []string{"empty.txt"}) {
+			t.Errorf("Expected the empty file to be deleted, got %v and %v", toCopy, toDelete)
+		}
+		if bytes.Equal(dirRoot(t, withEmpty), dirRoot(t, without)) {
+			t.Error("Expected an empty file to change the root")
+		}
+	})
+
+	t.Run("EmptyDirVsMissingDir", func(t *testing.T) {
+		withEmpty := createTestDir(t, map[string]string{"keep.txt": "K"})
+		mkdir(withEmpty, "e")
+		without := createTestDir(t, map[string]string{"keep.txt": "K"})
+		if toCopy, toDelete := compare(withEmpty, without); !slices.Equal(toCopy, []string{"e"}) || len(toDelete) != 0 {
+			t.Errorf("Expected the empty directory to be created, got %v and %v", toCopy, toDelete)
+		}
+		if toCopy, toDelete := compare(without, withEmpty); len(toCopy) != 0 || !slices.Equal(toDelete, []string{"e"}) {
+			t.Errorf("Expected the empty directory to be deleted, got %v and %v", toCopy, toDelete)
+		}
+	})
+
+	t.Run("EmptyDirVsDirWithFiles", func(t *testing.T) {
+		src := createTestDir(t, map[string]string{"keep.txt": "K"})
+		mkdir(src, "d")
+		dst := createTestDir(t, map[string]string{"keep.txt": "K", "d/x.txt": "X"})
+		if toCopy, toDelete := compare(src, dst); len(toCopy) != 0 || !slices.Equal(toDelete, []string{"d/x.txt"}) {
+			t.Errorf("Expected only the directory's file to be deleted, got %v and %v", toCopy, toDelete)
+		}
+
+		// The directory's own leaf records that it is empty
+		ds := &DirectorySync{}
+		sourceFiles, _ := ds.BuildDirectoryTree(src)
+		destFiles, _ := ds.BuildDirectoryTree(dst)
+		emptyTree, _ := ds.BuildMerkleTree(sourceFiles)
+		fullTree, _ := ds.BuildMerkleTree(destFiles)
+		if bytes.Equal(emptyTree.Leaves[0], fullTree.Leaves[0]) {
+			t.Error("Expected an empty directory's leaf to differ from a non-empty one")
+		}
+
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, VerifyOnComplete: true}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+		if !bytes.Equal(dirRoot(t, dst), dirRoot(t, src)) {
+			t.Error("Expected the synced roots to match")
+		}
+		if isValid, err := Scrub(src, dirRoot(t, src)); err != nil || !isValid {
+			t.Errorf("Expected scrub to encode empty directories the same way, got %v, %v", isValid, err)
+		}
+	})
+}
+
+func TestSyncVerifyAfterCopy(t *testing.T) {
+	// Simulates a disk that silently drops the tail of a write
+	truncatingCopier := func(src, dst string, mode os.FileMode) error {
+		if err := copyFile(src, dst, mode); err != nil {
+			return err
+		}
+		return os.Truncate(dst, 2)
+	}
+	src := createTestDir(t, map[string]string{"data.txt": "important contents"})
+
+	for _, verify := range []bool{false, true} {
+		t.Run(fmt.Sprintf("VerifyAfterCopy=%v", verify), func(t *testing.T) {
+			syncer := &DirectorySync{SourceDir: src, DestinationDir: t.TempDir(), VerifyAfterCopy: verify, copier: truncatingCopier}
+			err := syncer.SyncDirectories()
+			if !verify {
+				if err != nil {
+					t.Errorf("Expected the truncated copy to go unnoticed without verification, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrCopyVerification) {
+				t.Errorf("Expected ErrCopyVerification, got %v", err)
+			}
+		})
+	}
+
+	t.Run("IntactCopy", func(t *testing.T) {
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: t.TempDir(), VerifyAfterCopy: true, BatchSmallFiles: true}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Errorf("Expected intact copies to verify, got %v", err)
+		}
+	})
+}
+
+func TestBuildDirectoryTreeDetectDuplicates(t *testing.T) {
+	dir := createTestDir(t, map[string]string{
+		"a.txt":          "duplicate",
+		"nested/b.txt":   "duplicate",
+		"nested/c/d.txt": "duplicate",
+		"e.txt":          "pair",
+		"f.txt":          "pair",
+		"unique.txt":     "unique",
+	})
+
+	ds := &DirectorySync{DetectDuplicates: true}
+	files, err := ds.BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+	hashOf := func(content string) string {
+		hash := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(hash[:])
+	}
+	expected := map[string][]string{
+		hashOf("duplicate"): {"a.txt", "nested/b.txt", "nested/c/d.txt"},
+		hashOf("pair"):      {"e.txt", "f.txt"},
+	}
+	if !reflect.DeepEqual(ds.Duplicates, expected) {
+		t.Errorf("Expected duplicates %v, got %v", expected, ds.Duplicates)
+	}
+	if !reflect.DeepEqual(FindDuplicates(files), expected) {
+		t.Errorf("Expected FindDuplicates to match the scan report")
+	}
+
+	if duplicates := FindDuplicates(files[:1]); len(duplicates) != 0 {
+		t.Errorf("Expected no duplicates in a single file, got %v", duplicates)
+	}
+	ds = &DirectorySync{}
+	ds.BuildDirectoryTree(dir)
+	if ds.Duplicates != nil {
+		t.Errorf("Expected no report without DetectDuplicates, got %v", ds.Duplicates)
+	}
+}
+
+func TestSyncDetectDuplicatesReportsSource(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "same", "b.txt": "same"})
+	dst := createTestDir(t, map[string]string{"x.txt": "other", "y.txt": "other"})
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, DetectDuplicates: true}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	hash := sha256.Sum256([]byte("same"))
+	expected := map[string][]string{hex.EncodeToString(hash[:]): {"a.txt", "b.txt"}}
+	if !reflect.DeepEqual(syncer.Duplicates, expected) {
+		t.Errorf("Expected source duplicates %v, got %v", expected, syncer.Duplicates)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// manifestEntry is one line of a manifest written by ExportManifest
+type manifestEntry struct {
+	Path     string      `json:"path"`
+	Dir      bool        `json:"dir,omitempty"`
+	Size     int64       `json:"size"`
+	Mode     os.FileMode `json:"mode"`
+	Modified time.Time   `json:"modified"`
+	Hash     string      `json:"hash,omitempty"`
+}
+
+// ExportManifest writes a snapshot of the source listing as JSON Lines, one
+// entry per path with its size, mode, modification time and hex content hash.
+// The snapshot can later be compared against the live directory with
+// CompareToManifest, without the original being available.
+func (ds *DirectorySync) ExportManifest(w io.Writer) error {
+	files, err := ds.BuildDirectoryTree(ds.SourceDir)
+	if err != nil {
+		return fmt.Errorf("error scanning source directory: %v", err)
+	}
+
+	encoder := json.NewEncoder(w)
+	for _, file := range files {
+		entry := manifestEntry{
+			Path:     file.Path,
+			Dir:      file.IsDir,
+			Size:     file.Size,
+			Mode:     file.Mode,
+			Modified: file.LastModified,
+			Hash:     hex.EncodeToString(file.Hash),
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompareToManifest compares the live source directory against a snapshot
+// written by ExportManifest. The snapshot takes the place of the destination:
+// Added paths are new since the snapshot and Deleted paths have disappeared.
+func (ds *DirectorySync) CompareToManifest(r io.Reader) (*DirDiff, error) {
+	snapshot, err := readManifest(r)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ds.BuildDirectoryTree(ds.SourceDir)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning source directory: %v", err)
+	}
+	return diffFiles(files, snapshot, ds.pathKey), nil
+}
+
+// readManifest parses a manifest back into a listing sorted by path
+func readManifest(r io.Reader) ([]FileInfo, error) {
+	var files []FileInfo
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry manifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidManifest, line, err)
+		}
+		hash, err := hex.DecodeString(entry.Hash)
+		if err != nil || entry.Path == "" || !entry.Dir && len(hash) == 0 {
+			return nil, fmt.Errorf("%w: line %d: invalid entry", ErrInvalidManifest, line)
+		}
+		if entry.Dir {
+			hash = nil
+		}
+		files = append(files, FileInfo{
+			Path:         entry.Path,
+			Size:         entry.Size,
+			LastModified: entry.Modified,
+			IsDir:        entry.Dir,
+			Mode:         entry.Mode,
+			Hash:         hash,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading manifest: %v", err)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCompareToManifest(t *testing.T) {
+	dir := createTestDir(t, map[string]string{"keep.txt": "K", "edit.txt": "old", "sub/gone.txt": "G"})
+	ds := &DirectorySync{SourceDir: dir}
+
+	var snapshot bytes.Buffer
+	if err := ds.ExportManifest(&snapshot); err != nil {
+		t.Fatalf("ExportManifest failed: %v", err)
+	}
+
+	// Change the directory after the snapshot was taken
+	if err := os.WriteFile(filepath.Join(dir, "edit.txt"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "sub", "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "added.txt"), []byte("A"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := ds.CompareToManifest(&snapshot)
+	if err != nil {
+		t.Fatalf("CompareToManifest failed: %v", err)
+	}
+	expected := map[string][]string{
+		"Added":     {"added.txt"},
+		"Modified":  {"edit.txt"},
+		"Deleted":   {"sub/gone.txt"},
+		"Unchanged": {"keep.txt", "sub"},
+	}
+	got := map[string][]string{
+		"Added":     filePaths(diff.Added),
+		"Modified":  filePaths(diff.Modified),
+		"Deleted":   filePaths(diff.Deleted),
+		"Unchanged": filePaths(diff.Unchanged),
+	}
+	for category, paths := range expected {
+		if !slices.Equal(got[category], paths) {
+			t.Errorf("%s: expected %v, got %v", category, paths, got[category])
+		}
+	}
+}
+
+func TestCompareToManifestInvalid(t *testing.T) {
+	ds := &DirectorySync{SourceDir: t.TempDir()}
+	for _, manifest := range []string{
+		"not json\n",
+		`{"path":"a.txt","hash":"zz"}` + "\n",
+		`{"path":"a.txt"}` + "\n",
+	} {
+		if _, err := ds.CompareToManifest(strings.NewReader(manifest)); !errors.Is(err, ErrInvalidManifest) {
+			t.Errorf("Expected ErrInvalidManifest for %q, got %v", manifest, err)
+		}
+	}
+}
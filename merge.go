@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// SyncMode selects how SyncDirectories reconciles the two directories
+type SyncMode int
+
+const (
+	// SyncMirror makes the destination an exact copy of the source, deleting
+	// destination entries the source doesn't have. This is the default.
+	SyncMirror SyncMode = iota
+
+	// SyncMerge copies entries missing on either side to the other side and
+	// never deletes. When both sides have a file with different contents, the
+	// one modified last wins, and the source wins ties. An entry that is of a
+	// different type on each side, e.g. a directory and a link, is left alone
+	// along with everything below it and reported in Conflicts.
+	SyncMerge
+)
+
+// String returns a readable name for the mode
+func (m SyncMode) String() string {
+	switch m {
+	case SyncMirror:
+		return "mirror"
+	case SyncMerge:
+		return "merge"
+	default:
+		return "unknown"
+	}
+}
+
+// mergeDirectories performs a SyncMerge sync over already scanned listings
+func (ds *DirectorySync) mergeDirectories(ctx context.Context, sourceFiles, destFiles []FileInfo) error {
+	ds.logf("Finding differences...\n")
+	diff, err := ds.CompareTreesDetailed(sourceFiles, destFiles)
+	if err != nil {
+		return fmt.Errorf("error comparing trees: %v", err)
+	}
+	destByPath := make(map[string]FileInfo, len(destFiles))
+	for _, file := range destFiles {
+		destByPath[ds.pathKey(file.Path)] = file
+	}
+
+	// Listings are sorted, so directories are created before their contents
+	toDest := diff.Added
+	var toSource []FileInfo
+	for _, file := range diff.Modified {
+		destFile := destByPath[ds.pathKey(file.Path)]
+		if !sameEntryType(file, destFile) {
+			// Replacing either side would delete the other, which merging never does
+			ds.logf("Skipping entry of a different type on each side: %s\n", file.Path)
+			ds.Conflicts = append(ds.Conflicts, file.Path)
+			continue
+		}
+		if destFile.LastModified.After(file.LastModified) {
+			toSource = append(toSource, destFile)
+		} else {
+			toDest = append(toDest, file)
+		}
+	}
+	toSource = append(toSource, diff.Deleted...)
+	toDest = slices.DeleteFunc(toDest, ds.belowConflict)
+	toSource = slices.DeleteFunc(toSource, ds.belowConflict)
+
+	for _, file := range toDest {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if file.IsDir {
+			if err := checkLinkFreeParents(ds.destRoot(), file.Path); err != nil {
+				return err
+			}
+			ds.logf("Creating directory: %s\n", file.Path)
+			if err := ds.makeDir(filepath.Join(ds.destRoot(), file.Path), file.Mode); err != nil {
+				return fmt.Errorf("error creating directory %s: %v", file.Path, err)
+			}
+			ds.emit(SyncEvent{Type: EventCopied, Path: file.Path})
+			continue
+		}
+		if err := ds.tolerateCopyError(file, ds.copyToDestination(ctx, file)); err != nil {
+			return err
+		}
+	}
+	for _, file := range toSource {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := ds.tolerateCopyError(file, ds.copyToSource(ctx, file)); err != nil {
+			return err
+		}
+	}
+
+	if len(ds.FailedCopies) > 0 {
+		sort.Strings(ds.FailedCopies)
+		return fmt.Errorf("%w: %s", ErrCopyFailed, strings.Join(ds.FailedCopies, ", "))
+	}
+	ds.logf("Merge complete!\n")
+	return nil
+}
+
+// belowConflict reports whether an entry lies below a path the merge left alone
+func (ds *DirectorySync) belowConflict(file FileInfo) bool {
+	for _, path := range ds.Conflicts {
+		if strings.HasPrefix(ds.pathKey(file.Path), ds.pathKey(path)+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// copyToSource copies a destination entry back to the source during a merge
+func (ds *DirectorySync) copyToSource(ctx context.Context, file FileInfo) error {
+	sourceRel := file.Path
+	if original, ok := ds.sourcePaths[file.Path]; ok {
+		sourceRel = original
+	}
+	if err := checkLinkFreeParents(ds.SourceDir, sourceRel); err != nil {
+		return err
+	}
+	srcPath := filepath.Join(ds.destRoot(), filepath.FromSlash(file.Path))
+	destPath := ds.sourcePath(file.Path)
+
+	if file.IsDir {
+		ds.logf("Creating source directory: %s\n", file.Path)
+		if err := ds.makeDir(destPath, file.Mode); err != nil {
+			return fmt.Errorf("error creating directory %s: %v", file.Path, err)
+		}
+		ds.emit(SyncEvent{Type: EventCopied, Path: file.Path})
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), ds.dirMode()); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", filepath.Dir(destPath), err)
+	}
+	if isSymlink(file) {
+		ds.logf("Creating source link: %s\n", file.Path)
+		if err := copySymlink(srcPath, destPath); err != nil {
+			return fmt.Errorf("error copying link %s: %v", file.Path, err)
+		}
+		if err := ds.preserveOwnership(srcPath, destPath, file); err != nil {
+			return err
+		}
+		ds.emit(SyncEvent{Type: EventCopied, Path: file.Path, Size: file.Size})
+		return nil
+	}
+
+	ds.logf("Copying file to source: %s\n", file.Path)
+	err := ds.withRetries(ctx, func() error {
+		return ds.copy(ctx, srcPath, destPath, file.Mode)
+	})
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("error copying %s: %v", file.Path, err)
+	}
+	if err := ds.preserveOwnership(srcPath, destPath, file); err != nil {
+		return err
+	}
+	if err := preserveModTime(destPath, file); err != nil {
+		return fmt.Errorf("error setting modification time of %s: %v", file.Path, err)
+	}
+	if err := ds.verifyCopy(file, destPath); err != nil {
+		return err
+	}
+	ds.emit(SyncEvent{Type: EventCopied, Path: file.Path, Size: file.Size})
+	return nil
+}
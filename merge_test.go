@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSyncMerge(t *testing.T) {
+	src := createTestDir(t, map[string]string{"shared.txt": "same", "only-src.txt": "S", "conflict.txt": "source version", "stale.txt": "old source"})
+	dst := createTestDir(t, map[string]string{"shared.txt": "same", "only-dst/file.txt": "D", "conflict.txt": "destination version", "stale.txt": "new destination"})
+
+	// The destination edited conflict.txt last, the source edited stale.txt last
+	older, newer := time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour)
+	setModTime := func(path string, mtime time.Time) {
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setModTime(filepath.Join(src, "conflict.txt"), older)
+	setModTime(filepath.Join(dst, "conflict.txt"), newer)
+	setModTime(filepath.Join(src, "stale.txt"), newer)
+	setModTime(filepath.Join(dst, "stale.txt"), older)
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, Mode: SyncMerge}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"shared.txt":        "same",
+		"only-src.txt":      "S",
+		"only-dst/file.txt": "D",
+		"conflict.txt":      "destination version",
+		"stale.txt":         "old source",
+	}
+	for _, dir := range []string{src, dst} {
+		for relPath, content := range expected {
+			data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(relPath)))
+			if err != nil || string(data) != content {
+				t.Errorf("%s in %s: expected %q, got %q, %v", relPath, dir, content, data, err)
+			}
+		}
+	}
+	if !equalHashes(dirRoot(t, src), dirRoot(t, dst)) {
+		t.Errorf("Expected both sides to have the same root after merging")
+	}
+}
+
+func TestSyncMergeNeverDeletes(t *testing.T) {
+	src := t.TempDir()
+	dst := createTestDir(t, map[string]string{"keep.txt": "K"})
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, Mode: SyncMerge}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	for _, dir := range []string{src, dst} {
+		if _, err := os.Stat(filepath.Join(dir, "keep.txt")); err != nil {
+			t.Errorf("Expected keep.txt in %s: %v", dir, err)
+		}
+	}
+}
@@ -2,8 +2,15 @@ package main
 
 import (
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"hash"
+	"runtime"
 	"slices"
+	"sync"
+	"sync/atomic"
 )
 
 // MerkleTree holds the computed hashes and structure of a Merkle Tree.
@@ -22,6 +29,112 @@ type MerkleTree struct {
 	//        - nodes[len(nodes)-1] contains a single element: the Root.
 	// Storing all nodes is necessary for efficient proof generation.
 	nodes [][][]byte
+
+	// opts: The construction options the tree was built with. Proofs and
+	// updates must follow the same rules to stay consistent with Root.
+	opts TreeOptions
+
+	// leafIndexes maps leaf hashes to their first index. It is built by the
+	// first FindLeafIndex call and dropped whenever the leaves change.
+	// leafIndexesMu guards it and is a pointer so trees can still be copied;
+	// trees not built by a constructor have none and are scanned instead.
+	leafIndexes   map[string]int
+	leafIndexesMu *sync.Mutex
+}
+
+// OddNodeStrategy decides what happens to the last node of a level with an odd
+// number of nodes. Different ecosystems disagree, so trees are only comparable
+// (and proofs only verify) when both sides use the same strategy.
+type OddNodeStrategy int
+
+const (
+	// OddNodeDuplicate pairs the lone node with a copy of itself.
+	// This matches Bitcoin's transaction Merkle tree and is the default.
+	OddNodeDuplicate OddNodeStrategy = iota
+
+	// OddNodePromote carries the lone node up to the next level unchanged.
+	// This yields the same tree shape as RFC 6962 (Certificate Transparency).
+	OddNodePromote
+)
+
+// String returns the name used for the strategy on the command line.
+func (s OddNodeStrategy) String() string {
+	switch s {
+	case OddNodeDuplicate:
+		return "duplicate"
+	case OddNodePromote:
+		return "promote"
+	default:
+		return fmt.Sprintf("OddNodeStrategy(%d)", int(s))
+	}
+}
+
+// ParseOddNodeStrategy converts "duplicate" or "promote" into an OddNodeStrategy.
+func ParseOddNodeStrategy(name string) (OddNodeStrategy, error) {
+	switch name {
+	case "duplicate":
+		return OddNodeDuplicate, nil
+	case "promote":
+		return OddNodePromote, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrUnknownOddNodeStrategy, name)
+	}
+}
+
+// TreeOptions configures how a tree is constructed and how its proofs verify.
+// The zero value reproduces NewTree.
+type TreeOptions struct {
+	// OddNodeStrategy handles the last node of odd-sized levels.
+	OddNodeStrategy OddNodeStrategy
+
+	// Hash creates the hash function used for leaves and internal nodes
+	// (e.g. sha512.New). Nil means SHA-256.
+	Hash func() hash.Hash
+
+	// DomainSeparation prefixes leaf data with 0x00 and internal nodes with
+	// 0x01 before hashing, as RFC 6962 does. Without it an internal node's
+	// children can be presented as a leaf's data (a second-preimage attack).
+	// It is off by default so previously stored roots keep verifying.
+	DomainSeparation bool
+}
+
+// Hash prefixes applied when DomainSeparation is set
+const (
+	leafHashPrefix = 0x00
+	nodeHashPrefix = 0x01
+)
+
+// newHash returns a fresh instance of the configured hash function
+func (o TreeOptions) newHash() hash.Hash {
+	if o.Hash == nil {
+		return sha256.New()
+	}
+	return o.Hash()
+}
+
+// hashLeaf hashes a single data block into a leaf
+func (o TreeOptions) hashLeaf(data []byte) []byte {
+	if o.Hash == nil && !o.DomainSeparation {
+		hash := sha256.Sum256(data)
+		return hash[:]
+	}
+	h := o.newHash()
+	if o.DomainSeparation {
+		h.Write([]byte{leafHashPrefix})
+	}
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// hashNode hashes two child hashes into their parent
+func (o TreeOptions) hashNode(left, right []byte) []byte {
+	h := o.newHash()
+	if o.DomainSeparation {
+		h.Write([]byte{nodeHashPrefix})
+	}
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
 }
 
 var (
@@ -33,6 +146,24 @@ var (
 	ErrInvalidProofInputs = errors.New("merkleTree: invalid inputs: expected root, leaf hash cannot be empty")
 	ErrInvalidProof       = errors.New("merkleTree: invalid proof: contains empty sibling hash")
 	ErrProofPathRequired  = errors.New("merkleTree: proof path cannot be nil (use empty slice for single-node tree)") // Example if nil proofPath is invalid
+
+	ErrHistoryLength          = errors.New("merkleTree: root history must have one root per data block")
+	ErrLeafDataMismatch       = errors.New("merkleTree: data blocks do not match the tree's leaves")
+	ErrNilTree                = errors.New("merkleTree: method called on nil tree")
+	ErrInvalidHex             = errors.New("merkleTree: invalid hex-encoded hash")
+	ErrInvalidChunkSize       = errors.New("merkleTree: chunk size must be positive")
+	ErrUnknownOddNodeStrategy = errors.New("merkleTree: unknown odd node strategy")
+	ErrTreeSizeRequired       = errors.New("merkleTree: tree size must cover the leaf index for this odd node strategy")
+	ErrInvalidTreeSize        = errors.New("merkleTree: tree size must be between 1 and the number of leaves")
+	ErrInvalidTreeData        = errors.New("merkleTree: malformed serialized tree")
+	ErrDuplicateKey           = errors.New("merkleTree: duplicate key")
+	ErrKeyNotFound            = errors.New("merkleTree: key not found")
+	ErrProofLengthMismatch    = errors.New("merkleTree: proof length does not match the tree size")
+	ErrInvalidLeafHash        = errors.New("merkleTree: leaf hashes must be non-empty and of equal length")
+	ErrTreeInconsistent       = errors.New("merkleTree: tree is internally inconsistent")
+	ErrShardMismatch          = errors.New("merkleTree: shard root is not a leaf of the combined tree")
+	ErrNotRFC6962Tree         = errors.New("merkleTree: tree was not built with the RFC 6962 options")
+	ErrShardOptionsMismatch   = errors.New("merkleTree: shards were built with different options")
 )
 
 // NewTree creates a new Merkle Tree from ordered data blocks.
@@ -40,13 +171,73 @@ var (
 // by the caller (e.g., based on sorted keys or file paths).
 // It calculates all necessary hashes and populates the MerkleTree struct.
 func NewTree(dataBlocks [][]byte) (*MerkleTree, error) {
-	merkle := &MerkleTree{}
+	return NewTreeWithOptions(dataBlocks, TreeOptions{})
+}
 
+// NewTreeWithOptions creates a new Merkle Tree like NewTree, using the given
+// construction options. Proofs from the tree must be verified with
+// VerifyProofWithOptions and the same options.
+func NewTreeWithOptions(dataBlocks [][]byte, opts TreeOptions) (*MerkleTree, error) {
 	if len(dataBlocks) == 0 {
 		return nil, ErrEmptyMessage
 	}
-	merkle.Leaves = hashLeaves(dataBlocks)
-	nodes, err := calculateTreeLevels(merkle.Leaves)
+	return newTreeFromLeaves(hashLeaves(dataBlocks, opts), opts)
+}
+
+// NewTreeWithHasher creates a new Merkle Tree like NewTree, hashing leaves and
+// internal nodes with h instead of SHA-256. Proofs must be verified with
+// VerifyProofWithHasher and the same hash function.
+func NewTreeWithHasher(dataBlocks [][]byte, h func() hash.Hash) (*MerkleTree, error) {
+	return NewTreeWithOptions(dataBlocks, TreeOptions{Hash: h})
+}
+
+// NewTreeFromLeafHashes rebuilds a tree from leaf hashes, e.g. stored by an
+// earlier run or received from a peer, without the original data blocks.
+// The result equals NewTree over the blocks the hashes were computed from.
+// Every hash must be non-empty and all must have the same length.
+func NewTreeFromLeafHashes(leafHashes [][]byte) (*MerkleTree, error) {
+	if len(leafHashes) == 0 {
+		return nil, ErrZeroLeaves
+	}
+	leaves := make([][]byte, len(leafHashes))
+	for i, leafHash := range leafHashes {
+		if len(leafHash) == 0 || len(leafHash) != len(leafHashes[0]) {
+			return nil, fmt.Errorf("%w: leaf %d", ErrInvalidLeafHash, i)
+		}
+		leaves[i] = slices.Clone(leafHash)
+	}
+	return newTreeFromLeaves(leaves, TreeOptions{})
+}
+
+// NewTreeOpts creates a new Merkle Tree like NewTreeWithOptions, configured
+// by opts: WithTreeOptions sets the construction options, and WithPreHashed
+// uses the blocks as leaf hashes, e.g. digests from a content-addressed
+// store, rather than hashing them twice. Pre-hashed blocks must be as long as
+// the tree's hash output.
+func NewTreeOpts(dataBlocks [][]byte, opts ...Option) (*MerkleTree, error) {
+	if len(dataBlocks) == 0 {
+		return nil, ErrEmptyMessage
+	}
+	cfg := newConfig(opts)
+	if !cfg.preHashed {
+		return NewTreeWithOptions(dataBlocks, cfg.treeOptions)
+	}
+
+	size := cfg.treeOptions.newHash().Size()
+	leaves := make([][]byte, len(dataBlocks))
+	for i, block := range dataBlocks {
+		if len(block) != size {
+			return nil, fmt.Errorf("%w: leaf %d is %d bytes, expected %d", ErrInvalidLeafHash, i, len(block), size)
+		}
+		leaves[i] = slices.Clone(block)
+	}
+	return newTreeFromLeaves(leaves, cfg.treeOptions)
+}
+
+// newTreeFromLeaves builds the levels above already-hashed leaves
+func newTreeFromLeaves(leaves [][]byte, opts TreeOptions) (*MerkleTree, error) {
+	merkle := &MerkleTree{Leaves: leaves, opts: opts, leafIndexesMu: &sync.Mutex{}}
+	nodes, err := calculateTreeLevels(leaves, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -58,8 +249,9 @@ func NewTree(dataBlocks [][]byte) (*MerkleTree, error) {
 }
 
 // GetRoot returns the root hash of the tree.
+// A nil tree has no root and returns nil.
 func (t *MerkleTree) GetRoot() []byte {
-	if t.Root == nil {
+	if t == nil || t.Root == nil {
 		return nil
 	}
 	root := make([]byte, len(t.Root))
@@ -68,7 +260,11 @@ func (t *MerkleTree) GetRoot() []byte {
 }
 
 // GetLeaves returns the ordered slice of leaf hashes.
+// A nil tree has no leaves and returns nil.
 func (t *MerkleTree) GetLeaves() [][]byte {
+	if t == nil {
+		return nil
+	}
 	leaves := make([][]byte, 0, len(t.Leaves))
 	for _, leaf := range t.Leaves {
 		leafCopy := make([]byte, len(leaf))
@@ -78,22 +274,182 @@ func (t *MerkleTree) GetLeaves() [][]byte {
 	return leaves
 }
 
+// RootHex returns the root hash as lowercase hex.
+// A nil tree has no root and returns "".
+func (t *MerkleTree) RootHex() string {
+	if t == nil {
+		return ""
+	}
+	return hex.EncodeToString(t.Root)
+}
+
+// LeafHexes returns the ordered leaf hashes as lowercase hex.
+// A nil tree has no leaves and returns nil.
+func (t *MerkleTree) LeafHexes() []string {
+	if t == nil {
+		return nil
+	}
+	hexes := make([]string, 0, len(t.Leaves))
+	for _, leaf := range t.Leaves {
+		hexes = append(hexes, hex.EncodeToString(leaf))
+	}
+	return hexes
+}
+
+// Height returns the number of levels in the tree, counting the leaf level and
+// the root. A single leaf tree has height 1; a nil tree has height 0.
+func (t *MerkleTree) Height() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.nodes)
+}
+
+// NodeCount returns the total number of hashes stored across all levels.
+func (t *MerkleTree) NodeCount() int {
+	if t == nil {
+		return 0
+	}
+	count := 0
+	for _, level := range t.nodes {
+		count += len(level)
+	}
+	return count
+}
+
+// LevelNodes returns copies of the hashes at level, where level 0 holds the
+// leaves and level Height()-1 holds the root.
+func (t *MerkleTree) LevelNodes(level int) ([][]byte, error) {
+	if t == nil {
+		return nil, ErrNilTree
+	}
+	if level < 0 || level >= len(t.nodes) {
+		return nil, ErrOutOfBoundary
+	}
+	nodes := make([][]byte, 0, len(t.nodes[level]))
+	for _, node := range t.nodes[level] {
+		nodes = append(nodes, slices.Clone(node))
+	}
+	return nodes, nil
+}
+
+// Validate checks that the tree is well-formed: Leaves matches the bottom
+// level, every parent equals the hash of its children under the tree's
+// options, and Root is the single node of the top level. It returns an error
+// wrapping ErrTreeInconsistent that names the first mismatch, e.g. to catch a
+// tree corrupted by deserialization or a faulty update.
+func (t *MerkleTree) Validate() error {
+	if t == nil {
+		return ErrNilTree
+	}
+	if len(t.nodes) == 0 || len(t.nodes[0]) == 0 {
+		return fmt.Errorf("%w: no leaf level", ErrTreeInconsistent)
+	}
+	if len(t.Leaves) != len(t.nodes[0]) {
+		return fmt.Errorf("%w: %d leaves but %d nodes at level 0", ErrTreeInconsistent, len(t.Leaves), len(t.nodes[0]))
+	}
+	for i, leaf := range t.Leaves {
+		if !equalHashes(leaf, t.nodes[0][i]) {
+			return fmt.Errorf("%w: leaf %d does not match level 0", ErrTreeInconsistent, i)
+		}
+	}
+
+	top := len(t.nodes) - 1
+	for level := range top {
+		if len(t.nodes[level]) <= 1 {
+			return fmt.Errorf("%w: level %d has %d nodes below the top", ErrTreeInconsistent, level, len(t.nodes[level]))
+		}
+		expected, err := calculateNextLevel(t.nodes[level], t.opts)
+		if err != nil {
+			return err
+		}
+		if len(expected) != len(t.nodes[level+1]) {
+			return fmt.Errorf("%w: level %d has %d nodes, expected %d", ErrTreeInconsistent, level+1, len(t.nodes[level+1]), len(expected))
+		}
+		for i, node := range expected {
+			if !equalHashes(node, t.nodes[level+1][i]) {
+				return fmt.Errorf("%w: node %d of level %d does not match its children", ErrTreeInconsistent, i, level+1)
+			}
+		}
+	}
+	if len(t.nodes[top]) != 1 {
+		return fmt.Errorf("%w: top level has %d nodes", ErrTreeInconsistent, len(t.nodes[top]))
+	}
+	if !equalHashes(t.Root, t.nodes[top][0]) {
+		return fmt.Errorf("%w: root does not match the top level", ErrTreeInconsistent)
+	}
+	return nil
+}
+
 // GenerateProof creates the authentication path (Merkle proof) for the leaf
 // at the specified index. The proof consists of the sibling hashes required
 // to hash up to the root. The path is ordered from bottom (leaf sibling) to top.
 func (t *MerkleTree) GenerateProof(leafIndex int) (proofPath [][]byte, leafHash []byte, err error) {
+	if t == nil {
+		return nil, nil, ErrNilTree
+	}
 	if leafIndex >= len(t.Leaves) || leafIndex < 0 {
 		return nil, nil, ErrOutOfBoundary
 	}
 
-	leafHash = t.Leaves[leafIndex]
 	proofPath = make([][]byte, 0)
-	currentIndex := leafIndex
+	err = t.WalkProof(leafIndex, func(sibling []byte, _ bool) error {
+		proofPath = append(proofPath, sibling)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return proofPath, t.Leaves[leafIndex], nil
+}
+
+// ProofStep is one sibling of a proof together with its side, so the proof
+// can be verified without knowing the leaf's index.
+type ProofStep struct {
+	Sibling []byte
+	IsRight bool // Sibling is the right-hand input of its parent
+}
+
+// GenerateProofWithDirections returns the proof for the leaf at index like
+// GenerateProof, with each sibling's side recorded alongside it.
+func (t *MerkleTree) GenerateProofWithDirections(index int) ([]ProofStep, []byte, error) {
+	if t == nil {
+		return nil, nil, ErrNilTree
+	}
+	if index >= len(t.Leaves) || index < 0 {
+		return nil, nil, ErrOutOfBoundary
+	}
 
+	steps := make([]ProofStep, 0)
+	err := t.WalkProof(index, func(sibling []byte, isRight bool) error {
+		steps = append(steps, ProofStep{Sibling: slices.Clone(sibling), IsRight: isRight})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return steps, slices.Clone(t.Leaves[index]), nil
+}
+
+// WalkProof calls fn with each sibling hash of the leaf's proof, bottom to
+// top, as GenerateProof would return them, without building the path.
+// isRight reports whether the sibling is the right-hand input of its parent.
+// sibling is the tree's own hash and must not be modified. An error from fn
+// stops the walk and is returned.
+func (t *MerkleTree) WalkProof(leafIndex int, fn func(sibling []byte, isRight bool) error) error {
+	if t == nil {
+		return ErrNilTree
+	}
+	if leafIndex >= len(t.Leaves) || leafIndex < 0 {
+		return ErrOutOfBoundary
+	}
+
+	currentIndex := leafIndex
 	for level := range len(t.nodes) - 1 {
 		currentLevelNodes := t.nodes[level]
 		var siblingIndex int
-		if currentIndex%2 == 0 {
+		isRight := currentIndex%2 == 0
+		if isRight {
 			siblingIndex = currentIndex + 1 // Sibling is to the right
 		} else {
 			siblingIndex = currentIndex - 1 // Sibling is to the left
@@ -102,6 +458,11 @@ func (t *MerkleTree) GenerateProof(leafIndex int) (proofPath [][]byte, leafHash
 		var siblingHash []byte
 		if siblingIndex < 0 || siblingIndex >= len(currentLevelNodes) {
 			// This happens when currentIndex was the last node on an odd-sized level below.
+			if t.opts.OddNodeStrategy == OddNodePromote {
+				// The node was carried up unchanged, so this level adds nothing to the path.
+				currentIndex = currentIndex / 2
+				continue
+			}
 			// The node itself was paired with a duplicate of itself.
 			// So, the hash needed for the proof path is the node's own hash.
 			siblingHash = currentLevelNodes[currentIndex]
@@ -109,11 +470,12 @@ func (t *MerkleTree) GenerateProof(leafIndex int) (proofPath [][]byte, leafHash
 			// Normal case: sibling exists within bounds.
 			siblingHash = currentLevelNodes[siblingIndex]
 		}
-		proofPath = append(proofPath, siblingHash)
+		if err := fn(siblingHash, isRight); err != nil {
+			return err
+		}
 		currentIndex = currentIndex / 2
 	}
-
-	return proofPath, leafHash, nil
+	return nil
 }
 
 // VerifyProof checks if a given leaf hash and its corresponding proof path
@@ -125,50 +487,354 @@ func (t *MerkleTree) GenerateProof(leafIndex int) (proofPath [][]byte, leafHash
 //
 //	This index is crucial for determining hash concatenation order (left vs right).
 func VerifyProof(expectedRoot []byte, proofPath [][]byte, leafHash []byte, leafIndex int) (bool, error) {
+	return VerifyProofWithOptions(expectedRoot, proofPath, leafHash, leafIndex, 0, TreeOptions{})
+}
+
+// VerifyProofWithHasher verifies a proof generated by a tree built with
+// NewTreeWithHasher, using the same hash function h.
+func VerifyProofWithHasher(expectedRoot []byte, proofPath [][]byte, leafHash []byte, leafIndex int, h func() hash.Hash) (bool, error) {
+	return VerifyProofWithOptions(expectedRoot, proofPath, leafHash, leafIndex, 0, TreeOptions{Hash: h})
+}
+
+// VerifyProofWithSize verifies a proof like VerifyProof for a tree of treeSize
+// leaves, returning ErrProofLengthMismatch if the proof has more or fewer
+// siblings than such a tree has levels above the leaf.
+func VerifyProofWithSize(expectedRoot []byte, proofPath [][]byte, leafHash []byte, leafIndex int, treeSize int) (bool, error) {
+	return VerifyProofWithOptions(expectedRoot, proofPath, leafHash, leafIndex, treeSize, TreeOptions{})
+}
+
+// VerifyProofWithOptions verifies a proof generated by a tree built with opts.
+// `treeSize`: The number of leaves in the tree. It is required for OddNodePromote,
+// where levels without a sibling are absent from the proof. When it is
+// positive, proofs whose length doesn't fit the tree are rejected with
+// ErrProofLengthMismatch; zero skips the check for the duplicate strategy.
+func VerifyProofWithOptions(expectedRoot []byte, proofPath [][]byte, leafHash []byte, leafIndex int, treeSize int, opts TreeOptions) (bool, error) {
 	if len(expectedRoot) == 0 || len(leafHash) == 0 {
 		return false, ErrInvalidProofInputs
 	}
-	if len(proofPath) == 0 {
-		isValid := slices.Equal(leafHash, expectedRoot)
-		return isValid, nil
+	root, err := proofRoot(proofPath, leafHash, leafIndex, treeSize, opts)
+	if err != nil || root == nil {
+		return false, err
+	}
+	return equalHashes(root, expectedRoot), nil
+}
+
+// VerifyProofRoot returns the root a proof from NewTree reconstructs for
+// leafHash without comparing it to an expected root, e.g. to show which root
+// a failing proof actually leads to.
+func VerifyProofRoot(proofPath [][]byte, leafHash []byte, leafIndex int) ([]byte, error) {
+	if len(leafHash) == 0 {
+		return nil, ErrInvalidProofInputs
+	}
+	return proofRoot(proofPath, leafHash, leafIndex, 0, TreeOptions{})
+}
+
+// VerifyProofSteps checks that leafHash hashes up to root through the steps
+// from GenerateProofWithDirections of a tree built by NewTree. Unlike
+// VerifyProof, it doesn't need the leaf's index.
+func VerifyProofSteps(root []byte, steps []ProofStep, leafHash []byte) (bool, error) {
+	if len(root) == 0 || len(leafHash) == 0 {
+		return false, ErrInvalidProofInputs
+	}
+
+	opts := TreeOptions{}
+	currentHash := leafHash
+	for _, step := range steps {
+		if len(step.Sibling) == 0 {
+			return false, ErrInvalidProof
+		}
+		if step.IsRight {
+			currentHash = opts.hashNode(currentHash, step.Sibling)
+		} else {
+			currentHash = opts.hashNode(step.Sibling, currentHash)
+		}
+	}
+	return equalHashes(currentHash, root), nil
+}
+
+// proofRoot hashes leafHash up through proofPath. It returns a nil root when
+// the proof has more siblings than a tree of treeSize leaves has levels.
+func proofRoot(proofPath [][]byte, leafHash []byte, leafIndex int, treeSize int, opts TreeOptions) ([]byte, error) {
+	if opts.OddNodeStrategy == OddNodePromote && (leafIndex < 0 || leafIndex >= treeSize) {
+		return nil, ErrTreeSizeRequired
+	}
+	if treeSize > 0 {
+		if leafIndex < 0 || leafIndex >= treeSize {
+			return nil, ErrOutOfBoundary
+		}
+		if len(proofPath) != proofLength(leafIndex, treeSize, opts.OddNodeStrategy) {
+			return nil, ErrProofLengthMismatch
+		}
 	}
 
 	currentHash := leafHash
 	currentIndex := leafIndex
+	levelSize := treeSize
 
 	for _, siblingHash := range proofPath {
-		if len(siblingHash) == 0 { // Good to also check inside loop
-			return false, ErrInvalidProof
+		// Only the length is inspected here, which reveals nothing about hash contents
+		if len(siblingHash) == 0 {
+			return nil, ErrInvalidProof
+		}
+		if opts.OddNodeStrategy == OddNodePromote {
+			// Skip the levels where this node was carried up without a sibling
+			for levelSize > 1 && currentIndex == levelSize-1 && levelSize%2 != 0 {
+				currentIndex = currentIndex / 2
+				levelSize = (levelSize + 1) / 2
+			}
+			if levelSize <= 1 {
+				// More siblings than levels: the proof cannot belong to this tree
+				return nil, nil
+			}
+			levelSize = (levelSize + 1) / 2
 		}
 		isRightNode := currentIndex%2 != 0
 
-		var concatted []byte
 		if isRightNode {
-			concatted = slices.Concat(siblingHash, currentHash)
+			currentHash = opts.hashNode(siblingHash, currentHash)
 		} else {
-			concatted = slices.Concat(currentHash, siblingHash)
+			currentHash = opts.hashNode(currentHash, siblingHash)
 		}
-		computedHash := sha256.Sum256(concatted)
-
-		currentHash = computedHash[:]
 		currentIndex = currentIndex / 2
 	}
 
-	return slices.Equal(currentHash, expectedRoot), nil // Placeholder
+	return slices.Clone(currentHash), nil
+}
+
+// proofLength returns how many siblings GenerateProof returns for the leaf at
+// index in a tree of treeSize leaves
+func proofLength(index, treeSize int, strategy OddNodeStrategy) int {
+	length := 0
+	for levelSize := treeSize; levelSize > 1; levelSize = (levelSize + 1) / 2 {
+		// A promoted node has no sibling on this level
+		if strategy != OddNodePromote || index != levelSize-1 || levelSize%2 == 0 {
+			length++
+		}
+		index /= 2
+	}
+	return length
+}
+
+// VerifyProofHex verifies a proof whose root, siblings and leaf hash are
+// hex-encoded, as commonly stored and transmitted by other systems.
+// Every value must decode to a hash of the same length as the root.
+func VerifyProofHex(rootHex string, proofHex []string, leafHex string, leafIndex int) (bool, error) {
+	root, err := decodeHexHash("root", rootHex, -1)
+	if err != nil {
+		return false, err
+	}
+	leafHash, err := decodeHexHash("leaf", leafHex, len(root))
+	if err != nil {
+		return false, err
+	}
+	proofPath := make([][]byte, len(proofHex))
+	for i, siblingHex := range proofHex {
+		proofPath[i], err = decodeHexHash(fmt.Sprintf("proof[%d]", i), siblingHex, len(root))
+		if err != nil {
+			return false, err
+		}
+	}
+	return VerifyProof(root, proofPath, leafHash, leafIndex)
+}
+
+// decodeHexHash decodes a hex-encoded hash, checking its length when wantLen >= 0.
+func decodeHexHash(name, s string, wantLen int) ([]byte, error) {
+	if len(s)%2 != 0 {
+		return nil, fmt.Errorf("%w: %s has an odd number of digits", ErrInvalidHex, name)
+	}
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidHex, name, err)
+	}
+	if wantLen >= 0 && len(decoded) != wantLen {
+		return nil, fmt.Errorf("%w: %s is %d bytes, expected %d", ErrInvalidHex, name, len(decoded), wantLen)
+	}
+	return decoded, nil
+}
+
+// VerifyRoot computes the root from already-hashed leaves and compares it to claimedRoot.
+// Only the current level is kept in memory, so no node structure is retained.
+// It applies the same odd-level duplication rules as NewTree.
+func VerifyRoot(leafHashes [][]byte, claimedRoot []byte) (bool, error) {
+	if len(claimedRoot) == 0 {
+		return false, ErrHashOrProof
+	}
+	root, err := computeRoot(leafHashes, TreeOptions{})
+	if err != nil {
+		return false, err
+	}
+	return equalHashes(root, claimedRoot), nil
+}
+
+// equalHashes compares two hashes in constant time so verifiers don't leak
+// how many leading bytes of a forged hash matched.
+func equalHashes(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
 }
 
-// hashLeaves calculates the SHA256 hash for each data block.
-func hashLeaves(dataBlocks [][]byte) [][]byte {
+// ComputeRoot returns the root NewTreeOpts would compute for dataBlocks with
+// the same options, without building a tree, for large inputs that need a
+// root but no proofs. The leaf hashes are written to a single buffer and each
+// level is hashed in place over the one below it, so no intermediate levels or
+// per-node slices are allocated.
+func ComputeRoot(dataBlocks [][]byte, opts ...Option) ([]byte, error) {
+	if len(dataBlocks) == 0 {
+		return nil, ErrEmptyMessage
+	}
+	cfg := newConfig(opts)
+	treeOpts := cfg.treeOptions
+	h := treeOpts.newHash()
+	size := h.Size()
+	level := make([]byte, len(dataBlocks)*size)
+	node := func(i int) []byte {
+		return level[i*size : (i+1)*size]
+	}
+	for i, block := range dataBlocks {
+		if cfg.preHashed {
+			if len(block) != size {
+				return nil, fmt.Errorf("%w: leaf %d is %d bytes, expected %d", ErrInvalidLeafHash, i, len(block), size)
+			}
+			copy(node(i), block)
+			continue
+		}
+		h.Reset()
+		if treeOpts.DomainSeparation {
+			h.Write([]byte{leafHashPrefix})
+		}
+		h.Write(block)
+		h.Sum(node(i)[:0])
+	}
+
+	// Parent i only overwrites nodes already consumed by parents before it
+	for width := len(dataBlocks); width > 1; width = (width + 1) / 2 {
+		for i := range (width + 1) / 2 {
+			left, right := 2*i, 2*i+1
+			if right == width {
+				if treeOpts.OddNodeStrategy == OddNodePromote {
+					copy(node(i), node(left))
+					continue
+				}
+				right = left
+			}
+			h.Reset()
+			if treeOpts.DomainSeparation {
+				h.Write([]byte{nodeHashPrefix})
+			}
+			h.Write(node(left))
+			h.Write(node(right))
+			h.Sum(node(i)[:0])
+		}
+	}
+	return slices.Clone(node(0)), nil
+}
+
+// computeRoot hashes the given leaves up to the root, discarding each level once used.
+func computeRoot(leaves [][]byte, opts TreeOptions) ([]byte, error) {
+	if len(leaves) == 0 {
+		return nil, ErrZeroLeaves
+	}
+	currentLevel := leaves
+	for len(currentLevel) > 1 {
+		nextLevel, err := calculateNextLevel(currentLevel, opts)
+		if err != nil {
+			return nil, err
+		}
+		currentLevel = nextLevel
+	}
+	return currentLevel[0], nil
+}
+
+// VerifyAppendHistory checks a published root history against the data blocks.
+// roots[i] must be the root of the tree holding dataBlocks[0..i], i.e. the state
+// after appending block i. It returns true and -1 when every root matches,
+// otherwise false and the index of the first root that doesn't. The tree is
+// grown one AppendLeaf at a time, so checking n roots costs O(n log n) hashes.
+func VerifyAppendHistory(dataBlocks [][]byte, roots [][]byte) (bool, int, error) {
+	if len(dataBlocks) == 0 {
+		return false, -1, ErrEmptyMessage
+	}
+	if len(roots) != len(dataBlocks) {
+		return false, -1, ErrHistoryLength
+	}
+
+	tree, err := NewTree(dataBlocks[:1])
+	if err != nil {
+		return false, -1, err
+	}
+	for i := range dataBlocks {
+		if i > 0 {
+			if err := tree.AppendLeaf(dataBlocks[i]); err != nil {
+				return false, -1, err
+			}
+		}
+		if !equalHashes(tree.Root, roots[i]) {
+			return false, i, nil
+		}
+	}
+	return true, -1, nil
+}
+
+// SameDataSet reports whether two trees were built over the same ordered data,
+// even when their roots differ because they were built with different hash
+// functions or construction options. Each tree is first checked against its own
+// data blocks, returning ErrLeafDataMismatch if the blocks are not the ones the
+// tree was built from; the blocks themselves are then compared in order.
+func SameDataSet(a *MerkleTree, aBlocks [][]byte, b *MerkleTree, bBlocks [][]byte) (bool, error) {
+	if a == nil || b == nil {
+		return false, ErrNilTree
+	}
+	for _, pair := range []struct {
+		tree   *MerkleTree
+		blocks [][]byte
+	}{{a, aBlocks}, {b, bBlocks}} {
+		if !slices.EqualFunc(hashLeaves(pair.blocks, pair.tree.opts), pair.tree.Leaves, equalHashes) {
+			return false, ErrLeafDataMismatch
+		}
+	}
+	return slices.EqualFunc(aBlocks, bBlocks, slices.Equal), nil
+}
+
+// HashOpCount returns how many hash computations NewTreeOpts performs for
+// leafCount leaves with the same opts: one per leaf, unless WithPreHashed is
+// set, plus one per parent node. The last node of an odd-sized level is hashed
+// with its duplicate, or carried up unhashed under OddNodePromote.
+func HashOpCount(leafCount int, opts ...Option) int {
+	if leafCount <= 0 {
+		return 0
+	}
+	cfg := newConfig(opts)
+	ops := leafCount
+	if cfg.preHashed {
+		ops = 0
+	}
+	for levelSize := leafCount; levelSize > 1; {
+		if cfg.treeOptions.OddNodeStrategy == OddNodePromote {
+			ops += levelSize / 2
+		} else {
+			ops += (levelSize + 1) / 2
+		}
+		levelSize = (levelSize + 1) / 2
+	}
+	return ops
+}
+
+// hashOpCounter, when set by tests, counts the hashes computed while building trees.
+var hashOpCounter *atomic.Int64
+
+// hashLeaves calculates the leaf hash for each data block.
+func hashLeaves(dataBlocks [][]byte, opts TreeOptions) [][]byte {
+	if hashOpCounter != nil {
+		hashOpCounter.Add(int64(len(dataBlocks)))
+	}
 	leaves := make([][]byte, 0, len(dataBlocks))
 	for _, input := range dataBlocks {
-		hash := sha256.Sum256(input)
-		leaves = append(leaves, hash[:])
+		leaves = append(leaves, opts.hashLeaf(input))
 	}
 	return leaves
 }
 
 // calculateTreeLevels builds all levels of the Merkle tree from the leaf hashes.
-func calculateTreeLevels(leaves [][]byte) ([][][]byte, error) {
+func calculateTreeLevels(leaves [][]byte, opts TreeOptions) ([][][]byte, error) {
 	if len(leaves) == 0 {
 		return nil, ErrZeroLeaves
 	}
@@ -177,7 +843,7 @@ func calculateTreeLevels(leaves [][]byte) ([][][]byte, error) {
 
 	currentLevel := leaves
 	for len(currentLevel) > 1 {
-		nextLevel, err := calculateNextLevel(currentLevel)
+		nextLevel, err := calculateNextLevel(currentLevel, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -188,29 +854,64 @@ func calculateTreeLevels(leaves [][]byte) ([][][]byte, error) {
 }
 
 // calculateNextLevel computes the next level hashes from the current level.
-func calculateNextLevel(currentLevelHashes [][]byte) ([][]byte, error) {
+func calculateNextLevel(currentLevelHashes [][]byte, opts TreeOptions) ([][]byte, error) {
 	if len(currentLevelHashes) <= 1 {
 		return nil, ErrInsufficientLevel
 	}
 
+	var promoted []byte
 	levelToProcess := currentLevelHashes
-	if len(currentLevelHashes)%2 != 0 {
+	if len(currentLevelHashes)%2 != 0 && opts.OddNodeStrategy == OddNodePromote {
+		// Hold the lone node back and append it to the next level as is
+		promoted = currentLevelHashes[len(currentLevelHashes)-1]
+		levelToProcess = currentLevelHashes[:len(currentLevelHashes)-1]
+	} else if len(currentLevelHashes)%2 != 0 {
 		levelToProcess = make([][]byte, len(currentLevelHashes), len(currentLevelHashes)+1)
 		copy(levelToProcess, currentLevelHashes)
 		levelToProcess = append(levelToProcess, currentLevelHashes[len(currentLevelHashes)-1])
 	}
 
-	nextLevelHashes := make([][]byte, 0, len(levelToProcess)/2)
+	pairs := len(levelToProcess) / 2
+	nextLevelHashes := make([][]byte, pairs, pairs+1)
+	if hashOpCounter != nil {
+		hashOpCounter.Add(int64(pairs))
+	}
 
-	for i := 0; i < len(levelToProcess); i += 2 {
-		hash1 := levelToProcess[i]
-		hash2 := levelToProcess[i+1]
+	if pairs >= parallelLevelThreshold && runtime.GOMAXPROCS(0) > 1 {
+		hashPairsParallel(levelToProcess, nextLevelHashes, opts)
+	} else {
+		hashPairs(levelToProcess, nextLevelHashes, 0, pairs, opts)
+	}
+	if promoted != nil {
+		nextLevelHashes = append(nextLevelHashes, promoted)
+	}
 
-		concattedPair := slices.Concat(hash1, hash2)
+	return nextLevelHashes, nil
+}
+
+// parallelLevelThreshold is the number of pairs from which a level is hashed
+// across all processors; smaller levels aren't worth the goroutine overhead
+var parallelLevelThreshold = 1 << 13
 
-		newHash := sha256.Sum256(concattedPair)
-		nextLevelHashes = append(nextLevelHashes, newHash[:])
+// hashPairs hashes the pairs [from, to) of level into next
+func hashPairs(level, next [][]byte, from, to int, opts TreeOptions) {
+	for i := from; i < to; i++ {
+		next[i] = opts.hashNode(level[2*i], level[2*i+1])
 	}
+}
 
-	return nextLevelHashes, nil
+// hashPairsParallel hashes every pair of level into next, giving each
+// processor a contiguous range of pairs so the output order is unchanged
+func hashPairsParallel(level, next [][]byte, opts TreeOptions) {
+	workers := runtime.GOMAXPROCS(0)
+	size := (len(next) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for from := 0; from < len(next); from += size {
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			hashPairs(level, next, from, to, opts)
+		}(from, min(from+size, len(next)))
+	}
+	wg.Wait()
 }
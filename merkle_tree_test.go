@@ -4,8 +4,16 @@ package main
 import (
 	"bytes"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
 	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
 )
 
@@ -350,3 +358,975 @@ func TestVerifyProofEdgeCases(t *testing.T) {
 		}
 	})
 }
+
+func TestVerifyRoot(t *testing.T) {
+	for _, size := range []int{1, 2, 3, 4, 5, 7, 8, 13} {
+		items := make([]string, size)
+		for i := range items {
+			items[i] = string(rune('A' + i))
+		}
+		tree, err := NewTree(createTestDataBlocks(items...))
+		if err != nil {
+			t.Fatalf("NewTree failed for %d leaves: %v", size, err)
+		}
+
+		isValid, err := VerifyRoot(tree.Leaves, tree.Root)
+		if err != nil {
+			t.Errorf("VerifyRoot failed for %d leaves: %v", size, err)
+		}
+		if !isValid {
+			t.Errorf("VerifyRoot returned false for matching root with %d leaves", size)
+		}
+
+		tamperedRoot := append([]byte{}, tree.Root...)
+		tamperedRoot[0] ^= 0xff
+		isValid, err = VerifyRoot(tree.Leaves, tamperedRoot)
+		if err != nil {
+			t.Errorf("VerifyRoot (TamperedRoot) returned error for %d leaves: %v", size, err)
+		}
+		if isValid {
+			t.Errorf("VerifyRoot returned true for tampered root with %d leaves", size)
+		}
+	}
+
+	t.Run("InvalidInputs", func(t *testing.T) {
+		if _, err := VerifyRoot(nil, []byte{1}); !errors.Is(err, ErrZeroLeaves) {
+			t.Errorf("Expected ErrZeroLeaves for no leaves, got %v", err)
+		}
+		if _, err := VerifyRoot([][]byte{hashData([]byte("A"))}, nil); !errors.Is(err, ErrHashOrProof) {
+			t.Errorf("Expected ErrHashOrProof for empty claimed root, got %v", err)
+		}
+	})
+}
+
+func TestEqualHashes(t *testing.T) {
+	h := hashData([]byte("A"))
+	testCases := []struct {
+		name     string
+		a, b     []byte
+		expected bool
+	}{
+		{"Equal", h, append([]byte{}, h...), true},
+		{"DifferentFirstByte", h, append([]byte{h[0] ^ 0xff}, h[1:]...), false},
+		{"DifferentLastByte", h, append(append([]byte{}, h[:len(h)-1]...), h[len(h)-1]^0xff), false},
+		{"DifferentLength", h, h[:len(h)-1], false},
+		{"BothEmpty", []byte{}, nil, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := equalHashes(tc.a, tc.b); got != tc.expected {
+				t.Errorf("Expected equalHashes to return %v, got %v", tc.expected, got)
+			}
+			// Results must match the previous slices.Equal based comparison
+			if got := slices.Equal(tc.a, tc.b); got != tc.expected {
+				t.Errorf("equalHashes disagrees with slices.Equal")
+			}
+		})
+	}
+}
+
+func TestOddNodeStrategy(t *testing.T) {
+	strategies := []OddNodeStrategy{OddNodeDuplicate, OddNodePromote}
+
+	t.Run("PromoteThreeLeaves", func(t *testing.T) {
+		blocks := createTestDataBlocks("A", "B", "C")
+		l0, l1, l2 := hashData(blocks[0]), hashData(blocks[1]), hashData(blocks[2])
+		expectedRoot := hashPair(hashPair(l0, l1), l2) // C is carried up unchanged
+
+		tree, err := NewTreeWithOptions(blocks, TreeOptions{OddNodeStrategy: OddNodePromote})
+		if err != nil {
+			t.Fatalf("NewTreeWithOptions failed: %v", err)
+		}
+		if !bytes.Equal(tree.Root, expectedRoot) {
+			t.Errorf("Root mismatch. Expected %x, got %x", expectedRoot, tree.Root)
+		}
+	})
+
+	for size := 1; size <= 9; size++ {
+		items := make([]string, size)
+		for i := range items {
+			items[i] = string(rune('A' + i))
+		}
+		blocks := createTestDataBlocks(items...)
+
+		trees := make(map[OddNodeStrategy]*MerkleTree)
+		for _, strategy := range strategies {
+			tree, err := NewTreeWithOptions(blocks, TreeOptions{OddNodeStrategy: strategy})
+			if err != nil {
+				t.Fatalf("NewTreeWithOptions(%v) failed for %d leaves: %v", strategy, size, err)
+			}
+			trees[strategy] = tree
+		}
+
+		for _, proveWith := range strategies {
+			for index := range size {
+				proof, leafHash, err := trees[proveWith].GenerateProof(index)
+				if err != nil {
+					t.Fatalf("GenerateProof(%v) failed: %v", proveWith, err)
+				}
+				for _, verifyWith := range strategies {
+					root := trees[verifyWith].Root
+					isValid, err := VerifyProofWithOptions(root, proof, leafHash, index, size, TreeOptions{OddNodeStrategy: verifyWith})
+					// Trees only diverge once an odd level appears
+					shouldVerify := proveWith == verifyWith || bytes.Equal(trees[OddNodeDuplicate].Root, trees[OddNodePromote].Root)
+					// A proof from the other strategy may also have the wrong length
+					if err != nil && (shouldVerify || !errors.Is(err, ErrProofLengthMismatch)) {
+						t.Errorf("VerifyProofWithOptions returned error for %d leaves: %v", size, err)
+					}
+					if isValid != shouldVerify {
+						t.Errorf("Size %d, index %d: proof from %v verified=%v under %v", size, index, proveWith, isValid, verifyWith)
+					}
+				}
+			}
+		}
+	}
+
+	t.Run("PromoteRequiresTreeSize", func(t *testing.T) {
+		tree, _ := NewTreeWithOptions(createTestDataBlocks("A", "B", "C"), TreeOptions{OddNodeStrategy: OddNodePromote})
+		proof, leafHash, _ := tree.GenerateProof(2)
+		_, err := VerifyProofWithOptions(tree.Root, proof, leafHash, 2, 0, TreeOptions{OddNodeStrategy: OddNodePromote})
+		if !errors.Is(err, ErrTreeSizeRequired) {
+			t.Errorf("Expected ErrTreeSizeRequired, got %v", err)
+		}
+	})
+
+	t.Run("ParseOddNodeStrategy", func(t *testing.T) {
+		for _, strategy := range strategies {
+			parsed, err := ParseOddNodeStrategy(strategy.String())
+			if err != nil || parsed != strategy {
+				t.Errorf("Round trip of %v failed: got %v, %v", strategy, parsed, err)
+			}
+		}
+		if _, err := ParseOddNodeStrategy("carry"); !errors.Is(err, ErrUnknownOddNodeStrategy) {
+			t.Errorf("Expected ErrUnknownOddNodeStrategy, got %v", err)
+		}
+	})
+}
+
+func TestVerifyProofHex(t *testing.T) {
+	tree, _ := NewTree(createTestDataBlocks("A", "B", "C"))
+	proof, leafHash, _ := tree.GenerateProof(2)
+	rootHex := hex.EncodeToString(tree.Root)
+	leafHex := hex.EncodeToString(leafHash)
+	proofHex := make([]string, len(proof))
+	for i, sibling := range proof {
+		proofHex[i] = hex.EncodeToString(sibling)
+	}
+
+	t.Run("ValidHex", func(t *testing.T) {
+		isValid, err := VerifyProofHex(rootHex, proofHex, leafHex, 2)
+		if err != nil {
+			t.Fatalf("VerifyProofHex failed: %v", err)
+		}
+		if !isValid {
+			t.Errorf("VerifyProofHex returned false for a valid proof")
+		}
+		// Hex digits are case-insensitive
+		isValid, _ = VerifyProofHex(strings.ToUpper(rootHex), proofHex, leafHex, 2)
+		if !isValid {
+			t.Errorf("VerifyProofHex returned false for upper-case root")
+		}
+	})
+
+	t.Run("WrongIndex", func(t *testing.T) {
+		isValid, err := VerifyProofHex(rootHex, proofHex, leafHex, 1)
+		if err != nil || isValid {
+			t.Errorf("Expected false without error for wrong index, got %v, %v", isValid, err)
+		}
+	})
+
+	invalidCases := []struct {
+		name  string
+		root  string
+		proof []string
+		leaf  string
+	}{
+		{"OddLengthRoot", rootHex[1:], proofHex, leafHex},
+		{"OddLengthSibling", rootHex, []string{proofHex[0][1:], proofHex[1]}, leafHex},
+		{"NonHexLeaf", rootHex, proofHex, "zz" + leafHex[2:]},
+		{"ShortLeaf", rootHex, proofHex, leafHex[2:]},
+	}
+	for _, tc := range invalidCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := VerifyProofHex(tc.root, tc.proof, tc.leaf, 2)
+			if !errors.Is(err, ErrInvalidHex) {
+				t.Errorf("Expected ErrInvalidHex, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewTreeFromLeafHashes(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		blocks := make([][]byte, size)
+		for i := range blocks {
+			blocks[i] = fmt.Appendf(nil, "block-%d", i)
+		}
+		tree, _ := NewTree(blocks)
+		rebuilt, err := NewTreeFromLeafHashes(tree.GetLeaves())
+		if err != nil {
+			t.Fatalf("NewTreeFromLeafHashes failed for %d leaves: %v", size, err)
+		}
+		if !bytes.Equal(rebuilt.Root, tree.Root) {
+			t.Errorf("%d leaves: expected root %x, got %x", size, tree.Root, rebuilt.Root)
+		}
+		proof, leafHash, _ := rebuilt.GenerateProof(size - 1)
+		if isValid, err := VerifyProof(tree.Root, proof, leafHash, size-1); err != nil || !isValid {
+			t.Errorf("%d leaves: expected proofs from the rebuilt tree to verify, got %v, %v", size, isValid, err)
+		}
+	}
+
+	leaves := [][]byte{bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)}
+	tree, _ := NewTreeFromLeafHashes(leaves)
+	leaves[0][0] = 0xff
+	if tree.Leaves[0][0] == 0xff {
+		t.Error("Expected the tree to keep its own copy of the leaf hashes")
+	}
+
+	testCases := []struct {
+		name   string
+		leaves [][]byte
+		err    error
+	}{
+		{"NoLeaves", nil, ErrZeroLeaves},
+		{"EmptyHash", [][]byte{bytes.Repeat([]byte{1}, 32), {}}, ErrInvalidLeafHash},
+		{"MixedLengths", [][]byte{bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 20)}, ErrInvalidLeafHash},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewTreeFromLeafHashes(tc.leaves); !errors.Is(err, tc.err) {
+				t.Errorf("Expected %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestHexAccessors(t *testing.T) {
+	tree, err := NewTree([][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	if err != nil {
+		t.Fatalf("NewTree failed: %v", err)
+	}
+	if got := tree.RootHex(); got != hex.EncodeToString(tree.Root) {
+		t.Errorf("RootHex mismatch. Expected %x, got %s", tree.Root, got)
+	}
+	leafHexes := tree.LeafHexes()
+	if len(leafHexes) != len(tree.Leaves) {
+		t.Fatalf("Expected %d leaf hexes, got %d", len(tree.Leaves), len(leafHexes))
+	}
+	for i, leaf := range tree.Leaves {
+		if leafHexes[i] != hex.EncodeToString(leaf) {
+			t.Errorf("LeafHexes[%d] mismatch. Expected %x, got %s", i, leaf, leafHexes[i])
+		}
+	}
+
+	var nilTree *MerkleTree
+	if got := nilTree.RootHex(); got != "" {
+		t.Errorf("Expected empty RootHex for nil tree, got %q", got)
+	}
+	if got := nilTree.LeafHexes(); got != nil {
+		t.Errorf("Expected nil LeafHexes for nil tree, got %v", got)
+	}
+}
+
+func TestTreeShapeAccessors(t *testing.T) {
+	testCases := []struct {
+		leaves, height, nodeCount int
+	}{
+		{1, 1, 1},
+		{2, 2, 3},
+		{3, 3, 6},
+		{4, 3, 7},
+		{5, 4, 11},
+		{8, 4, 15},
+		{9, 5, 20},
+		{1024, 11, 2047},
+	}
+	for _, tc := range testCases {
+		blocks := make([][]byte, tc.leaves)
+		for i := range blocks {
+			blocks[i] = fmt.Appendf(nil, "block-%d", i)
+		}
+		tree, _ := NewTree(blocks)
+		if tree.Height() != tc.height {
+			t.Errorf("%d leaves: expected height %d, got %d", tc.leaves, tc.height, tree.Height())
+		}
+		if tree.NodeCount() != tc.nodeCount {
+			t.Errorf("%d leaves: expected %d nodes, got %d", tc.leaves, tc.nodeCount, tree.NodeCount())
+		}
+	}
+
+	tree, _ := NewTree(createTestDataBlocks("A", "B", "C"))
+	top, err := tree.LevelNodes(tree.Height() - 1)
+	if err != nil || len(top) != 1 || !bytes.Equal(top[0], tree.Root) {
+		t.Errorf("Expected the top level to hold only the root, got %x, %v", top, err)
+	}
+	leaves, _ := tree.LevelNodes(0)
+	leaves[0][0] ^= 0xff
+	if bytes.Equal(leaves[0], tree.Leaves[0]) {
+		t.Errorf("Expected LevelNodes to return copies")
+	}
+	for _, level := range []int{-1, tree.Height()} {
+		if _, err := tree.LevelNodes(level); !errors.Is(err, ErrOutOfBoundary) {
+			t.Errorf("Level %d: expected ErrOutOfBoundary, got %v", level, err)
+		}
+	}
+}
+
+func TestNilTree(t *testing.T) {
+	var tree *MerkleTree
+	if root := tree.GetRoot(); root != nil {
+		t.Errorf("Expected nil root from nil tree, got %x", root)
+	}
+	if leaves := tree.GetLeaves(); leaves != nil {
+		t.Errorf("Expected nil leaves from nil tree, got %v", leaves)
+	}
+	if _, _, err := tree.GenerateProof(0); !errors.Is(err, ErrNilTree) {
+		t.Errorf("Expected ErrNilTree from GenerateProof, got %v", err)
+	}
+
+	var lazy *LazyTree
+	if leaves := lazy.GetLeaves(); leaves != nil {
+		t.Errorf("Expected nil leaves from nil lazy tree, got %v", leaves)
+	}
+	if _, err := lazy.Root(); !errors.Is(err, ErrNilTree) {
+		t.Errorf("Expected ErrNilTree from LazyTree.Root, got %v", err)
+	}
+	if _, _, err := lazy.GenerateProof(0); !errors.Is(err, ErrNilTree) {
+		t.Errorf("Expected ErrNilTree from LazyTree.GenerateProof, got %v", err)
+	}
+}
+
+func TestVerifyAppendHistory(t *testing.T) {
+	blocks := createTestDataBlocks("A", "B", "C", "D", "E", "F")
+	roots := make([][]byte, len(blocks))
+	for i := range blocks {
+		tree, err := NewTree(blocks[:i+1])
+		if err != nil {
+			t.Fatalf("NewTree failed: %v", err)
+		}
+		roots[i] = tree.Root
+	}
+
+	t.Run("CorrectHistory", func(t *testing.T) {
+		ok, index, err := VerifyAppendHistory(blocks, roots)
+		if err != nil {
+			t.Fatalf("VerifyAppendHistory failed: %v", err)
+		}
+		if !ok || index != -1 {
+			t.Errorf("Expected (true, -1), got (%v, %d)", ok, index)
+		}
+	})
+
+	t.Run("TamperedIntermediateRoot", func(t *testing.T) {
+		tampered := slices.Clone(roots)
+		tampered[3] = append([]byte{}, roots[3]...)
+		tampered[3][0] ^= 0xff
+		ok, index, err := VerifyAppendHistory(blocks, tampered)
+		if err != nil {
+			t.Fatalf("VerifyAppendHistory failed: %v", err)
+		}
+		if ok || index != 3 {
+			t.Errorf("Expected (false, 3), got (%v, %d)", ok, index)
+		}
+	})
+
+	t.Run("LongHistory", func(t *testing.T) {
+		// Enough appends to grow, fill and unbalance several levels
+		var long [][]byte
+		var longRoots [][]byte
+		for i := range 37 {
+			long = append(long, []byte(fmt.Sprint(i)))
+			tree, _ := NewTree(long)
+			longRoots = append(longRoots, tree.Root)
+		}
+		if ok, index, err := VerifyAppendHistory(long, longRoots); err != nil || !ok {
+			t.Errorf("Expected (true, -1, nil), got (%v, %d, %v)", ok, index, err)
+		}
+	})
+
+	t.Run("InvalidInputs", func(t *testing.T) {
+		if _, _, err := VerifyAppendHistory(nil, nil); !errors.Is(err, ErrEmptyMessage) {
+			t.Errorf("Expected ErrEmptyMessage, got %v", err)
+		}
+		if _, _, err := VerifyAppendHistory(blocks, roots[:2]); !errors.Is(err, ErrHistoryLength) {
+			t.Errorf("Expected ErrHistoryLength, got %v", err)
+		}
+	})
+}
+
+func TestSameDataSet(t *testing.T) {
+	blocks := createTestDataBlocks("A", "B", "C", "D", "E")
+	duplicateTree, _ := NewTreeWithOptions(blocks, TreeOptions{OddNodeStrategy: OddNodeDuplicate})
+	promoteTree, _ := NewTreeWithOptions(blocks, TreeOptions{OddNodeStrategy: OddNodePromote})
+	if bytes.Equal(duplicateTree.Root, promoteTree.Root) {
+		t.Fatalf("Test setup expects differing roots")
+	}
+
+	t.Run("SameDataDifferentRoots", func(t *testing.T) {
+		same, err := SameDataSet(duplicateTree, blocks, promoteTree, slices.Clone(blocks))
+		if err != nil {
+			t.Fatalf("SameDataSet failed: %v", err)
+		}
+		if !same {
+			t.Errorf("Expected identical data sets despite differing roots")
+		}
+	})
+
+	t.Run("DifferentData", func(t *testing.T) {
+		otherBlocks := createTestDataBlocks("A", "B", "X", "D", "E")
+		otherTree, _ := NewTreeWithOptions(otherBlocks, TreeOptions{OddNodeStrategy: OddNodePromote})
+		same, err := SameDataSet(duplicateTree, blocks, otherTree, otherBlocks)
+		if err != nil {
+			t.Fatalf("SameDataSet failed: %v", err)
+		}
+		if same {
+			t.Errorf("Expected differing data sets")
+		}
+	})
+
+	t.Run("BlocksDoNotMatchTree", func(t *testing.T) {
+		_, err := SameDataSet(duplicateTree, createTestDataBlocks("A"), promoteTree, blocks)
+		if !errors.Is(err, ErrLeafDataMismatch) {
+			t.Errorf("Expected ErrLeafDataMismatch, got %v", err)
+		}
+	})
+}
+
+func TestHashOpCount(t *testing.T) {
+	counter := &atomic.Int64{}
+	hashOpCounter = counter
+	defer func() { hashOpCounter = nil }()
+
+	for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote} {
+		opts := WithTreeOptions(TreeOptions{OddNodeStrategy: strategy})
+		for size := 1; size <= 64; size++ {
+			blocks := make([][]byte, size)
+			for i := range blocks {
+				blocks[i] = []byte{byte(i)}
+			}
+			counter.Store(0)
+			if _, err := NewTreeOpts(blocks, opts); err != nil {
+				t.Fatalf("NewTreeOpts failed for %d leaves: %v", size, err)
+			}
+			if actual := counter.Load(); int64(HashOpCount(size, opts)) != actual {
+				t.Errorf("Strategy %v, size %d: HashOpCount returned %d, NewTreeOpts performed %d", strategy, size, HashOpCount(size, opts), actual)
+			}
+		}
+	}
+
+	if HashOpCount(5) != 5+3+2+1 {
+		t.Errorf("Expected duplicated odd nodes to be hashed by default, got %d", HashOpCount(5))
+	}
+	promote := WithTreeOptions(TreeOptions{OddNodeStrategy: OddNodePromote})
+	if HashOpCount(5, promote) != 5+2+1+1 {
+		t.Errorf("Expected promoted odd nodes not to be hashed, got %d", HashOpCount(5, promote))
+	}
+	if HashOpCount(5, promote, WithPreHashed()) != 2+1+1 {
+		t.Errorf("Expected pre-hashed leaves not to be counted, got %d", HashOpCount(5, promote, WithPreHashed()))
+	}
+	if HashOpCount(0) != 0 {
+		t.Errorf("Expected zero operations for zero leaves")
+	}
+}
+
+func TestNewTreeWithHasher(t *testing.T) {
+	blocks := createTestDataBlocks("A", "B", "C", "D", "E")
+
+	defaultTree, _ := NewTree(blocks)
+	sha256Tree, err := NewTreeWithHasher(blocks, sha256.New)
+	if err != nil {
+		t.Fatalf("NewTreeWithHasher failed: %v", err)
+	}
+	if !bytes.Equal(defaultTree.Root, sha256Tree.Root) {
+		t.Errorf("Expected an explicit SHA-256 hasher to match NewTree")
+	}
+
+	tree, err := NewTreeWithHasher(blocks, sha512.New)
+	if err != nil {
+		t.Fatalf("NewTreeWithHasher failed: %v", err)
+	}
+	if len(tree.Root) != sha512.Size {
+		t.Errorf("Expected a %d-byte root, got %d bytes", sha512.Size, len(tree.Root))
+	}
+	for i := range blocks {
+		proof, leafHash, _ := tree.GenerateProof(i)
+		isValid, err := VerifyProofWithHasher(tree.Root, proof, leafHash, i, sha512.New)
+		if err != nil || !isValid {
+			t.Errorf("Expected leaf %d to verify with SHA-512, got %v, %v", i, isValid, err)
+		}
+		if isValid, _ := VerifyProof(tree.Root, proof, leafHash, i); isValid {
+			t.Errorf("Expected leaf %d not to verify with the default hasher", i)
+		}
+	}
+
+	same, err := SameDataSet(defaultTree, blocks, tree, blocks)
+	if err != nil || !same {
+		t.Errorf("Expected trees with different hashers over the same data to match, got %v, %v", same, err)
+	}
+}
+
+func TestDomainSeparation(t *testing.T) {
+	blocks := createTestDataBlocks("A", "B", "C", "D")
+
+	// An attacker presents the children of an internal node as leaf data, claiming
+	// a two-leaf tree whose first leaf is that node
+	forge := func(opts TreeOptions) (bool, error) {
+		tree, _ := NewTreeWithOptions(blocks, opts)
+		forgedData := slices.Concat(tree.nodes[0][0], tree.nodes[0][1])
+		forgedLeaf := opts.hashLeaf(forgedData)
+		return VerifyProofWithOptions(tree.Root, [][]byte{tree.nodes[1][1]}, forgedLeaf, 0, 2, opts)
+	}
+
+	if isValid, err := forge(TreeOptions{}); err != nil || !isValid {
+		t.Errorf("Expected the forged leaf to verify without domain separation, got %v, %v", isValid, err)
+	}
+	if isValid, err := forge(TreeOptions{DomainSeparation: true}); err != nil || isValid {
+		t.Errorf("Expected the forged leaf to be rejected with domain separation, got %v, %v", isValid, err)
+	}
+
+	opts := TreeOptions{DomainSeparation: true}
+	tree, _ := NewTreeWithOptions(blocks, opts)
+	plain, _ := NewTree(blocks)
+	if bytes.Equal(tree.Root, plain.Root) {
+		t.Errorf("Expected domain separation to change the root")
+	}
+	for i := range blocks {
+		proof, leafHash, _ := tree.GenerateProof(i)
+		isValid, err := VerifyProofWithOptions(tree.Root, proof, leafHash, i, len(blocks), opts)
+		if err != nil || !isValid {
+			t.Errorf("Expected leaf %d to verify with domain separation, got %v, %v", i, isValid, err)
+		}
+	}
+}
+
+func TestVerifyProofWithSize(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		blocks := make([][]byte, size)
+		for i := range blocks {
+			blocks[i] = fmt.Appendf(nil, "block-%d", i)
+		}
+		for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote} {
+			opts := TreeOptions{OddNodeStrategy: strategy}
+			tree, _ := NewTreeWithOptions(blocks, opts)
+			for index := range size {
+				proof, leafHash, _ := tree.GenerateProof(index)
+				if isValid, err := VerifyProofWithOptions(tree.Root, proof, leafHash, index, size, opts); err != nil || !isValid {
+					t.Errorf("%s/%d/%d: expected valid proof, got %v, %v", strategy, size, index, isValid, err)
+				}
+			}
+		}
+	}
+
+	tree, _ := NewTree(createTestDataBlocks("A", "B", "C", "D", "E"))
+	proof, leafHash, _ := tree.GenerateProof(1)
+	testCases := []struct {
+		name  string
+		proof [][]byte
+	}{
+		{"UnderLength", proof[:len(proof)-1]},
+		{"OverLength", append(slices.Clone(proof), tree.Root)},
+		{"Empty", nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := VerifyProofWithSize(tree.Root, tc.proof, leafHash, 1, len(tree.Leaves)); !errors.Is(err, ErrProofLengthMismatch) {
+				t.Errorf("Expected ErrProofLengthMismatch, got %v", err)
+			}
+		})
+	}
+	if isValid, err := VerifyProofWithSize(tree.Root, proof, leafHash, 1, len(tree.Leaves)); err != nil || !isValid {
+		t.Errorf("Expected the untouched proof to verify, got %v, %v", isValid, err)
+	}
+	if _, err := VerifyProofWithSize(tree.Root, proof, leafHash, 5, len(tree.Leaves)); !errors.Is(err, ErrOutOfBoundary) {
+		t.Errorf("Expected ErrOutOfBoundary, got %v", err)
+	}
+}
+
+func TestVerifyProofRoot(t *testing.T) {
+	tree, _ := NewTree(createTestDataBlocks("A", "B", "C", "D", "E"))
+	for index := range tree.Leaves {
+		proof, leafHash, _ := tree.GenerateProof(index)
+		root, err := VerifyProofRoot(proof, leafHash, index)
+		if err != nil {
+			t.Fatalf("VerifyProofRoot failed for leaf %d: %v", index, err)
+		}
+		if !bytes.Equal(root, tree.Root) {
+			t.Errorf("Leaf %d: expected root %x, got %x", index, tree.Root, root)
+		}
+
+		tampered := slices.Clone(proof)
+		tampered[0] = tree.Root
+		root, err = VerifyProofRoot(tampered, leafHash, index)
+		if err != nil {
+			t.Fatalf("VerifyProofRoot failed for tampered leaf %d: %v", index, err)
+		}
+		if bytes.Equal(root, tree.Root) {
+			t.Errorf("Leaf %d: expected a tampered proof to reconstruct a different root", index)
+		}
+	}
+
+	if _, err := VerifyProofRoot(nil, nil, 0); !errors.Is(err, ErrInvalidProofInputs) {
+		t.Errorf("Expected ErrInvalidProofInputs, got %v", err)
+	}
+	if _, err := VerifyProofRoot([][]byte{nil}, tree.Leaves[0], 0); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("Expected ErrInvalidProof, got %v", err)
+	}
+}
+
+func TestWalkProof(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		blocks := make([][]byte, size)
+		for i := range blocks {
+			blocks[i] = fmt.Appendf(nil, "block-%d", i)
+		}
+		for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote} {
+			opts := TreeOptions{OddNodeStrategy: strategy}
+			tree, _ := NewTreeWithOptions(blocks, opts)
+			for index := range size {
+				var walked [][]byte
+				current := tree.Leaves[index]
+				err := tree.WalkProof(index, func(sibling []byte, isRight bool) error {
+					walked = append(walked, sibling)
+					if isRight {
+						current = opts.hashNode(current, sibling)
+					} else {
+						current = opts.hashNode(sibling, current)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("%s/%d/%d: WalkProof failed: %v", strategy, size, index, err)
+				}
+				proof, _, _ := tree.GenerateProof(index)
+				if !slices.EqualFunc(walked, proof, bytes.Equal) {
+					t.Errorf("%s/%d/%d: walked siblings don't match GenerateProof", strategy, size, index)
+				}
+				if !bytes.Equal(current, tree.Root) {
+					t.Errorf("%s/%d/%d: hashing along the walk doesn't reach the root", strategy, size, index)
+				}
+			}
+		}
+	}
+
+	tree, _ := NewTree(createTestDataBlocks("A", "B", "C", "D", "E"))
+	errStop := errors.New("stop")
+	calls := 0
+	err := tree.WalkProof(2, func([]byte, bool) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || calls != 1 {
+		t.Errorf("Expected the walk to stop at the first error, got %v after %d calls", err, calls)
+	}
+	if err := tree.WalkProof(5, func([]byte, bool) error { return nil }); !errors.Is(err, ErrOutOfBoundary) {
+		t.Errorf("Expected ErrOutOfBoundary, got %v", err)
+	}
+	var nilTree *MerkleTree
+	if err := nilTree.WalkProof(0, func([]byte, bool) error { return nil }); !errors.Is(err, ErrNilTree) {
+		t.Errorf("Expected ErrNilTree, got %v", err)
+	}
+}
+
+func TestGenerateProofWithDirections(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		blocks := make([][]byte, size)
+		for i := range blocks {
+			blocks[i] = fmt.Appendf(nil, "block-%d", i)
+		}
+		tree, _ := NewTree(blocks)
+		for index := range size {
+			steps, leafHash, err := tree.GenerateProofWithDirections(index)
+			if err != nil {
+				t.Fatalf("%d/%d: GenerateProofWithDirections failed: %v", size, index, err)
+			}
+			proof, _, _ := tree.GenerateProof(index)
+			siblings := make([][]byte, len(steps))
+			for i, step := range steps {
+				siblings[i] = step.Sibling
+			}
+			if !slices.EqualFunc(siblings, proof, bytes.Equal) {
+				t.Errorf("%d/%d: step siblings don't match GenerateProof", size, index)
+			}
+
+			byIndex, _ := VerifyProof(tree.Root, proof, leafHash, index)
+			bySteps, err := VerifyProofSteps(tree.Root, steps, leafHash)
+			if err != nil || !bySteps || !byIndex {
+				t.Errorf("%d/%d: expected both verifiers to accept, got %v and %v (%v)", size, index, byIndex, bySteps, err)
+			}
+			if size > 1 {
+				flipped := slices.Clone(steps)
+				flipped[0].IsRight = !flipped[0].IsRight
+				byWrongIndex, _ := VerifyProof(tree.Root, proof, leafHash, index^1)
+				byFlipped, _ := VerifyProofSteps(tree.Root, flipped, leafHash)
+				if byFlipped != byWrongIndex {
+					t.Errorf("%d/%d: flipping the first side gave %v, the neighbouring index gave %v", size, index, byFlipped, byWrongIndex)
+				}
+			}
+		}
+	}
+
+	tree, _ := NewTree(createTestDataBlocks("A", "B", "C"))
+	steps, leafHash, _ := tree.GenerateProofWithDirections(1)
+	if isValid, _ := VerifyProofSteps(tree.Root, steps, tree.Leaves[0]); isValid {
+		t.Error("Expected a different leaf to be rejected")
+	}
+	steps[0].Sibling = nil
+	if _, err := VerifyProofSteps(tree.Root, steps, leafHash); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("Expected ErrInvalidProof, got %v", err)
+	}
+	if _, err := VerifyProofSteps(nil, steps, leafHash); !errors.Is(err, ErrInvalidProofInputs) {
+		t.Errorf("Expected ErrInvalidProofInputs, got %v", err)
+	}
+	if _, _, err := tree.GenerateProofWithDirections(3); !errors.Is(err, ErrOutOfBoundary) {
+		t.Errorf("Expected ErrOutOfBoundary, got %v", err)
+	}
+}
+
+func TestNewTreeOptsPreHashed(t *testing.T) {
+	preimages := createTestDataBlocks("A", "B", "C", "D", "E")
+	for _, opts := range []TreeOptions{{}, {DomainSeparation: true}, {OddNodeStrategy: OddNodePromote}} {
+		digests := hashLeaves(preimages, opts)
+		tree, err := NewTreeOpts(digests, WithPreHashed(), WithTreeOptions(opts))
+		if err != nil {
+			t.Fatalf("NewTreeOpts failed: %v", err)
+		}
+		expected, _ := NewTreeWithOptions(preimages, opts)
+		if !bytes.Equal(tree.Root, expected.Root) {
+			t.Errorf("%+v: expected the pre-hashed root to match the tree over the pre-images", opts)
+		}
+
+		doubleHashed, _ := NewTreeOpts(digests, WithTreeOptions(opts))
+		if bytes.Equal(doubleHashed.Root, expected.Root) {
+			t.Errorf("%+v: expected digests without WithPreHashed to be hashed again", opts)
+		}
+	}
+
+	digests := hashLeaves(preimages, TreeOptions{})
+	tree, _ := NewTreeOpts(digests, WithPreHashed())
+	digests[0][0] ^= 0xff
+	if bytes.Equal(tree.Leaves[0], digests[0]) {
+		t.Error("Expected the tree to keep its own copy of the digests")
+	}
+
+	if _, err := NewTreeOpts([][]byte{bytes.Repeat([]byte{1}, 20)}, WithPreHashed()); !errors.Is(err, ErrInvalidLeafHash) {
+		t.Errorf("Expected ErrInvalidLeafHash for a short digest, got %v", err)
+	}
+	sha256Digest := bytes.Repeat([]byte{1}, 32)
+	if _, err := NewTreeOpts([][]byte{sha256Digest}, WithPreHashed(), WithTreeOptions(TreeOptions{Hash: sha512.New})); !errors.Is(err, ErrInvalidLeafHash) {
+		t.Errorf("Expected ErrInvalidLeafHash for a digest shorter than the tree's hash, got %v", err)
+	}
+	if _, err := NewTreeOpts(nil, WithPreHashed()); !errors.Is(err, ErrEmptyMessage) {
+		t.Errorf("Expected ErrEmptyMessage, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote} {
+			blocks := make([][]byte, size)
+			for i := range blocks {
+				blocks[i] = fmt.Appendf(nil, "block-%d", i)
+			}
+			tree, _ := NewTreeWithOptions(blocks, TreeOptions{OddNodeStrategy: strategy})
+			if err := tree.Validate(); err != nil {
+				t.Errorf("%s/%d: expected a fresh tree to be valid, got %v", strategy, size, err)
+			}
+		}
+	}
+
+	corruptions := []struct {
+		name    string
+		corrupt func(tree *MerkleTree)
+	}{
+		{"InnerNode", func(tree *MerkleTree) { tree.nodes[1][1] = bytes.Repeat([]byte{0xff}, 32) }},
+		{"LeafLevel", func(tree *MerkleTree) { tree.nodes[0][2] = bytes.Repeat([]byte{0xff}, 32) }},
+		{"Leaf", func(tree *MerkleTree) { tree.Leaves = slices.Clone(tree.Leaves[:4]) }},
+		{"MissingNode", func(tree *MerkleTree) { tree.nodes[2] = tree.nodes[2][:1] }},
+		{"Root", func(tree *MerkleTree) { tree.Root = bytes.Repeat([]byte{0xff}, 32) }},
+	}
+	for _, tc := range corruptions {
+		t.Run(tc.name, func(t *testing.T) {
+			tree, _ := NewTree(createTestDataBlocks("A", "B", "C", "D", "E"))
+			tc.corrupt(tree)
+			if err := tree.Validate(); !errors.Is(err, ErrTreeInconsistent) {
+				t.Errorf("Expected ErrTreeInconsistent, got %v", err)
+			}
+		})
+	}
+
+	var nilTree *MerkleTree
+	if err := nilTree.Validate(); !errors.Is(err, ErrNilTree) {
+		t.Errorf("Expected ErrNilTree, got %v", err)
+	}
+}
+
+// withParallelLevelThreshold runs fn with levels of at least threshold pairs
+// hashed in parallel
+func withParallelLevelThreshold(threshold int, fn func()) {
+	previous := parallelLevelThreshold
+	parallelLevelThreshold = threshold
+	defer func() { parallelLevelThreshold = previous }()
+	fn()
+}
+
+func TestParallelTreeMatchesSerial(t *testing.T) {
+	// Split levels between several workers even on a single CPU
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	rng := rand.New(rand.NewSource(1))
+	sizes := []int{1, 2, 3, 4, 5, 7, 8, 9, 1023, 1024, 1025}
+	for range 20 {
+		sizes = append(sizes, 1+rng.Intn(5000))
+	}
+
+	for _, size := range sizes {
+		blocks := make([][]byte, size)
+		for i := range blocks {
+			blocks[i] = fmt.Appendf(nil, "block-%d", i)
+		}
+		for _, opts := range []TreeOptions{{}, {OddNodeStrategy: OddNodePromote}} {
+			var serial, parallel *MerkleTree
+			withParallelLevelThreshold(math.MaxInt, func() { serial, _ = NewTreeWithOptions(blocks, opts) })
+			withParallelLevelThreshold(1, func() { parallel, _ = NewTreeWithOptions(blocks, opts) })
+			if !bytes.Equal(serial.Root, parallel.Root) {
+				t.Fatalf("%d leaves (%s): parallel root %x differs from serial root %x", size, opts.OddNodeStrategy, parallel.Root, serial.Root)
+			}
+			for level := range serial.nodes {
+				if !slices.EqualFunc(serial.nodes[level], parallel.nodes[level], bytes.Equal) {
+					t.Fatalf("%d leaves (%s): level %d differs", size, opts.OddNodeStrategy, level)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkNewTreeMillionLeaves(b *testing.B) {
+	blocks := make([][]byte, 1<<20)
+	for i := range blocks {
+		blocks[i] = fmt.Appendf(nil, "block-%d", i)
+	}
+	for _, bc := range []struct {
+		name      string
+		threshold int
+	}{{"Serial", math.MaxInt}, {"Parallel", parallelLevelThreshold}} {
+		b.Run(bc.name, func(b *testing.B) {
+			withParallelLevelThreshold(bc.threshold, func() {
+				for i := 0; i < b.N; i++ {
+					if _, err := NewTree(blocks); err != nil {
+						b.Fatalf("NewTree failed: %v", err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestComputeRoot(t *testing.T) {
+	for size := 1; size <= 300; size++ {
+		blocks := make([][]byte, size)
+		for i := range blocks {
+			blocks[i] = fmt.Appendf(nil, "block-%d", i)
+		}
+		tree, _ := NewTree(blocks)
+		root, err := ComputeRoot(blocks)
+		if err != nil {
+			t.Fatalf("ComputeRoot failed for %d blocks: %v", size, err)
+		}
+		if !bytes.Equal(root, tree.Root) {
+			t.Fatalf("%d blocks: ComputeRoot returned %x, NewTree %x", size, root, tree.Root)
+		}
+	}
+	if _, err := ComputeRoot(nil); !errors.Is(err, ErrEmptyMessage) {
+		t.Errorf("Expected ErrEmptyMessage, got %v", err)
+	}
+
+	// Options change the root exactly as they change NewTreeOpts
+	optionSets := [][]Option{
+		{WithTreeOptions(TreeOptions{OddNodeStrategy: OddNodePromote})},
+		{WithTreeOptions(TreeOptions{DomainSeparation: true})},
+		{WithTreeOptions(TreeOptions{Hash: sha512.New, OddNodeStrategy: OddNodePromote, DomainSeparation: true})},
+	}
+	for _, opts := range optionSets {
+		for size := 1; size <= 40; size++ {
+			blocks := make([][]byte, size)
+			for i := range blocks {
+				blocks[i] = fmt.Appendf(nil, "block-%d", i)
+			}
+			tree, _ := NewTreeOpts(blocks, opts...)
+			root, err := ComputeRoot(blocks, opts...)
+			if err != nil || !bytes.Equal(root, tree.Root) {
+				t.Fatalf("%d blocks: ComputeRoot returned %x, %v, NewTreeOpts %x", size, root, err, tree.Root)
+			}
+		}
+	}
+
+	tree, _ := NewTree(createTestDataBlocks("A", "B", "C"))
+	if root, err := ComputeRoot(tree.Leaves, WithPreHashed()); err != nil || !bytes.Equal(root, tree.Root) {
+		t.Errorf("Expected pre-hashed leaves to give the tree's root, got %x, %v", root, err)
+	}
+	if _, err := ComputeRoot([][]byte{[]byte("short")}, WithPreHashed()); !errors.Is(err, ErrInvalidLeafHash) {
+		t.Errorf("Expected ErrInvalidLeafHash, got %v", err)
+	}
+}
+
+func BenchmarkComputeRoot(b *testing.B) {
+	blocks := make([][]byte, 1<<16)
+	for i := range blocks {
+		blocks[i] = fmt.Appendf(nil, "block-%d", i)
+	}
+	b.Run("NewTree", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			NewTree(blocks)
+		}
+	})
+	b.Run("ComputeRoot", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ComputeRoot(blocks)
+		}
+	})
+}
+
+func TestOddNodeStrategyShapes(t *testing.T) {
+	h := func(data ...[]byte) []byte {
+		hash := sha256.Sum256(slices.Concat(data...))
+		return hash[:]
+	}
+	a, b, c, d, e := h([]byte("A")), h([]byte("B")), h([]byte("C")), h([]byte("D")), h([]byte("E"))
+	ab, cd := h(a, b), h(c, d)
+	abcd := h(ab, cd)
+
+	testCases := []struct {
+		name     string
+		leaves   []string
+		strategy OddNodeStrategy
+		root     []byte
+	}{
+		// C is paired with itself, then carried up as h(C, C)
+		{"ThreeDuplicate", []string{"A", "B", "C"}, OddNodeDuplicate, h(ab, h(c, c))},
+		// C is carried up unchanged and joins AB one level higher
+		{"ThreePromote", []string{"A", "B", "C"}, OddNodePromote, h(ab, c)},
+		{"FiveDuplicate", []string{"A", "B", "C", "D", "E"}, OddNodeDuplicate, h(abcd, h(h(e, e), h(e, e)))},
+		{"FivePromote", []string{"A", "B", "C", "D", "E"}, OddNodePromote, h(abcd, e)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := TreeOptions{OddNodeStrategy: tc.strategy}
+			tree, err := NewTreeWithOptions(createTestDataBlocks(tc.leaves...), opts)
+			if err != nil {
+				t.Fatalf("NewTreeWithOptions failed: %v", err)
+			}
+			if !bytes.Equal(tree.Root, tc.root) {
+				t.Fatalf("Expected root %x, got %x", tc.root, tree.Root)
+			}
+			for i := range tc.leaves {
+				proof, leafHash, _ := tree.GenerateProof(i)
+				isValid, err := VerifyProofWithOptions(tree.Root, proof, leafHash, i, len(tc.leaves), opts)
+				if err != nil || !isValid {
+					t.Errorf("Leaf %d: expected proof to hash to the root, got %v, %v", i, isValid, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
+// Names of the files the package itself keeps at the root of a destination.
+// They are never hashed, compared or deleted.
+const journalName = ".merkle-journal"
+
+var metadataNames = []string{journalName}
+
+// isMetadataFile reports whether a slash-separated path relative to a scanned
+// root is one of the package's own metadata files, including the temporary
+// archives left behind by an interrupted batch copy
+func isMetadataFile(relPath string) bool {
+	if slices.Contains(metadataNames, relPath) {
+		return true
+	}
+	name := path.Base(relPath)
+	return relPath == name && strings.HasPrefix(name, ".merkle-batch-") && strings.HasSuffix(name, ".tar")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"math/bits"
+	"slices"
+)
+
+// mmrHashOptions hashes MMR nodes like a MerkleTree built with default options
+var mmrHashOptions = TreeOptions{}
+
+// MMR is a Merkle Mountain Range: an append-only accumulator made of perfect
+// binary trees ("peaks"), one for every set bit of the leaf count, largest
+// first. Appending only merges equal-height peaks, so earlier nodes never
+// change. The root bags the peaks right to left:
+// H(peak0 || H(peak1 || ... peakN)). Nodes are hashed like MerkleTree's.
+type MMR struct {
+	// nodes[h] holds every completed node of height h, in order; node j
+	// covers leaves [j*2^h, (j+1)*2^h).
+	nodes [][][]byte
+}
+
+// MMRProof proves a leaf against an MMR root of a given size.
+type MMRProof struct {
+	Siblings [][]byte // Path from the leaf up to its peak, bottom to top
+	Peaks    [][]byte // Every other peak, left to right
+}
+
+// NewMMR returns an empty Merkle Mountain Range.
+func NewMMR() *MMR {
+	return &MMR{}
+}
+
+// Size returns the number of leaves appended so far.
+func (m *MMR) Size() int {
+	if len(m.nodes) == 0 {
+		return 0
+	}
+	return len(m.nodes[0])
+}
+
+// Append adds an already-hashed leaf, merging peaks of equal height.
+// It costs O(log n) hashes.
+func (m *MMR) Append(leafHash []byte) {
+	node := slices.Clone(leafHash)
+	for height := 0; ; height++ {
+		if height == len(m.nodes) {
+			m.nodes = append(m.nodes, nil)
+		}
+		m.nodes[height] = append(m.nodes[height], node)
+		count := len(m.nodes[height])
+		if count%2 != 0 {
+			return
+		}
+		node = mmrHashOptions.hashNode(m.nodes[height][count-2], m.nodes[height][count-1])
+	}
+}
+
+// Root bags the peaks into a single hash. An empty MMR has a nil root.
+func (m *MMR) Root() []byte {
+	return bagPeaks(m.peaks())
+}
+
+// GenerateProof returns the proof for the leaf at index against the current root.
+func (m *MMR) GenerateProof(index int) (*MMRProof, error) {
+	size := m.Size()
+	if index < 0 || index >= size {
+		return nil, ErrOutOfBoundary
+	}
+
+	peak, height := mmrPeakOf(index, size)
+	proof := &MMRProof{Siblings: make([][]byte, 0, height)}
+	current := index
+	for h := range height {
+		proof.Siblings = append(proof.Siblings, m.nodes[h][current^1])
+		current /= 2
+	}
+	for i, p := range m.peaks() {
+		if i != peak {
+			proof.Peaks = append(proof.Peaks, p)
+		}
+	}
+	return proof, nil
+}
+
+// VerifyMMRProof checks that leafHash is the leaf at index of an MMR with
+// size leaves whose root is root.
+func VerifyMMRProof(root []byte, proof *MMRProof, leafHash []byte, index, size int) (bool, error) {
+	if len(root) == 0 || len(leafHash) == 0 || proof == nil {
+		return false, ErrInvalidProofInputs
+	}
+	if index < 0 || index >= size {
+		return false, ErrOutOfBoundary
+	}
+	peak, height := mmrPeakOf(index, size)
+	if len(proof.Siblings) != height || len(proof.Peaks) != bits.OnesCount(uint(size))-1 {
+		return false, nil
+	}
+
+	current := leafHash
+	position := index
+	for _, sibling := range proof.Siblings {
+		if len(sibling) == 0 {
+			return false, ErrInvalidProof
+		}
+		if position%2 == 0 {
+			current = mmrHashOptions.hashNode(current, sibling)
+		} else {
+			current = mmrHashOptions.hashNode(sibling, current)
+		}
+		position /= 2
+	}
+
+	peaks := slices.Insert(slices.Clone(proof.Peaks), peak, current)
+	return equalHashes(bagPeaks(peaks), root), nil
+}
+
+// peaks returns the current peaks, tallest (leftmost) first
+func (m *MMR) peaks() [][]byte {
+	size := m.Size()
+	var peaks [][]byte
+	for height := len(m.nodes) - 1; height >= 0; height-- {
+		if size&(1<<height) != 0 {
+			peaks = append(peaks, m.nodes[height][size>>height-1])
+		}
+	}
+	return peaks
+}
+
+// mmrPeakOf returns the position (left to right) and height of the peak
+// covering the leaf at index in an MMR of size leaves
+func mmrPeakOf(index, size int) (peak, height int) {
+	start := 0
+	for height = bits.Len(uint(size)) - 1; height >= 0; height-- {
+		if size&(1<<height) == 0 {
+			continue
+		}
+		if index < start+1<<height {
+			return peak, height
+		}
+		start += 1 << height
+		peak++
+	}
+	return peak, 0
+}
+
+// bagPeaks folds the peaks from right to left into the root
+func bagPeaks(peaks [][]byte) []byte {
+	if len(peaks) == 0 {
+		return nil
+	}
+	root := peaks[len(peaks)-1]
+	for i := len(peaks) - 2; i >= 0; i-- {
+		root = mmrHashOptions.hashNode(peaks[i], root)
+	}
+	return root
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/bits"
+	"testing"
+)
+
+func TestMMR(t *testing.T) {
+	leaves := hashLeaves(createTestDataBlocks("A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K"), TreeOptions{})
+
+	// 4 leaves form a single perfect peak, the same as a regular tree
+	mmr := NewMMR()
+	for _, leaf := range leaves[:4] {
+		mmr.Append(leaf)
+	}
+	tree, _ := NewTree(createTestDataBlocks("A", "B", "C", "D"))
+	if !bytes.Equal(mmr.Root(), tree.Root) {
+		t.Errorf("Expected a single-peak MMR root to equal the tree root")
+	}
+
+	// 3 leaves have peaks AB and C, bagged as H(H(A||B) || C)
+	mmr = NewMMR()
+	for _, leaf := range leaves[:3] {
+		mmr.Append(leaf)
+	}
+	want := mmrHashOptions.hashNode(mmrHashOptions.hashNode(leaves[0], leaves[1]), leaves[2])
+	if !bytes.Equal(mmr.Root(), want) {
+		t.Errorf("Expected root H(H(A||B) || C), got %x", mmr.Root())
+	}
+
+	for _, size := range []int{1, 2, 3, 4, 6, 7, 11} {
+		t.Run(fmt.Sprintf("Size%dPeaks%d", size, bits.OnesCount(uint(size))), func(t *testing.T) {
+			mmr := NewMMR()
+			for _, leaf := range leaves[:size] {
+				mmr.Append(leaf)
+			}
+			root := mmr.Root()
+			for index := range size {
+				proof, err := mmr.GenerateProof(index)
+				if err != nil {
+					t.Fatalf("GenerateProof(%d) failed: %v", index, err)
+				}
+				isValid, err := VerifyMMRProof(root, proof, leaves[index], index, size)
+				if err != nil || !isValid {
+					t.Errorf("Expected leaf %d to verify, got %v, %v", index, isValid, err)
+				}
+				wrongLeaf := leaves[(index+1)%len(leaves)]
+				if isValid, _ := VerifyMMRProof(root, proof, wrongLeaf, index, size); isValid {
+					t.Errorf("Expected a wrong leaf at %d to be rejected", index)
+				}
+				if isValid, _ := VerifyMMRProof(root, proof, leaves[index], index, size+1); isValid {
+					t.Errorf("Expected a wrong size for leaf %d to be rejected", index)
+				}
+			}
+		})
+	}
+
+	t.Run("OldProofsAgainstNewRoot", func(t *testing.T) {
+		mmr := NewMMR()
+		for _, leaf := range leaves[:5] {
+			mmr.Append(leaf)
+		}
+		proof, _ := mmr.GenerateProof(4)
+		mmr.Append(leaves[5])
+		if isValid, _ := VerifyMMRProof(mmr.Root(), proof, leaves[4], 4, 6); isValid {
+			t.Errorf("Expected a proof for an older root not to verify against the new one")
+		}
+	})
+
+	if NewMMR().Root() != nil {
+		t.Errorf("Expected an empty MMR to have a nil root")
+	}
+	if _, err := NewMMR().GenerateProof(0); !errors.Is(err, ErrOutOfBoundary) {
+		t.Errorf("Expected ErrOutOfBoundary, got %v", err)
+	}
+}
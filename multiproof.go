@@ -0,0 +1,130 @@
+package main
+
+import "slices"
+
+// MultiProof proves several leaves of one tree at once. Siblings shared by the
+// proven leaves, or computable from them, are sent only once, so proving k
+// leaves costs far fewer hashes than k separate proofs.
+type MultiProof struct {
+	Indices  []int    // Proven leaf indices, sorted and without duplicates
+	Leaves   [][]byte // Leaf hashes, in the order of Indices
+	Hashes   [][]byte // Sibling hashes the verifier can't compute, in consumption order
+	TreeSize int      // Number of leaves in the tree
+}
+
+// multiProofNode is a node whose hash is known while walking up the tree
+type multiProofNode struct {
+	index int
+	hash  []byte
+}
+
+// GenerateMultiProof returns a single proof for all leaves at indices. Indices
+// may be unsorted and contain duplicates; the proof holds each leaf once.
+func (t *MerkleTree) GenerateMultiProof(indices []int) (*MultiProof, error) {
+	if t == nil {
+		return nil, ErrNilTree
+	}
+	if len(indices) == 0 {
+		return nil, ErrInvalidProofInputs
+	}
+	sorted := slices.Compact(slices.Sorted(slices.Values(indices)))
+	if sorted[0] < 0 || sorted[len(sorted)-1] >= len(t.Leaves) {
+		return nil, ErrOutOfBoundary
+	}
+
+	proof := &MultiProof{Indices: sorted, TreeSize: len(t.Leaves)}
+	for _, index := range sorted {
+		proof.Leaves = append(proof.Leaves, t.Leaves[index])
+	}
+
+	known := sorted
+	for level := range len(t.nodes) - 1 {
+		levelNodes := t.nodes[level]
+		var parents []int
+		for i := 0; i < len(known); i++ {
+			index := known[i]
+			switch {
+			case index%2 == 0 && i+1 < len(known) && known[i+1] == index+1:
+				// Both children are known, the verifier computes the parent itself
+				i++
+			case index%2 == 0 && index == len(levelNodes)-1:
+				// The odd node is promoted or paired with itself, no sibling needed
+			default:
+				proof.Hashes = append(proof.Hashes, levelNodes[index^1])
+			}
+			parents = append(parents, index/2)
+		}
+		known = parents
+	}
+	return proof, nil
+}
+
+// VerifyMultiProof checks a multiproof generated by a tree built with the
+// default options against root.
+func VerifyMultiProof(root []byte, proof *MultiProof) (bool, error) {
+	return VerifyMultiProofWithOptions(root, proof, TreeOptions{})
+}
+
+// VerifyMultiProofWithOptions checks a multiproof generated by a tree built with
+// opts against root by recomputing the root from the proven leaves and the
+// supplied siblings. Every sibling must be used exactly once.
+func VerifyMultiProofWithOptions(root []byte, proof *MultiProof, opts TreeOptions) (bool, error) {
+	if len(root) == 0 || proof == nil || len(proof.Indices) == 0 {
+		return false, ErrInvalidProofInputs
+	}
+	if len(proof.Leaves) != len(proof.Indices) {
+		return false, ErrInvalidProof
+	}
+
+	known := make([]multiProofNode, len(proof.Indices))
+	for i, index := range proof.Indices {
+		if index < 0 || index >= proof.TreeSize {
+			return false, ErrOutOfBoundary
+		}
+		// Sorted, unique indices keep the sibling order unambiguous
+		if i > 0 && index <= proof.Indices[i-1] {
+			return false, ErrInvalidProof
+		}
+		if len(proof.Leaves[i]) == 0 {
+			return false, ErrInvalidProof
+		}
+		known[i] = multiProofNode{index: index, hash: proof.Leaves[i]}
+	}
+
+	hashes := proof.Hashes
+	for levelSize := proof.TreeSize; levelSize > 1; levelSize = (levelSize + 1) / 2 {
+		parents := make([]multiProofNode, 0, len(known))
+		for i := 0; i < len(known); i++ {
+			node := known[i]
+			var parent []byte
+			switch {
+			case node.index%2 == 0 && i+1 < len(known) && known[i+1].index == node.index+1:
+				parent = opts.hashNode(node.hash, known[i+1].hash)
+				i++
+			case node.index%2 == 0 && node.index == levelSize-1:
+				parent = node.hash
+				if opts.OddNodeStrategy != OddNodePromote {
+					parent = opts.hashNode(node.hash, node.hash)
+				}
+			default:
+				if len(hashes) == 0 || len(hashes[0]) == 0 {
+					return false, ErrInvalidProof
+				}
+				if node.index%2 == 0 {
+					parent = opts.hashNode(node.hash, hashes[0])
+				} else {
+					parent = opts.hashNode(hashes[0], node.hash)
+				}
+				hashes = hashes[1:]
+			}
+			parents = append(parents, multiProofNode{index: node.index / 2, hash: parent})
+		}
+		known = parents
+	}
+
+	if len(hashes) != 0 {
+		// Unused siblings mean the proof was made for a different tree
+		return false, nil
+	}
+	return equalHashes(known[0].hash, root), nil
+}
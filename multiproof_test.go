@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestMultiProof(t *testing.T) {
+	for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote} {
+		opts := TreeOptions{OddNodeStrategy: strategy}
+		for size := 1; size <= 9; size++ {
+			blocks := make([][]byte, size)
+			for i := range blocks {
+				blocks[i] = fmt.Appendf(nil, "block-%d", i)
+			}
+			tree, _ := NewTreeWithOptions(blocks, opts)
+
+			// Every non-empty subset of leaves must verify
+			for mask := 1; mask < 1<<size; mask++ {
+				var indices []int
+				for i := range size {
+					if mask&(1<<i) != 0 {
+						indices = append(indices, i)
+					}
+				}
+				proof, err := tree.GenerateMultiProof(indices)
+				if err != nil {
+					t.Fatalf("%s/%d: GenerateMultiProof(%v) failed: %v", strategy, size, indices, err)
+				}
+				isValid, err := VerifyMultiProofWithOptions(tree.Root, proof, opts)
+				if err != nil || !isValid {
+					t.Errorf("%s/%d: expected %v to verify, got %v, %v", strategy, size, indices, isValid, err)
+				}
+			}
+		}
+	}
+
+	t.Run("DuplicateAndUnsortedIndices", func(t *testing.T) {
+		tree, _ := NewTree(createTestDataBlocks("A", "B", "C", "D", "E"))
+		proof, err := tree.GenerateMultiProof([]int{4, 1, 4, 0, 1})
+		if err != nil {
+			t.Fatalf("GenerateMultiProof failed: %v", err)
+		}
+		if !slices.Equal(proof.Indices, []int{0, 1, 4}) || len(proof.Leaves) != 3 {
+			t.Errorf("Expected indices [0 1 4] with 3 leaves, got %v with %d", proof.Indices, len(proof.Leaves))
+		}
+		if isValid, err := VerifyMultiProof(tree.Root, proof); err != nil || !isValid {
+			t.Errorf("Expected proof to verify, got %v, %v", isValid, err)
+		}
+	})
+
+	t.Run("Rejects", func(t *testing.T) {
+		tree, _ := NewTree(createTestDataBlocks("A", "B", "C", "D", "E", "F", "G"))
+		proof, _ := tree.GenerateMultiProof([]int{1, 5})
+
+		tampered := *proof
+		tampered.Leaves = [][]byte{tree.Leaves[2], tree.Leaves[5]}
+		if isValid, err := VerifyMultiProof(tree.Root, &tampered); err != nil || isValid {
+			t.Errorf("Expected a wrong leaf to be rejected, got %v, %v", isValid, err)
+		}
+		tampered = *proof
+		tampered.Hashes = append(slices.Clone(proof.Hashes), tree.Leaves[0])
+		if isValid, err := VerifyMultiProof(tree.Root, &tampered); err != nil || isValid {
+			t.Errorf("Expected an extra sibling to be rejected, got %v, %v", isValid, err)
+		}
+		tampered = *proof
+		tampered.Hashes = proof.Hashes[1:]
+		if _, err := VerifyMultiProof(tree.Root, &tampered); !errors.Is(err, ErrInvalidProof) {
+			t.Errorf("Expected ErrInvalidProof for a missing sibling, got %v", err)
+		}
+		tampered = *proof
+		tampered.Indices = []int{5, 1}
+		if _, err := VerifyMultiProof(tree.Root, &tampered); !errors.Is(err, ErrInvalidProof) {
+			t.Errorf("Expected ErrInvalidProof for unsorted indices, got %v", err)
+		}
+		if _, err := tree.GenerateMultiProof([]int{0, 7}); !errors.Is(err, ErrOutOfBoundary) {
+			t.Errorf("Expected ErrOutOfBoundary, got %v", err)
+		}
+		if _, err := tree.GenerateMultiProof(nil); !errors.Is(err, ErrInvalidProofInputs) {
+			t.Errorf("Expected ErrInvalidProofInputs, got %v", err)
+		}
+	})
+}
+
+func TestMultiProofSize(t *testing.T) {
+	blocks := make([][]byte, 10000)
+	for i := range blocks {
+		blocks[i] = fmt.Appendf(nil, "file-%d", i)
+	}
+	tree, _ := NewTree(blocks)
+
+	rng := rand.New(rand.NewSource(1))
+	indices := rng.Perm(len(blocks))[:100]
+	proof, err := tree.GenerateMultiProof(indices)
+	if err != nil {
+		t.Fatalf("GenerateMultiProof failed: %v", err)
+	}
+	if isValid, err := VerifyMultiProof(tree.Root, proof); err != nil || !isValid {
+		t.Fatalf("Expected proof to verify, got %v, %v", isValid, err)
+	}
+
+	singleTotal := 0
+	for _, index := range indices {
+		path, _, _ := tree.GenerateProof(index)
+		singleTotal += len(path)
+	}
+	if len(proof.Hashes) >= singleTotal {
+		t.Errorf("Expected the multiproof to be smaller than %d single-proof hashes, got %d", singleTotal, len(proof.Hashes))
+	}
+	t.Logf("100 of 10000 leaves: %d multiproof hashes vs %d for single proofs", len(proof.Hashes), singleTotal)
+}
@@ -0,0 +1,64 @@
+package main
+
+import "runtime"
+
+// Option tunes functions that accept optional settings, such as Scrub,
+// NewTreeOpts and ComputeRoot
+type Option func(*config)
+
+// config holds the settings assembled from Options
+type config struct {
+	concurrency int
+	bufferSize  int
+	treeOptions TreeOptions
+	preHashed   bool
+}
+
+// defaultBufferSize is the read buffer used per hashing worker when unset
+const defaultBufferSize = 256 * 1024
+
+// WithConcurrency sets how many files are hashed in parallel.
+// Zero or less means runtime.NumCPU().
+func WithConcurrency(workers int) Option {
+	return func(c *config) {
+		c.concurrency = workers
+	}
+}
+
+// WithBufferSize sets the read buffer size used by each hashing worker.
+// Zero or less means defaultBufferSize.
+func WithBufferSize(size int) Option {
+	return func(c *config) {
+		c.bufferSize = size
+	}
+}
+
+// WithTreeOptions sets the construction options of the tree being computed.
+func WithTreeOptions(opts TreeOptions) Option {
+	return func(c *config) {
+		c.treeOptions = opts
+	}
+}
+
+// WithPreHashed makes NewTreeOpts and ComputeRoot take its data blocks as leaf hashes that
+// were already computed, instead of hashing them again.
+func WithPreHashed() Option {
+	return func(c *config) {
+		c.preHashed = true
+	}
+}
+
+// newConfig applies opts over the defaults
+func newConfig(opts []Option) *config {
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.concurrency <= 0 {
+		c.concurrency = runtime.NumCPU()
+	}
+	if c.bufferSize <= 0 {
+		c.bufferSize = defaultBufferSize
+	}
+	return c
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// copyOwnership does nothing on platforms without Unix owners
+func copyOwnership(src, dst string, mode os.FileMode) error {
+	return nil
+}
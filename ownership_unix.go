@@ -0,0 +1,32 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyOwnership gives dst the owner and group of src, then reapplies mode
+// (the source's when zero), since changing the owner clears the setuid and
+// setgid bits. Links keep the mode they were created with.
+func copyOwnership(src, dst string, mode os.FileMode) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if err := os.Lchown(dst, int(stat.Uid), int(stat.Gid)); err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	if mode == 0 {
+		mode = info.Mode()
+	}
+	return os.Chmod(dst, mode)
+}
@@ -0,0 +1,50 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestSyncPreserveOwnership(t *testing.T) {
+	src := createTestDir(t, map[string]string{"tool": "#!/bin/sh\n"})
+	srcPath := filepath.Join(src, "tool")
+	// Change the owner first, since that clears the setuid and setgid bits
+	privileged := os.Geteuid() == 0
+	if privileged {
+		if err := os.Lchown(srcPath, 1234, 5678); err != nil {
+			t.Fatalf("Lchown failed: %v", err)
+		}
+	}
+	special := os.FileMode(0755) | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	if err := os.Chmod(srcPath, special); err != nil {
+		t.Skipf("Cannot set special mode bits: %v", err)
+	}
+	if info, _ := os.Stat(srcPath); info.Mode() != special {
+		t.Skipf("Filesystem does not keep special mode bits, got %v", info.Mode())
+	}
+
+	dst := t.TempDir()
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, PreserveOwnership: true}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dst, "tool"))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode() != special {
+		t.Errorf("Expected mode %v to survive the copy, got %v", special, info.Mode())
+	}
+	if !privileged {
+		t.Skip("Changing owners requires privileges")
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	if stat.Uid != 1234 || stat.Gid != 5678 {
+		t.Errorf("Expected owner 1234:5678, got %d:%d", stat.Uid, stat.Gid)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrInvalidPatch = errors.New("directorySync: invalid patch archive")
+)
+
+// patchManifestName is the first entry of every patch archive
+const patchManifestName = "manifest.json"
+
+// patchFilePrefix is prepended to the archive names of changed file contents
+const patchFilePrefix = "files/"
+
+// patchManifest describes every operation a patch performs, in application order:
+// directories are created, moves are renamed, files are written, then deletes run.
+type patchManifest struct {
+	Directories []patchEntry `json:"directories"`
+	Moves       []patchMove  `json:"moves"`
+	Files       []patchEntry `json:"files"`
+	Deletes     []string     `json:"deletes"`
+}
+
+type patchEntry struct {
+	Path string      `json:"path"`
+	Mode os.FileMode `json:"mode"`
+}
+
+// patchMove reuses a file the destination already has under another path
+type patchMove struct {
+	From string      `json:"from"`
+	To   string      `json:"to"`
+	Mode os.FileMode `json:"mode"`
+}
+
+// ExportPatch writes a tar archive that turns DestinationDir into a copy of SourceDir.
+// It holds a manifest plus the contents of new or modified files, and symlinks as
+// link entries with their target. A new file whose content matches a file that
+// would otherwise be deleted is recorded as a move, so its bytes are not shipped.
+// The archive can be applied offline with ApplyPatch.
+func (ds *DirectorySync) ExportPatch(w io.Writer) error {
+	sourceFiles, err := ds.scanSource(context.Background())
+	if err != nil {
+		return err
+	}
+	destFiles, err := ds.buildDirectoryTree(context.Background(), ds.destRoot())
+	if err != nil {
+		return fmt.Errorf("error scanning destination directory: %v", err)
+	}
+	filesToCopy, filesToDelete, err := ds.CompareTrees(sourceFiles, destFiles)
+	if err != nil {
+		return fmt.Errorf("error comparing trees: %v", err)
+	}
+
+	// Deleted regular files are candidates for moves, keyed by content hash
+	destByPath := make(map[string]FileInfo, len(destFiles))
+	for _, file := range destFiles {
+		destByPath[file.Path] = file
+	}
+	movable := make(map[string]string)
+	for _, path := range filesToDelete {
+		if file := destByPath[path]; !file.IsDir && !isSymlink(file) {
+			movable[string(file.Hash)] = path
+		}
+	}
+
+	var manifest patchManifest
+	moved := make(map[string]bool)
+	for _, file := range filesToCopy {
+		switch {
+		case file.IsDir:
+			manifest.Directories = append(manifest.Directories, patchEntry{Path: file.Path, Mode: file.Mode})
+		case !isSymlink(file) && movable[string(file.Hash)] != "":
+			from := movable[string(file.Hash)]
+			delete(movable, string(file.Hash))
+			moved[from] = true
+			manifest.Moves = append(manifest.Moves, patchMove{From: from, To: file.Path, Mode: file.Mode})
+		default:
+			manifest.Files = append(manifest.Files, patchEntry{Path: file.Path, Mode: file.Mode})
+		}
+	}
+	for _, path := range filesToDelete {
+		if !moved[path] {
+			manifest.Deletes = append(manifest.Deletes, path)
+		}
+	}
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	if err := writeTarEntry(tw, patchManifestName, 0644, int64(len(manifestData)), bytes.NewReader(manifestData)); err != nil {
+		return err
+	}
+	for _, entry := range manifest.Files {
+		add := addFileToTar
+		if entry.Mode&os.ModeSymlink != 0 {
+			add = addLinkToTar
+		}
+		if err := add(tw, patchFilePrefix+entry.Path, ds.sourcePath(entry.Path), entry.Mode); err != nil {
+			return fmt.Errorf("error adding %s to patch: %v", entry.Path, err)
+		}
+	}
+	return tw.Close()
+}
+
+// ApplyPatch applies a patch archive produced by ExportPatch to destDir.
+// Every path in the archive is checked to stay within destDir, and not to lead
+// through a symlink there. Links whose target leaves destDir are refused with
+// ErrUnsafePath.
+func ApplyPatch(destDir string, r io.Reader) (err error) {
+	ds := &DirectorySync{DestinationDir: destDir}
+	defer func() {
+		if modeErr := ds.applyDirModes(); err == nil {
+			err = modeErr
+		}
+	}()
+	tr := tar.NewReader(r)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != patchManifestName {
+		return fmt.Errorf("%w: missing manifest", ErrInvalidPatch)
+	}
+	var manifest patchManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	destPath := func(relPath string) (string, error) {
+		if !filepath.IsLocal(filepath.FromSlash(relPath)) {
+			return "", fmt.Errorf("%w: %s", ErrUnsafePath, relPath)
+		}
+		if err := checkLinkFreeParents(destDir, relPath); err != nil {
+			return "", err
+		}
+		return filepath.Join(destDir, filepath.FromSlash(relPath)), nil
+	}
+
+	for _, entry := range manifest.Directories {
+		path, err := destPath(entry.Path)
+		if err != nil {
+			return err
+		}
+		if err := ds.makeDir(path, entry.Mode); err != nil {
+			return fmt.Errorf("error creating directory %s: %v", entry.Path, err)
+		}
+	}
+
+	for _, move := range manifest.Moves {
+		from, err := destPath(move.From)
+		if err != nil {
+			return err
+		}
+		to, err := destPath(move.To)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(to), ds.dirMode()); err != nil {
+			return fmt.Errorf("error creating directory for %s: %v", move.To, err)
+		}
+		if err := os.Rename(from, to); err != nil {
+			return fmt.Errorf("error moving %s to %s: %v", move.From, move.To, err)
+		}
+		if err := os.Chmod(to, move.Mode); err != nil {
+			return fmt.Errorf("error setting mode on %s: %v", move.To, err)
+		}
+	}
+
+	expectedFiles := make(map[string]os.FileMode, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		expectedFiles[patchFilePrefix+entry.Path] = entry.Mode
+	}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		mode, ok := expectedFiles[header.Name]
+		if !ok {
+			return fmt.Errorf("%w: unexpected entry %s", ErrInvalidPatch, header.Name)
+		}
+		delete(expectedFiles, header.Name)
+
+		path, err := destPath(header.Name[len(patchFilePrefix):])
+		if err != nil {
+			return err
+		}
+		if (header.Typeflag == tar.TypeSymlink) != (mode&os.ModeSymlink != 0) {
+			return fmt.Errorf("%w: %s does not match its manifest type", ErrInvalidPatch, header.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), ds.dirMode()); err != nil {
+			return fmt.Errorf("error creating directory for %s: %v", header.Name, err)
+		}
+		// Neither a link nor a file may be written through a link already there
+		if info, err := os.Lstat(path); err == nil && (info.Mode()&os.ModeSymlink != 0 || header.Typeflag == tar.TypeSymlink) {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("error replacing %s: %v", header.Name, err)
+			}
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			target := filepath.FromSlash(header.Linkname)
+			if !linkStaysInside(destDir, path, target) {
+				return fmt.Errorf("%w: link %s points to %s", ErrUnsafePath, header.Name, header.Linkname)
+			}
+			if err := os.Symlink(target, path); err != nil {
+				return fmt.Errorf("error creating link %s: %v", header.Name, err)
+			}
+			continue
+		}
+		if err := writeFile(path, tr, mode); err != nil {
+			return fmt.Errorf("error writing %s: %v", header.Name, err)
+		}
+	}
+	if len(expectedFiles) != 0 {
+		return fmt.Errorf("%w: %d file(s) missing from archive", ErrInvalidPatch, len(expectedFiles))
+	}
+
+	for _, relPath := range manifest.Deletes {
+		path, err := destPath(relPath)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("error deleting %s: %v", relPath, err)
+		}
+	}
+	return nil
+}
+
+// addFileToTar streams a file from disk into the archive under name
+func addFileToTar(tw *tar.Writer, name, path string, mode os.FileMode) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return writeTarEntry(tw, name, mode, info.Size(), file)
+}
+
+// addLinkToTar records the symlink at path in the archive under name, with its
+// target instead of the contents it points to
+func addLinkToTar(tw *tar.Writer, name, path string, mode os.FileMode) error {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return err
+	}
+	return tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     int64(mode.Perm()),
+		Linkname: filepath.ToSlash(target),
+		Typeflag: tar.TypeSymlink,
+	})
+}
+
+// writeTarEntry writes a regular file entry with the given contents
+func writeTarEntry(tw *tar.Writer, name string, mode os.FileMode, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Name:     name,
+		Mode:     int64(mode.Perm()),
+		Size:     size,
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// writeFile writes the contents of r to dst with the given mode
+func writeFile(dst string, r io.Reader, mode os.FileMode) error {
+	destFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	if _, err := io.Copy(destFile, r); err != nil {
+		return err
+	}
+	return os.Chmod(dst, mode)
+}
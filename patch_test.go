@@ -0,0 +1,72 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestExportAndApplyPatch(t *testing.T) {
+	src := createTestDir(t, map[string]string{
+		"same.txt":         "unchanged",
+		"modified.txt":     "new content",
+		"added/file.txt":   "brand new",
+		"renamed/moved.md": "moved content",
+	})
+	dst := createTestDir(t, map[string]string{
+		"same.txt":     "unchanged",
+		"modified.txt": "old content",
+		"old/moved.md": "moved content",
+		"stale.txt":    "to be deleted",
+	})
+
+	var patch bytes.Buffer
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst}
+	if err := syncer.ExportPatch(&patch); err != nil {
+		t.Fatalf("ExportPatch failed: %v", err)
+	}
+
+	// The moved file should not ship its content
+	tr := tar.NewReader(bytes.NewReader(patch.Bytes()))
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, header.Name)
+	}
+	expectedNames := []string{"manifest.json", "files/added/file.txt", "files/modified.txt"}
+	if !slices.Equal(names, expectedNames) {
+		t.Errorf("Expected archive entries %v, got %v", expectedNames, names)
+	}
+
+	if err := ApplyPatch(dst, &patch); err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	if !bytes.Equal(dirRoot(t, src), dirRoot(t, dst)) {
+		t.Errorf("Destination root differs from source root after applying patch")
+	}
+}
+
+func TestApplyPatchRejectsUnsafePaths(t *testing.T) {
+	var patch bytes.Buffer
+	tw := tar.NewWriter(&patch)
+	manifest := []byte(`{"deletes":["../outside.txt"]}`)
+	if err := writeTarEntry(tw, patchManifestName, 0644, int64(len(manifest)), bytes.NewReader(manifest)); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	tw.Close()
+
+	if err := ApplyPatch(t.TempDir(), &patch); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Expected ErrUnsafePath, got %v", err)
+	}
+}
+
+func TestApplyPatchRejectsMissingManifest(t *testing.T) {
+	if err := ApplyPatch(t.TempDir(), bytes.NewReader(nil)); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("Expected ErrInvalidPatch, got %v", err)
+	}
+}
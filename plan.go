@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// PlanAction is a single operation a sync would perform
+type PlanAction struct {
+	Action string `json:"action"` // "mkdir", "copy" or "delete"
+	Path   string `json:"path"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// Plan computes the actions a sync would perform, in execution order,
+// without modifying the destination.
+func (ds *DirectorySync) Plan() ([]PlanAction, error) {
+	actions := []PlanAction{}
+	err := ds.walkPlan(func(action PlanAction) error {
+		actions = append(actions, action)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+// StreamPlanJSONL writes the sync plan as JSON Lines: one PlanAction object per
+// line, e.g. {"action":"copy","path":"a.txt","size":123}. Unlike a single JSON
+// document, the output can be consumed line by line by jq or a queue. Each
+// action is written as soon as it is produced.
+func (ds *DirectorySync) StreamPlanJSONL(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return ds.walkPlan(func(action PlanAction) error {
+		return encoder.Encode(action)
+	})
+}
+
+// walkPlan scans and compares both directories, then calls fn with each
+// action in the order Plan returns them, stopping at the first error.
+func (ds *DirectorySync) walkPlan(fn func(action PlanAction) error) error {
+	sourceFiles, err := ds.scanSource(context.Background())
+	if err != nil {
+		return err
+	}
+	destFiles, err := ds.buildDirectoryTree(context.Background(), ds.destRoot())
+	if err != nil {
+		return fmt.Errorf("error scanning destination directory: %v", err)
+	}
+	filesToCopy, filesToDelete, err := ds.CompareTrees(sourceFiles, destFiles)
+	if err != nil {
+		return fmt.Errorf("error comparing trees: %v", err)
+	}
+	filesToDelete = ds.protectFromDeletion(filesToDelete)
+	ds.Deferred = nil
+	filesToCopy = ds.deferUnsettled(filesToCopy)
+
+	for _, file := range filesToCopy {
+		if file.IsDir {
+			if err := fn(PlanAction{Action: "mkdir", Path: file.Path}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, file := range filesToCopy {
+		if !file.IsDir {
+			if err := fn(PlanAction{Action: "copy", Path: file.Path, Size: file.Size}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, path := range filesToDelete {
+		if err := fn(PlanAction{Action: "delete", Path: path}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestStreamPlanJSONL(t *testing.T) {
+	src := createTestDir(t, map[string]string{"same.txt": "S", "new/file.txt": "12345", "changed.txt": "v2"})
+	dst := createTestDir(t, map[string]string{"same.txt": "S", "changed.txt": "v1", "stale.txt": "X"})
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst}
+
+	var buf bytes.Buffer
+	if err := syncer.StreamPlanJSONL(&buf); err != nil {
+		t.Fatalf("StreamPlanJSONL failed: %v", err)
+	}
+
+	var streamed []PlanAction
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var action PlanAction
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+			t.Fatalf("Line %q is not a JSON object: %v", scanner.Text(), err)
+		}
+		streamed = append(streamed, action)
+	}
+
+	expected := []PlanAction{
+		{Action: "mkdir", Path: "new"},
+		{Action: "copy", Path: "changed.txt", Size: 2},
+		{Action: "copy", Path: "new/file.txt", Size: 5},
+		{Action: "delete", Path: "stale.txt"},
+	}
+	if !slices.Equal(streamed, expected) {
+		t.Errorf("Expected %v, got %v", expected, streamed)
+	}
+
+	plan, err := syncer.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if !slices.Equal(streamed, plan) {
+		t.Errorf("Streamed actions %v differ from computed plan %v", streamed, plan)
+	}
+}
+
+// writeCounter counts writes and fails every one of them
+type writeCounter struct{ writes int }
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("pipe closed")
+}
+
+func TestStreamPlanJSONLStopsOnWriteError(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A", "b.txt": "B", "c.txt": "C"})
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: t.TempDir()}
+
+	w := &writeCounter{}
+	if err := syncer.StreamPlanJSONL(w); err == nil {
+		t.Fatalf("Expected the write error to be returned")
+	}
+	if w.writes != 1 {
+		t.Errorf("Expected streaming to stop after the first failed write, got %d writes", w.writes)
+	}
+}
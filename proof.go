@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Proof is an inclusion proof for a single leaf. TreeSize is needed to verify
+// proofs of trees built with OddNodePromote.
+type Proof struct {
+	Path      [][]byte
+	LeafHash  []byte
+	LeafIndex int
+	TreeSize  int
+}
+
+// proofJSON is the wire form of a Proof, with hashes hex-encoded
+type proofJSON struct {
+	LeafIndex int      `json:"leafIndex"`
+	TreeSize  int      `json:"treeSize"`
+	LeafHash  string   `json:"leafHash"`
+	Path      []string `json:"path"`
+}
+
+// GenerateProofStruct returns the proof for the leaf at index as a Proof,
+// ready to be sent to a verifier as JSON.
+func (t *MerkleTree) GenerateProofStruct(index int) (*Proof, error) {
+	path, leafHash, err := t.GenerateProof(index)
+	if err != nil {
+		return nil, err
+	}
+	return &Proof{Path: path, LeafHash: leafHash, LeafIndex: index, TreeSize: len(t.Leaves)}, nil
+}
+
+// VerifyProofStruct checks p against root like VerifyProofWithSize, so a
+// proof claiming a different TreeSize than its path fits is rejected with
+// ErrProofLengthMismatch. A zero TreeSize skips that check.
+func VerifyProofStruct(root []byte, p *Proof) (bool, error) {
+	if p == nil {
+		return false, ErrInvalidProofInputs
+	}
+	return VerifyProofWithOptions(root, p.Path, p.LeafHash, p.LeafIndex, p.TreeSize, TreeOptions{})
+}
+
+// MarshalJSON encodes the proof with hex-encoded hashes, e.g.
+// {"leafIndex":2,"treeSize":5,"leafHash":"ab12...","path":["cd34...", ...]}.
+func (p Proof) MarshalJSON() ([]byte, error) {
+	wire := proofJSON{
+		LeafIndex: p.LeafIndex,
+		TreeSize:  p.TreeSize,
+		LeafHash:  hex.EncodeToString(p.LeafHash),
+		Path:      make([]string, len(p.Path)),
+	}
+	for i, sibling := range p.Path {
+		wire.Path[i] = hex.EncodeToString(sibling)
+	}
+	return json.Marshal(wire)
+}
+
+// UnmarshalJSON decodes a proof written by MarshalJSON, returning
+// ErrInvalidHex if a hash is not valid hex.
+func (p *Proof) UnmarshalJSON(data []byte) error {
+	var wire proofJSON
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	leafHash, err := decodeHexHash("leafHash", wire.LeafHash, -1)
+	if err != nil {
+		return err
+	}
+	path := make([][]byte, len(wire.Path))
+	for i, sibling := range wire.Path {
+		if path[i], err = decodeHexHash(fmt.Sprintf("path[%d]", i), sibling, len(leafHash)); err != nil {
+			return err
+		}
+	}
+	*p = Proof{Path: path, LeafHash: leafHash, LeafIndex: wire.LeafIndex, TreeSize: wire.TreeSize}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+// ProofCursor yields the proofs of a tree's leaves one index at a time, so
+// proofs for millions of leaves can be exported without holding them all in
+// memory. Recording the last index written and calling Seek on a fresh cursor
+// resumes an interrupted export. A cursor is not safe for concurrent use.
+type ProofCursor struct {
+	tree *MerkleTree
+	next int
+}
+
+// NewProofCursor returns a cursor positioned at the first leaf.
+func (t *MerkleTree) NewProofCursor() *ProofCursor {
+	return &ProofCursor{tree: t}
+}
+
+// Next returns the proof for the current index and advances the cursor.
+// ok is false once every leaf has been visited (or for a nil tree).
+func (c *ProofCursor) Next() (index int, proof [][]byte, ok bool) {
+	if c.tree == nil || c.next < 0 || c.next >= len(c.tree.Leaves) {
+		return c.next, nil, false
+	}
+	index = c.next
+	proof, _, err := c.tree.GenerateProof(index)
+	if err != nil {
+		return index, nil, false
+	}
+	c.next++
+	return index, proof, true
+}
+
+// Seek positions the cursor so the next call to Next yields index.
+// Seeking outside the tree makes Next report that the cursor is exhausted.
+func (c *ProofCursor) Seek(index int) {
+	c.next = index
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestProofCursor(t *testing.T) {
+	tree, _ := NewTree(createTestDataBlocks("A", "B", "C", "D", "E", "F", "G"))
+	cursor := tree.NewProofCursor()
+
+	// Export the first half, then resume from a checkpoint on a fresh cursor
+	for range 3 {
+		cursor.Next()
+	}
+	checkpoint := 3
+
+	resumed := tree.NewProofCursor()
+	resumed.Seek(checkpoint)
+	want := checkpoint
+	for {
+		index, proof, ok := resumed.Next()
+		if !ok {
+			break
+		}
+		if index != want {
+			t.Fatalf("Expected index %d, got %d", want, index)
+		}
+		expected, _, _ := tree.GenerateProof(index)
+		if !slices.EqualFunc(proof, expected, bytes.Equal) {
+			t.Errorf("Proof for %d differs from GenerateProof", index)
+		}
+		want++
+	}
+	if want != len(tree.Leaves) {
+		t.Errorf("Expected cursor to stop after %d leaves, stopped at %d", len(tree.Leaves), want)
+	}
+
+	resumed.Seek(-1)
+	if _, _, ok := resumed.Next(); ok {
+		t.Errorf("Expected seeking before the first leaf to exhaust the cursor")
+	}
+	var nilTree *MerkleTree
+	if _, _, ok := nilTree.NewProofCursor().Next(); ok {
+		t.Errorf("Expected a nil tree's cursor to be exhausted")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestProofJSONRoundTrip(t *testing.T) {
+	tree, _ := NewTree(createTestDataBlocks("A", "B", "C", "D", "E"))
+	for index := range tree.Leaves {
+		proof, err := tree.GenerateProofStruct(index)
+		if err != nil {
+			t.Fatalf("GenerateProofStruct(%d) failed: %v", index, err)
+		}
+		data, err := json.Marshal(proof)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+
+		var received Proof
+		if err := json.Unmarshal(data, &received); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		isValid, err := VerifyProofStruct(tree.Root, &received)
+		if err != nil || !isValid {
+			t.Errorf("Expected proof %d to verify after a JSON round-trip, got %v, %v", index, isValid, err)
+		}
+		if received.TreeSize != len(tree.Leaves) || received.LeafIndex != index {
+			t.Errorf("Expected index %d of %d, got %d of %d", index, len(tree.Leaves), received.LeafIndex, received.TreeSize)
+		}
+	}
+
+	var proof Proof
+	err := json.Unmarshal([]byte(`{"leafIndex":0,"treeSize":1,"leafHash":"zz","path":[]}`), &proof)
+	if !errors.Is(err, ErrInvalidHex) {
+		t.Errorf("Expected ErrInvalidHex, got %v", err)
+	}
+	if _, err := VerifyProofStruct(tree.Root, nil); !errors.Is(err, ErrInvalidProofInputs) {
+		t.Errorf("Expected ErrInvalidProofInputs, got %v", err)
+	}
+
+	// The claimed tree size must fit the path
+	mismatched, _ := tree.GenerateProofStruct(0)
+	mismatched.TreeSize = 2
+	if _, err := VerifyProofStruct(tree.Root, mismatched); !errors.Is(err, ErrProofLengthMismatch) {
+		t.Errorf("Expected ErrProofLengthMismatch for a wrong tree size, got %v", err)
+	}
+	mismatched, _ = tree.GenerateProofStruct(4)
+	mismatched.TreeSize = 4
+	if _, err := VerifyProofStruct(tree.Root, mismatched); !errors.Is(err, ErrOutOfBoundary) {
+		t.Errorf("Expected ErrOutOfBoundary for an index past the tree size, got %v", err)
+	}
+}
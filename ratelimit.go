@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// maxRateLimitedRead caps a single rate limited read, so concurrent copies
+// take turns at a fine granularity
+const maxRateLimitedRead = 32 * 1024
+
+// rateLimiter is a token bucket shared by every copy of a sync. Tokens are
+// bytes, added at the configured rate; a read that takes more tokens than
+// are available waits until they would have been added. The bucket holds no
+// burst, so transfers never get ahead of the rate, even after idling.
+type rateLimiter struct {
+	bytesPerSec float64
+	mu          sync.Mutex
+	tokens      float64
+	last        time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{bytesPerSec: float64(bytesPerSec), last: time.Now()}
+}
+
+// wait takes n tokens, sleeping for as long as the bucket is in debt
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.bytesPerSec, 0)
+	l.last = now
+	l.tokens -= float64(n)
+	debt := -l.tokens
+	l.mu.Unlock()
+
+	if debt > 0 {
+		time.Sleep(time.Duration(debt / l.bytesPerSec * float64(time.Second)))
+	}
+}
+
+// rateLimitedReader reads through a shared rateLimiter
+type rateLimitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	chunk := max(1, min(maxRateLimitedRead, int(r.limiter.bytesPerSec/10)))
+	if len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.r.Read(p)
+	r.limiter.wait(n)
+	return n, err
+}
+
+// limitReader returns r limited to RateLimitBytesPerSec, shared with every
+// other copy of the running sync
+func (ds *DirectorySync) limitReader(r io.Reader) io.Reader {
+	if ds.limiter == nil {
+		return r
+	}
+	return &rateLimitedReader{r: r, limiter: ds.limiter}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncRateLimit(t *testing.T) {
+	const limit = 40 * 1024
+	testCases := []struct {
+		name  string
+		files map[string]string
+		batch bool
+	}{
+		{"SingleFile", map[string]string{"a.bin": strings.Repeat("a", 10*1024)}, false},
+		// Concurrent copies share the limit
+		{"ConcurrentFiles", map[string]string{"a.bin": strings.Repeat("a", 6*1024), "b.bin": strings.Repeat("b", 6*1024)}, false},
+		{"Batched", map[string]string{"a.bin": strings.Repeat("a", 5*1024), "b.bin": strings.Repeat("b", 5*1024)}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src := createTestDir(t, tc.files)
+			dst := t.TempDir()
+			total := 0
+			for _, content := range tc.files {
+				total += len(content)
+			}
+
+			syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, RateLimitBytesPerSec: limit, BatchSmallFiles: tc.batch}
+			start := time.Now()
+			if err := syncer.SyncDirectories(); err != nil {
+				t.Fatalf("SyncDirectories failed: %v", err)
+			}
+			elapsed := time.Since(start)
+
+			minimum := time.Duration(float64(total) / limit * float64(time.Second))
+			if elapsed < minimum*95/100 {
+				t.Errorf("Expected copying %d bytes at %d B/s to take at least %v, took %v", total, limit, minimum, elapsed)
+			}
+			if !bytes.Equal(dirRoot(t, dst), dirRoot(t, src)) {
+				t.Error("Expected the destination to match the source")
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// Operations of the remote sync protocol. Each request is answered by one
+// response, except remoteOpFile, which streams the contents in chunks.
+const (
+	remoteOpRoot  = "root"  // Root of the served directory's tree
+	remoteOpLevel = "level" // Node hashes of one tree level, 0 being the leaves
+	remoteOpList  = "list"  // Every entry, as scanned by BuildDirectoryTree
+	remoteOpFile  = "file"  // Contents of a listed file, or the target of a listed link
+)
+
+// remoteChunkSize is the size of the content chunks a file is streamed in
+const remoteChunkSize = 64 * 1024
+
+// remoteRequest is sent by the client, gob-encoded
+type remoteRequest struct {
+	Op    string
+	Level int
+	Path  string
+}
+
+// remoteResponse is sent by the server, gob-encoded
+type remoteResponse struct {
+	Error  string
+	Root   []byte
+	Nodes  [][]byte
+	Files  []FileInfo
+	Data   []byte // Next chunk of a file's contents
+	Target string // Target of a link
+	Done   bool   // Last response for a file
+}
+
+// remoteRequestHook, when set by tests, is called with the op of every request served
+var remoteRequestHook func(op string)
+
+// ServeDirectory answers remote sync requests for dir on every connection
+// accepted from ln, until accepting fails (e.g. because ln was closed), and
+// returns that error. Each connection sees the directory as scanned when
+// it was accepted. Only listed entries can be read, so clients never reach
+// files outside dir.
+func ServeDirectory(dir string, ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			serveRemote(dir, conn)
+		}()
+	}
+}
+
+// serveRemote answers the requests of a single connection until it is closed
+func serveRemote(dir string, conn net.Conn) {
+	dec := gob.NewDecoder(conn)
+	enc := gob.NewEncoder(conn)
+
+	ds := &DirectorySync{SourceDir: dir}
+	files, scanErr := ds.BuildDirectoryTree(dir)
+	var tree *MerkleTree
+	if scanErr == nil && len(files) > 0 {
+		tree, scanErr = ds.BuildMerkleTree(files)
+	}
+	listed := make(map[string]FileInfo, len(files))
+	for _, file := range files {
+		listed[file.Path] = file
+	}
+
+	for {
+		var req remoteRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if remoteRequestHook != nil {
+			remoteRequestHook(req.Op)
+		}
+		if scanErr != nil {
+			enc.Encode(remoteResponse{Error: fmt.Sprintf("error scanning directory: %v", scanErr)})
+			continue
+		}
+
+		var resp remoteResponse
+		switch req.Op {
+		case remoteOpRoot:
+			resp.Root = tree.GetRoot()
+		case remoteOpLevel:
+			nodes, err := tree.LevelNodes(req.Level)
+			if err != nil {
+				resp.Error = err.Error()
+			}
+			resp.Nodes = nodes
+		case remoteOpList:
+			resp.Files = files
+		case remoteOpFile:
+			file, ok := listed[req.Path]
+			if !ok || file.IsDir {
+				resp.Error = fmt.Sprintf("no such file: %s", req.Path)
+				break
+			}
+			if err := sendRemoteFile(enc, filepath.Join(dir, filepath.FromSlash(file.Path)), file); err != nil {
+				return
+			}
+			continue
+		default:
+			resp.Error = fmt.Sprintf("unknown operation: %s", req.Op)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// sendRemoteFile streams a listed file's contents, or a link's target, ending
+// with a Done response. Only errors writing to the connection are returned;
+// read errors are reported to the client.
+func sendRemoteFile(enc *gob.Encoder, path string, file FileInfo) error {
+	if isSymlink(file) {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return enc.Encode(remoteResponse{Error: err.Error()})
+		}
+		return enc.Encode(remoteResponse{Target: target, Done: true})
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return enc.Encode(remoteResponse{Error: err.Error()})
+	}
+	defer f.Close()
+	buf := make([]byte, remoteChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := enc.Encode(remoteResponse{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return enc.Encode(remoteResponse{Done: true})
+		}
+		if err != nil {
+			return enc.Encode(remoteResponse{Error: err.Error()})
+		}
+	}
+}
+
+// remoteClient sends requests over a connection served by ServeDirectory
+type remoteClient struct {
+	enc     *gob.Encoder
+	dec     *gob.Decoder
+	pending []byte // Rest of the chunk being read by Read
+}
+
+// call sends a request and returns its response, turning a reported error
+// into an error
+func (c *remoteClient) call(req remoteRequest) (*remoteResponse, error) {
+	if err := c.enc.Encode(req); err != nil {
+		return nil, fmt.Errorf("error sending %s request: %v", req.Op, err)
+	}
+	return c.receive()
+}
+
+// receive reads the next response
+func (c *remoteClient) receive() (*remoteResponse, error) {
+	var resp remoteResponse
+	if err := c.dec.Decode(&resp); err != nil {
+		return nil, fmt.Errorf("error reading response: %v", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("remote error: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// Read implements io.Reader over the chunks of the file being received,
+// returning io.EOF after its Done response
+func (c *remoteClient) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		resp, err := c.receive()
+		if err != nil {
+			return 0, err
+		}
+		if resp.Done {
+			return 0, io.EOF
+		}
+		c.pending = resp.Data
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// SyncFromRemote makes localDir a mirror of the directory served by
+// ServeDirectory on the other end of conn. The roots are compared first and
+// nothing else is requested when they are equal. Otherwise the remote listing
+// is checked against the remote root and compared with localDir, and only
+// added or modified files are transferred, each verified against its listed
+// hash, before local extras are deleted. A local entry the remote replaces
+// with one of another type, e.g. a directory that became a file, is removed
+// first. Links whose target leaves localDir,
+// and entries listed below a link, are refused with ErrUnsafePath.
+func SyncFromRemote(localDir string, conn net.Conn) (err error) {
+	client := &remoteClient{enc: gob.NewEncoder(conn), dec: gob.NewDecoder(conn)}
+	ds := &DirectorySync{DestinationDir: localDir}
+	defer func() {
+		if modeErr := ds.applyDirModes(); err == nil {
+			err = modeErr
+		}
+	}()
+
+	resp, err := client.call(remoteRequest{Op: remoteOpRoot})
+	if err != nil {
+		return err
+	}
+	remoteRoot := resp.Root
+
+	localFiles, err := ds.BuildDirectoryTree(localDir)
+	if err != nil {
+		return fmt.Errorf("error scanning local directory: %v", err)
+	}
+	var localRoot []byte
+	if len(localFiles) > 0 {
+		localTree, err := ds.BuildMerkleTree(localFiles)
+		if err != nil {
+			return fmt.Errorf("error building local tree: %v", err)
+		}
+		localRoot = localTree.Root
+	}
+	if bytes.Equal(localRoot, remoteRoot) {
+		ds.logf("Directories are already in sync.\n")
+		return nil
+	}
+
+	resp, err = client.call(remoteRequest{Op: remoteOpList})
+	if err != nil {
+		return err
+	}
+	remoteFiles := resp.Files
+	var listedRoot []byte
+	if len(remoteFiles) > 0 {
+		listedTree, err := ds.BuildMerkleTree(remoteFiles)
+		if err != nil {
+			return fmt.Errorf("error building remote tree: %v", err)
+		}
+		listedRoot = listedTree.Root
+	}
+	if !bytes.Equal(listedRoot, remoteRoot) {
+		return ErrRemoteListing
+	}
+	for _, file := range remoteFiles {
+		if !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+			return fmt.Errorf("%w: %s", ErrUnsafePath, file.Path)
+		}
+	}
+
+	filesToCopy, filesToDelete, err := ds.CompareTrees(remoteFiles, localFiles)
+	if err != nil {
+		return fmt.Errorf("error comparing trees: %v", err)
+	}
+	filesToDelete, err = ds.removeReplaced(filesToCopy, localFiles, filesToDelete)
+	if err != nil {
+		return err
+	}
+	for _, file := range filesToCopy {
+		destPath := filepath.Join(localDir, filepath.FromSlash(file.Path))
+		if err := checkLinkFreeParents(localDir, file.Path); err != nil {
+			return err
+		}
+		if file.IsDir {
+			ds.logf("Creating directory: %s\n", file.Path)
+			if err := ds.makeDir(destPath, file.Mode); err != nil {
+				return fmt.Errorf("error creating directory %s: %v", file.Path, err)
+			}
+			continue
+		}
+		ds.logf("Fetching file: %s\n", file.Path)
+		if err := ds.fetchRemote(client, file, destPath); err != nil {
+			return fmt.Errorf("error fetching %s: %v", file.Path, err)
+		}
+	}
+
+	for _, path := range filesToDelete {
+		ds.logf("Deleting: %s\n", path)
+		if err := os.RemoveAll(filepath.Join(localDir, path)); err != nil {
+			return fmt.Errorf("error deleting %s: %v", path, err)
+		}
+	}
+	ds.logf("Sync complete!\n")
+	return nil
+}
+
+// fetchRemote requests a listed file and writes it to destPath, replacing it
+// only once the received contents match the listed hash
+func (ds *DirectorySync) fetchRemote(c *remoteClient, file FileInfo, destPath string) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), ds.dirMode()); err != nil {
+		return err
+	}
+	if err := c.enc.Encode(remoteRequest{Op: remoteOpFile, Path: file.Path}); err != nil {
+		return err
+	}
+
+	if isSymlink(file) {
+		resp, err := c.receive()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(hashLinkTarget(resp.Target), file.Hash) {
+			return ErrCopyVerification
+		}
+		if !linkStaysInside(ds.DestinationDir, destPath, resp.Target) {
+			return fmt.Errorf("%w: link %s points to %s", ErrUnsafePath, file.Path, resp.Target)
+		}
+		if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Symlink(resp.Target, destPath)
+	}
+
+	err := writeAtomically(destPath, func(tmpPath string) error {
+		h := sha256.New()
+		if err := writeFile(tmpPath, io.TeeReader(c, h), file.Mode); err != nil {
+			return err
+		}
+		if !bytes.Equal(h.Sum(nil), file.Hash) {
+			return ErrCopyVerification
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return preserveModTime(destPath, file)
+}
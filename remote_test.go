@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipeListener hands out the server ends of in-memory pipes created by dial
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+// dial returns the client end of a new connection
+func (l *pipeListener) dial() net.Conn {
+	client, server := net.Pipe()
+	l.conns <- server
+	return client
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestSyncFromRemote(t *testing.T) {
+	big := make([]byte, 3*remoteChunkSize+123)
+	rand.New(rand.NewSource(1)).Read(big)
+	src := createTestDir(t, map[string]string{
+		"a.txt":     "A",
+		"same.txt":  "S",
+		"sub/b.txt": "new B",
+		"big.bin":   string(big),
+	})
+	dst := createTestDir(t, map[string]string{
+		"same.txt":  "S",
+		"sub/b.txt": "old B",
+		"extra.txt": "X",
+	})
+	untouched := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(filepath.Join(dst, "same.txt"), untouched, untouched); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var ops []string
+	remoteRequestHook = func(op string) {
+		mu.Lock()
+		ops = append(ops, op)
+		mu.Unlock()
+	}
+	defer func() { remoteRequestHook = nil }()
+
+	ln := newPipeListener()
+	served := make(chan error, 1)
+	go func() { served <- ServeDirectory(src, ln) }()
+	defer func() {
+		ln.Close()
+		if err := <-served; err != net.ErrClosed {
+			t.Errorf("Expected ServeDirectory to stop with net.ErrClosed, got %v", err)
+		}
+	}()
+
+	runSync := func() []string {
+		t.Helper()
+		conn := ln.dial()
+		defer conn.Close()
+		mu.Lock()
+		ops = nil
+		mu.Unlock()
+		if err := SyncFromRemote(dst, conn); err != nil {
+			t.Fatalf("SyncFromRemote failed: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(ops)
+	}
+
+	got := runSync()
+	if want := []string{"root", "list", "file", "file", "file"}; !slices.Equal(got, want) {
+		t.Errorf("Expected only the differing files to be requested %v, got %v", want, got)
+	}
+	if !bytes.Equal(dirRoot(t, dst), dirRoot(t, src)) {
+		t.Error("Expected the local directory to mirror the remote one")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "extra.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected extra.txt to be deleted, got %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "same.txt")); err != nil || !info.ModTime().Equal(untouched) {
+		t.Errorf("Expected the unchanged file to be left alone, got %v", err)
+	}
+
+	if got := runSync(); !slices.Equal(got, []string{"root"}) {
+		t.Errorf("Expected an in-sync directory to stop after the root, got %v", got)
+	}
+}
+
+func TestServeDirectoryRequests(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A", "b.txt": "B", "c.txt": "C"})
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		serveRemote(src, server)
+	}()
+	c := &remoteClient{enc: gob.NewEncoder(client), dec: gob.NewDecoder(client)}
+
+	resp, err := c.call(remoteRequest{Op: remoteOpLevel, Level: 0})
+	if err != nil {
+		t.Fatalf("Level request failed: %v", err)
+	}
+	ds := &DirectorySync{}
+	files, _ := ds.BuildDirectoryTree(src)
+	tree, _ := ds.BuildMerkleTree(files)
+	if !slices.EqualFunc(resp.Nodes, tree.Leaves, bytes.Equal) {
+		t.Errorf("Expected level 0 to hold the leaves")
+	}
+
+	for _, req := range []remoteRequest{
+		{Op: remoteOpLevel, Level: tree.Height()},
+		{Op: remoteOpFile, Path: "../secret.txt"},
+		{Op: "unknown"},
+	} {
+		if _, err := c.call(req); err == nil || !strings.Contains(err.Error(), "remote error") {
+			t.Errorf("Expected %+v to be refused, got %v", req, err)
+		}
+	}
+}
+
+func TestSyncFromRemoteReplacesEntriesChangingType(t *testing.T) {
+	src := createTestDir(t, map[string]string{"x": "now a file", "y/z.txt": "Z"})
+	dst := createTestDir(t, map[string]string{"x/inner.txt": "I", "y": "was a file"})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		serveRemote(src, server)
+	}()
+	if err := SyncFromRemote(dst, client); err != nil {
+		t.Fatalf("SyncFromRemote failed: %v", err)
+	}
+	if !bytes.Equal(dirRoot(t, dst), dirRoot(t, src)) {
+		t.Error("Expected the local directory to mirror the remote one")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// defaultLargeFileThreshold is the size from which copies become resumable when unset
+	defaultLargeFileThreshold = 64 << 20
+
+	// checkpointInterval is how many bytes are copied between checkpoints
+	checkpointInterval = 1 << 20
+
+	// partSuffix and checkpointSuffix name the in-progress copy and its checkpoint
+	partSuffix       = ".merkle-part"
+	checkpointSuffix = ".merkle-part.ckpt"
+)
+
+// resumableCopy copies large files through a partial file with checkpoints, so an
+// interrupted copy continues where it stopped instead of starting over.
+type resumableCopy struct {
+	chunkSize int64
+
+	// ctx, when set, stops the copy between reads once it is cancelled,
+	// keeping the partial file and its last checkpoint
+	ctx context.Context
+
+	// afterCheckpoint is called after each checkpoint is recorded (used by tests)
+	afterCheckpoint func(written int64) error
+}
+
+// copyResumableFile copies src to dst, resuming from a previous attempt when possible
+func copyResumableFile(ctx context.Context, src, dst string, mode os.FileMode) error {
+	return resumableCopy{chunkSize: checkpointInterval, ctx: ctx}.copy(src, dst, mode)
+}
+
+// isResumeArtifact reports whether a relative path is a partial copy or checkpoint
+func isResumeArtifact(path string) bool {
+	return strings.HasSuffix(path, partSuffix) || strings.HasSuffix(path, checkpointSuffix)
+}
+
+// removeResumeArtifacts deletes the partial copy and checkpoint an earlier
+// interrupted copy left for destPath, once destPath was copied or deleted
+func (ds *DirectorySync) removeResumeArtifacts(destPath string) error {
+	if !ds.ResumeLargeFiles {
+		return nil
+	}
+	for _, artifact := range []string{destPath + partSuffix, destPath + checkpointSuffix} {
+		if err := os.Remove(artifact); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// copy writes src to dst+partSuffix, recording "<offset> <prefix hash>" in
+// dst+checkpointSuffix after each chunk. A retry resumes at the recorded offset
+// if both the partial file and the source still hash to the recorded prefix
+// hash; otherwise it starts over. The partial file is renamed into place once complete.
+func (rc resumableCopy) copy(src, dst string, mode os.FileMode) error {
+	partPath := dst + partSuffix
+	checkpointPath := dst + checkpointSuffix
+
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	offset, prefixHash := resumeOffset(sourceFile, partPath, checkpointPath)
+
+	partFile, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer partFile.Close()
+
+	// Discard anything written after the last checkpoint
+	if err := partFile.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := partFile.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := sourceFile.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	var source io.Reader = sourceFile
+	if rc.ctx != nil {
+		source = &contextReader{ctx: rc.ctx, r: sourceFile}
+	}
+	written := offset
+	for {
+		n, err := io.CopyN(io.MultiWriter(partFile, prefixHash), source, rc.chunkSize)
+		written += n
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n > 0 {
+			if err := partFile.Sync(); err != nil {
+				return err
+			}
+			checkpoint := fmt.Sprintf("%d %x\n", written, prefixHash.Sum(nil))
+			if err := os.WriteFile(checkpointPath, []byte(checkpoint), 0600); err != nil {
+				return err
+			}
+			if rc.afterCheckpoint != nil {
+				if err := rc.afterCheckpoint(written); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if err := partFile.Close(); err != nil {
+		return err
+	}
+	if mode == 0 {
+		sourceInfo, err := sourceFile.Stat()
+		if err != nil {
+			return err
+		}
+		mode = sourceInfo.Mode()
+	}
+	if err := os.Chmod(partPath, mode); err != nil {
+		return err
+	}
+	if err := os.Rename(partPath, dst); err != nil {
+		return err
+	}
+	return os.Remove(checkpointPath)
+}
+
+// resumeOffset returns the offset recorded by a previous attempt and a hash
+// already fed with the copied prefix, or zero and a fresh hash if the previous
+// attempt is missing, corrupt, or the source changed since.
+func resumeOffset(sourceFile *os.File, partPath, checkpointPath string) (int64, hash.Hash) {
+	offset, recorded, err := readCheckpoint(checkpointPath)
+	if err != nil || offset == 0 {
+		return 0, sha256.New()
+	}
+
+	partHash, err := hashPrefix(partPath, offset)
+	if err != nil || !bytes.Equal(partHash.Sum(nil), recorded) {
+		return 0, sha256.New()
+	}
+	sourceHash, err := hashPrefix(sourceFile.Name(), offset)
+	if err != nil || !bytes.Equal(sourceHash.Sum(nil), recorded) {
+		return 0, sha256.New()
+	}
+	return offset, sourceHash
+}
+
+// readCheckpoint parses a "<offset> <hex hash>" checkpoint file
+func readCheckpoint(path string) (int64, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	var offset int64
+	var hexHash string
+	if _, err := fmt.Sscanf(string(data), "%d %s", &offset, &hexHash); err != nil {
+		return 0, nil, err
+	}
+	recorded, err := hex.DecodeString(hexHash)
+	if err != nil {
+		return 0, nil, err
+	}
+	return offset, recorded, nil
+}
+
+// hashPrefix hashes the first n bytes of a file, failing if the file is shorter
+func hashPrefix(path string, n int64) (hash.Hash, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	copied, err := io.CopyN(h, file, n)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if copied != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return h, nil
+}
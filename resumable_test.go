@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResumableCopyResumesAfterInterruption(t *testing.T) {
+	content := strings.Repeat("0123456789abcdef", 64) // 1 KiB
+	dir := createTestDir(t, map[string]string{"large.bin": content})
+	src := filepath.Join(dir, "large.bin")
+	dst := filepath.Join(dir, "copy.bin")
+
+	// First attempt fails after the third checkpoint
+	interrupted := errors.New("connection lost")
+	first := resumableCopy{chunkSize: 100, afterCheckpoint: func(written int64) error {
+		if written >= 300 {
+			return interrupted
+		}
+		return nil
+	}}
+	if err := first.copy(src, dst, 0644); !errors.Is(err, interrupted) {
+		t.Fatalf("Expected interrupted copy, got %v", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Fatalf("Expected no file at destination before completion, got err=%v", err)
+	}
+
+	// The retry must continue from the checkpoint
+	var checkpoints []int64
+	retry := resumableCopy{chunkSize: 100, afterCheckpoint: func(written int64) error {
+		checkpoints = append(checkpoints, written)
+		return nil
+	}}
+	if err := retry.copy(src, dst, 0644); err != nil {
+		t.Fatalf("Retry failed: %v", err)
+	}
+	if len(checkpoints) == 0 || checkpoints[0] != 400 {
+		t.Errorf("Expected retry to resume at 300 and checkpoint at 400, got %v", checkpoints)
+	}
+
+	copied, err := os.ReadFile(dst)
+	if err != nil || !bytes.Equal(copied, []byte(content)) {
+		t.Errorf("Copied content mismatch (err=%v)", err)
+	}
+	for _, leftover := range []string{dst + partSuffix, dst + checkpointSuffix} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got err=%v", leftover, err)
+		}
+	}
+}
+
+func TestResumableCopyRestartsWhenSourceChanged(t *testing.T) {
+	dir := createTestDir(t, map[string]string{"large.bin": strings.Repeat("a", 500)})
+	src := filepath.Join(dir, "large.bin")
+	dst := filepath.Join(dir, "copy.bin")
+
+	stop := errors.New("stop")
+	first := resumableCopy{chunkSize: 100, afterCheckpoint: func(int64) error { return stop }}
+	if err := first.copy(src, dst, 0644); !errors.Is(err, stop) {
+		t.Fatalf("Expected interrupted copy, got %v", err)
+	}
+
+	// Changing the already-copied prefix invalidates the checkpoint
+	if err := os.WriteFile(src, []byte(strings.Repeat("b", 500)), 0644); err != nil {
+		t.Fatalf("Failed to rewrite source: %v", err)
+	}
+	var checkpoints []int64
+	retry := resumableCopy{chunkSize: 100, afterCheckpoint: func(written int64) error {
+		checkpoints = append(checkpoints, written)
+		return nil
+	}}
+	if err := retry.copy(src, dst, 0644); err != nil {
+		t.Fatalf("Retry failed: %v", err)
+	}
+	if checkpoints[0] != 100 {
+		t.Errorf("Expected copy to restart from zero, first checkpoint at %d", checkpoints[0])
+	}
+	copied, _ := os.ReadFile(dst)
+	if string(copied) != strings.Repeat("b", 500) {
+		t.Errorf("Copied content does not match the changed source")
+	}
+}
+
+func TestSyncKeepsResumeArtifacts(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A"})
+	dst := createTestDir(t, map[string]string{"big.bin" + partSuffix: "partial", "big.bin" + checkpointSuffix: "7 00"})
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, ResumeLargeFiles: true}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "big.bin"+partSuffix)); err != nil {
+		t.Errorf("Expected partial copy to survive the sync: %v", err)
+	}
+
+	// Artifacts are not part of the destination listing or its root
+	destFiles, err := syncer.BuildDirectoryTree(dst)
+	if err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+	if paths := filePaths(destFiles); !slices.Equal(paths, []string{"a.txt"}) {
+		t.Errorf("Expected only a.txt in the destination listing, got %v", paths)
+	}
+	syncer.VerifyOnComplete = true
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Errorf("Expected the destination to verify despite the partial copy: %v", err)
+	}
+}
+
+func TestSyncRemovesResumeArtifacts(t *testing.T) {
+	src := createTestDir(t, map[string]string{"done.bin": "complete"})
+	dst := createTestDir(t, map[string]string{
+		"done.bin" + partSuffix:       "comp",
+		"done.bin" + checkpointSuffix: "4 00",
+		"gone.bin":                    "old",
+		"gone.bin" + partSuffix:       "ol",
+	})
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, ResumeLargeFiles: true}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	entries, _ := os.ReadDir(dst)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if !slices.Equal(names, []string{"done.bin"}) {
+		t.Errorf("Expected artifacts of copied and deleted files to be removed, got %v", names)
+	}
+}
+
+func TestSyncRejectsSparseResumableCopies(t *testing.T) {
+	syncer := &DirectorySync{
+		SourceDir:        createTestDir(t, map[string]string{"a.txt": "A"}),
+		DestinationDir:   t.TempDir(),
+		PreserveSparse:   true,
+		ResumeLargeFiles: true,
+	}
+	if err := syncer.SyncDirectories(); !errors.Is(err, ErrIncompatibleOptions) {
+		t.Errorf("Expected ErrIncompatibleOptions, got %v", err)
+	}
+}
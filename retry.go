@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"syscall"
+	"time"
+)
+
+// isTransientError reports whether an I/O error is likely to go away when the
+// operation is retried, like a timeout on a network file system. Errors such
+// as permission denied or a missing file are permanent.
+func isTransientError(err error) bool {
+	return os.IsTimeout(err) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.ETIMEDOUT)
+}
+
+// withRetries runs op, retrying it up to MaxRetries times while it fails with
+// a transient error. The wait starts at RetryBackoff and doubles every retry;
+// it is cut short, returning ctx.Err(), once ctx is cancelled.
+func (ds *DirectorySync) withRetries(ctx context.Context, op func() error) error {
+	err := op()
+	backoff := ds.RetryBackoff
+	for attempt := 0; err != nil && attempt < ds.MaxRetries && isTransientError(err); attempt++ {
+		ds.logf("Retrying after transient error: %v\n", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		// Stop doubling before the wait overflows
+		if backoff <= math.MaxInt64/2 {
+			backoff *= 2
+		}
+		err = op()
+	}
+	return err
+}
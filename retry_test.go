@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSyncRetriesTransientErrors(t *testing.T) {
+	flakyCopier := func(failures int, failErr error) (func(src, dst string, mode os.FileMode) error, *int) {
+		calls := 0
+		return func(src, dst string, mode os.FileMode) error {
+			calls++
+			if calls <= failures {
+				return &os.PathError{Op: "write", Path: dst, Err: failErr}
+			}
+			return copyFile(src, dst, mode)
+		}, &calls
+	}
+
+	testCases := []struct {
+		name       string
+		failures   int
+		failErr    error
+		maxRetries int
+		wantCalls  int
+		wantCopied bool
+	}{
+		{"RecoversWithinRetries", 2, syscall.EAGAIN, 3, 3, true},
+		{"TimeoutRecovers", 1, os.ErrDeadlineExceeded, 1, 2, true},
+		{"GivesUpAfterRetries", 2, syscall.EAGAIN, 1, 2, false},
+		{"NoRetriesByDefault", 1, syscall.EAGAIN, 0, 1, false},
+		{"PermanentErrorNotRetried", 1, syscall.EACCES, 3, 1, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src := createTestDir(t, map[string]string{"a.txt": "A"})
+			dst := t.TempDir()
+			copier, calls := flakyCopier(tc.failures, tc.failErr)
+			syncer := &DirectorySync{
+				SourceDir:      src,
+				DestinationDir: dst,
+				MaxRetries:     tc.maxRetries,
+				RetryBackoff:   time.Millisecond,
+				copier:         copier,
+			}
+
+			err := syncer.SyncDirectories()
+			if *calls != tc.wantCalls {
+				t.Errorf("Expected %d copy attempts, got %d", tc.wantCalls, *calls)
+			}
+			if tc.wantCopied {
+				if err != nil {
+					t.Fatalf("SyncDirectories failed: %v", err)
+				}
+				if content, err := os.ReadFile(filepath.Join(dst, "a.txt")); err != nil || string(content) != "A" {
+					t.Errorf("Expected a.txt to be copied eventually, got %q, %v", content, err)
+				}
+			} else if err == nil {
+				t.Errorf("Expected the copy error to be reported")
+			}
+		})
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		want bool
+	}{
+		{syscall.EAGAIN, true},
+		{fmt.Errorf("copy: %w", syscall.ETIMEDOUT), true},
+		{&os.PathError{Op: "read", Path: "f", Err: os.ErrDeadlineExceeded}, true},
+		{syscall.EINTR, true},
+		{os.ErrPermission, false},
+		{&os.PathError{Op: "open", Path: "f", Err: syscall.ENOENT}, false},
+	}
+	for _, tc := range testCases {
+		if got := isTransientError(tc.err); got != tc.want {
+			t.Errorf("isTransientError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestWithRetriesStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// A backoff that would overflow if shifted and a wait far longer than the test
+	syncer := &DirectorySync{MaxRetries: 100, RetryBackoff: time.Hour, OnProgress: func(SyncEvent) {}}
+	calls := 0
+	start := time.Now()
+	err := syncer.withRetries(ctx, func() error {
+		calls++
+		return syscall.EAGAIN
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("Expected one attempt and a wait cut short, got %d attempts in %v", calls, time.Since(start))
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import "math/bits"
+
+// GenerateInclusionProofRFC6962 returns the RFC 6962 audit path for the leaf at
+// index in the tree made of the first treeSize leaves, so proofs can be issued
+// for any earlier tree head. Interior nodes are hashed as RFC 6962 defines,
+// and the tree's Leaves are used as the leaf hashes; for Certificate
+// Transparency those must be SHA-256(0x00 || entry), which is what a tree
+// built with TreeOptions{OddNodeStrategy: OddNodePromote, DomainSeparation: true}
+// holds. Verify the result with VerifyInclusionRFC6962.
+func (t *MerkleTree) GenerateInclusionProofRFC6962(index, treeSize int) ([][]byte, error) {
+	if t == nil {
+		return nil, ErrNilTree
+	}
+	if treeSize <= 0 || treeSize > len(t.Leaves) {
+		return nil, ErrInvalidTreeSize
+	}
+	if index < 0 || index >= treeSize {
+		return nil, ErrOutOfBoundary
+	}
+	return rfc6962Path(index, t.Leaves[:treeSize]), nil
+}
+
+// VerifyInclusionRFC6962 checks an RFC 6962 audit path for leafHash at index in
+// a tree of treeSize leaves against root. Unlike VerifyProof, the side of each
+// sibling follows from the subtree sizes of the tree, not from index parity
+// alone (RFC 9162, section 2.1.3.2).
+func VerifyInclusionRFC6962(root []byte, proof [][]byte, leafHash []byte, index, treeSize int) (bool, error) {
+	if len(root) == 0 || len(leafHash) == 0 {
+		return false, ErrInvalidProofInputs
+	}
+	if index < 0 || index >= treeSize {
+		return false, ErrOutOfBoundary
+	}
+
+	fn, sn := index, treeSize-1
+	currentHash := leafHash
+	for _, siblingHash := range proof {
+		if len(siblingHash) == 0 {
+			return false, ErrInvalidProof
+		}
+		if sn == 0 {
+			// More siblings than levels: the proof cannot belong to this tree
+			return false, nil
+		}
+		if fn%2 == 1 || fn == sn {
+			currentHash = rfc6962NodeHash(siblingHash, currentHash)
+			// A right-most node without a sibling is carried up unchanged
+			for fn%2 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			currentHash = rfc6962NodeHash(currentHash, siblingHash)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return false, nil
+	}
+	return equalHashes(currentHash, root), nil
+}
+
+// ConsistencyProof returns the RFC 6962 consistency proof between the tree
+// made of the first oldSize leaves and the one made of the first newSize
+// leaves, showing the newer tree only appends to the older one. Like
+// GenerateInclusionProofRFC6962, interior nodes are hashed as RFC 6962
+// defines, so the tree must have been built with
+// TreeOptions{OddNodeStrategy: OddNodePromote, DomainSeparation: true};
+// other trees return ErrNotRFC6962Tree. Verify the result with
+// VerifyConsistency.
+func (t *MerkleTree) ConsistencyProof(oldSize, newSize int) ([][]byte, error) {
+	if t == nil {
+		return nil, ErrNilTree
+	}
+	if !isRFC6962Options(t.opts) {
+		return nil, ErrNotRFC6962Tree
+	}
+	if oldSize <= 0 || oldSize > newSize || newSize > len(t.Leaves) {
+		return nil, ErrInvalidTreeSize
+	}
+	return t.rfc6962Subproof(oldSize, 0, newSize, true), nil
+}
+
+// VerifyConsistency checks that proof links oldRoot, the root of a tree of
+// oldSize leaves, to newRoot, the root of the same tree after growing to
+// newSize leaves (RFC 9162, section 2.1.4.2).
+func VerifyConsistency(oldRoot, newRoot []byte, oldSize, newSize int, proof [][]byte) (bool, error) {
+	if len(oldRoot) == 0 || len(newRoot) == 0 {
+		return false, ErrInvalidProofInputs
+	}
+	if oldSize <= 0 || oldSize > newSize {
+		return false, ErrInvalidTreeSize
+	}
+	if oldSize == newSize {
+		return len(proof) == 0 && equalHashes(oldRoot, newRoot), nil
+	}
+	if len(proof) == 0 {
+		return false, nil
+	}
+	for _, hash := range proof {
+		if len(hash) == 0 {
+			return false, ErrInvalidProof
+		}
+	}
+
+	// A complete old tree is a node of the new one, so its root starts the path
+	if oldSize&(oldSize-1) == 0 {
+		proof = append([][]byte{oldRoot}, proof...)
+	}
+	fn, sn := oldSize-1, newSize-1
+	for fn%2 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			// More hashes than levels: the proof cannot belong to these trees
+			return false, nil
+		}
+		if fn%2 == 1 || fn == sn {
+			fr = rfc6962NodeHash(c, fr)
+			sr = rfc6962NodeHash(c, sr)
+			for fn%2 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = rfc6962NodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return false, nil
+	}
+	return equalHashes(fr, oldRoot) && equalHashes(sr, newRoot), nil
+}
+
+// rfc6962Subproof computes SUBPROOF(m, D[start:end], b) from RFC 6962,
+// section 2.1.2.
+func (t *MerkleTree) rfc6962Subproof(m, start, end int, complete bool) [][]byte {
+	n := end - start
+	if m == n {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{t.rfc6962SubtreeRoot(start, end)}
+	}
+	k := rfc6962Split(n)
+	if m <= k {
+		return append(t.rfc6962Subproof(m, start, start+k, complete), t.rfc6962SubtreeRoot(start+k, end))
+	}
+	return append(t.rfc6962Subproof(m-k, start+k, end, false), t.rfc6962SubtreeRoot(start, start+k))
+}
+
+// rfc6962SubtreeRoot computes MTH(D[start:end]) for a tree built with
+// rfc6962Options. A range covered by a single node is read from t.nodes, so
+// only the right edge of a smaller tree size is hashed again.
+func (t *MerkleTree) rfc6962SubtreeRoot(start, end int) []byte {
+	n := end - start
+	level := bits.Len(uint(n - 1))
+	covered := n == 1<<level || end == len(t.Leaves)
+	if covered && start%(1<<level) == 0 && level < len(t.nodes) {
+		return t.nodes[level][start>>level]
+	}
+	k := rfc6962Split(n)
+	return rfc6962NodeHash(t.rfc6962SubtreeRoot(start, start+k), t.rfc6962SubtreeRoot(start+k, end))
+}
+
+// rfc6962Path computes PATH(m, D[n]) from RFC 6962, section 2.1.1.
+func rfc6962Path(m int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return [][]byte{}
+	}
+	k := rfc6962Split(len(leaves))
+	if m < k {
+		return append(rfc6962Path(m, leaves[:k]), rfc6962Root(leaves[k:]))
+	}
+	return append(rfc6962Path(m-k, leaves[k:]), rfc6962Root(leaves[:k]))
+}
+
+// rfc6962Root computes MTH(D[n]) over leaf hashes.
+func rfc6962Root(leaves [][]byte) []byte {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	k := rfc6962Split(len(leaves))
+	return rfc6962NodeHash(rfc6962Root(leaves[:k]), rfc6962Root(leaves[k:]))
+}
+
+// rfc6962Split returns the largest power of two smaller than n, for n > 1.
+func rfc6962Split(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
+
+// rfc6962NodeHash computes SHA-256(0x01 || left || right)
+func rfc6962NodeHash(left, right []byte) []byte {
+	return rfc6962Options.hashNode(left, right)
+}
+
+// rfc6962Options builds trees with the RFC 6962 shape and hashing
+var rfc6962Options = TreeOptions{OddNodeStrategy: OddNodePromote, DomainSeparation: true}
+
+// isRFC6962Options reports whether opts build the same trees as rfc6962Options
+func isRFC6962Options(opts TreeOptions) bool {
+	return opts.OddNodeStrategy == OddNodePromote && opts.DomainSeparation && opts.Hash == nil
+}
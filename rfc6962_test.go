@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// rfc6962TestTree builds a tree over the RFC 6962 reference entries used by
+// the Certificate Transparency test suites, with CT-style leaf hashes.
+func rfc6962TestTree() *MerkleTree {
+	entries := []string{"", "00", "10", "2021", "3031", "40414243",
+		"5051525354555657", "606162636465666768696a6b6c6d6e6f"}
+	dataBlocks := make([][]byte, len(entries))
+	for i, entry := range entries {
+		dataBlocks[i], _ = hex.DecodeString(entry)
+	}
+	tree, _ := NewTreeWithOptions(dataBlocks, rfc6962Options)
+	return tree
+}
+
+func decodeHexList(t *testing.T, values ...string) [][]byte {
+	t.Helper()
+	decoded := make([][]byte, len(values))
+	for i, v := range values {
+		b, err := hex.DecodeString(v)
+		if err != nil {
+			t.Fatalf("Invalid hex %q: %v", v, err)
+		}
+		decoded[i] = b
+	}
+	return decoded
+}
+
+func TestInclusionProofRFC6962Vectors(t *testing.T) {
+	tree := rfc6962TestTree()
+	roots := decodeHexList(t,
+		"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+		"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+		"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+		"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+	)
+
+	testCases := []struct {
+		index, treeSize int
+		path            []string
+	}{
+		{0, 1, nil},
+		{0, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{5, 8, []string{
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 3, []string{
+			"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		}},
+	}
+	for _, tc := range testCases {
+		proof, err := tree.GenerateInclusionProofRFC6962(tc.index, tc.treeSize)
+		if err != nil {
+			t.Fatalf("GenerateInclusionProofRFC6962(%d, %d) failed: %v", tc.index, tc.treeSize, err)
+		}
+		want := decodeHexList(t, tc.path...)
+		if len(proof) != len(want) {
+			t.Fatalf("Index %d of %d: expected %d siblings, got %d", tc.index, tc.treeSize, len(want), len(proof))
+		}
+		for i := range want {
+			if !equalHashes(proof[i], want[i]) {
+				t.Errorf("Index %d of %d: sibling %d is %x, expected %x", tc.index, tc.treeSize, i, proof[i], want[i])
+			}
+		}
+	}
+
+	if !equalHashes(tree.Root, roots[len(roots)-1]) {
+		t.Errorf("Expected a promote tree with domain separation to have the RFC 6962 root")
+	}
+
+	// Every leaf must verify against the published root of every tree size
+	for treeSize := 1; treeSize <= len(tree.Leaves); treeSize++ {
+		for index := range treeSize {
+			proof, err := tree.GenerateInclusionProofRFC6962(index, treeSize)
+			if err != nil {
+				t.Fatalf("GenerateInclusionProofRFC6962(%d, %d) failed: %v", index, treeSize, err)
+			}
+			isValid, err := VerifyInclusionRFC6962(roots[treeSize-1], proof, tree.Leaves[index], index, treeSize)
+			if err != nil || !isValid {
+				t.Errorf("Index %d of %d: expected valid proof, got %v, %v", index, treeSize, isValid, err)
+			}
+		}
+	}
+}
+
+func TestVerifyInclusionRFC6962Rejects(t *testing.T) {
+	tree := rfc6962TestTree()
+	root := rfc6962Root(tree.Leaves[:7])
+	proof, _ := tree.GenerateInclusionProofRFC6962(6, 7)
+
+	testCases := []struct {
+		name            string
+		proof           [][]byte
+		leafHash        []byte
+		index, treeSize int
+	}{
+		{"WrongLeaf", proof, tree.Leaves[5], 6, 7},
+		{"WrongIndex", proof, tree.Leaves[6], 5, 7},
+		{"WrongTreeSize", proof, tree.Leaves[6], 6, 8},
+		{"ExtraSibling", append(proof, tree.Leaves[0]), tree.Leaves[6], 6, 7},
+		{"MissingSibling", proof[:len(proof)-1], tree.Leaves[6], 6, 7},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			isValid, err := VerifyInclusionRFC6962(root, tc.proof, tc.leafHash, tc.index, tc.treeSize)
+			if err != nil || isValid {
+				t.Errorf("Expected proof to be rejected, got %v, %v", isValid, err)
+			}
+		})
+	}
+
+	if _, err := VerifyInclusionRFC6962(root, proof, tree.Leaves[6], 7, 7); !errors.Is(err, ErrOutOfBoundary) {
+		t.Errorf("Expected ErrOutOfBoundary, got %v", err)
+	}
+	if _, err := tree.GenerateInclusionProofRFC6962(0, 9); !errors.Is(err, ErrInvalidTreeSize) {
+		t.Errorf("Expected ErrInvalidTreeSize, got %v", err)
+	}
+	if _, err := tree.GenerateInclusionProofRFC6962(3, 3); !errors.Is(err, ErrOutOfBoundary) {
+		t.Errorf("Expected ErrOutOfBoundary, got %v", err)
+	}
+}
+
+func TestConsistencyProofRFC6962Vectors(t *testing.T) {
+	tree := rfc6962TestTree()
+
+	testCases := []struct {
+		oldSize, newSize int
+		proof            []string
+	}{
+		{1, 1, nil},
+		{1, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{6, 8, []string{
+			"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 5, []string{
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		}},
+	}
+	for _, tc := range testCases {
+		proof, err := tree.ConsistencyProof(tc.oldSize, tc.newSize)
+		if err != nil {
+			t.Fatalf("ConsistencyProof(%d, %d) failed: %v", tc.oldSize, tc.newSize, err)
+		}
+		want := decodeHexList(t, tc.proof...)
+		if len(proof) != len(want) {
+			t.Fatalf("%d to %d: expected %d hashes, got %d", tc.oldSize, tc.newSize, len(want), len(proof))
+		}
+		for i := range want {
+			if !equalHashes(proof[i], want[i]) {
+				t.Errorf("%d to %d: hash %d is %x, expected %x", tc.oldSize, tc.newSize, i, proof[i], want[i])
+			}
+		}
+	}
+
+	// Every pair of tree sizes must be linked by its proof
+	for newSize := 1; newSize <= len(tree.Leaves); newSize++ {
+		newRoot := rfc6962Root(tree.Leaves[:newSize])
+		for oldSize := 1; oldSize <= newSize; oldSize++ {
+			proof, _ := tree.ConsistencyProof(oldSize, newSize)
+			isValid, err := VerifyConsistency(rfc6962Root(tree.Leaves[:oldSize]), newRoot, oldSize, newSize, proof)
+			if err != nil || !isValid {
+				t.Errorf("%d to %d: expected valid proof, got %v, %v", oldSize, newSize, isValid, err)
+			}
+		}
+	}
+}
+
+func TestConsistencyProofGrowingTree(t *testing.T) {
+	tree, _ := NewTreeWithOptions(createTestDataBlocks("A", "B", "C"), rfc6962Options)
+	oldRoot := tree.Root
+	for _, block := range []string{"D", "E", "F", "G"} {
+		if err := tree.AppendLeaf([]byte(block)); err != nil {
+			t.Fatalf("AppendLeaf failed: %v", err)
+		}
+	}
+
+	proof, err := tree.ConsistencyProof(3, 7)
+	if err != nil {
+		t.Fatalf("ConsistencyProof failed: %v", err)
+	}
+	isValid, err := VerifyConsistency(oldRoot, tree.Root, 3, 7, proof)
+	if err != nil || !isValid {
+		t.Fatalf("Expected the 3-leaf root to be consistent with the 7-leaf root, got %v, %v", isValid, err)
+	}
+
+	// A rewritten history must not verify against the same proof
+	forked, _ := NewTreeWithOptions(createTestDataBlocks("A", "X", "C"), rfc6962Options)
+	if isValid, err := VerifyConsistency(forked.Root, tree.Root, 3, 7, proof); err != nil || isValid {
+		t.Errorf("Expected a forked old root to be rejected, got %v, %v", isValid, err)
+	}
+	if isValid, err := VerifyConsistency(oldRoot, tree.Root, 3, 4, proof); err != nil || isValid {
+		t.Errorf("Expected a wrong new size to be rejected, got %v, %v", isValid, err)
+	}
+	if isValid, err := VerifyConsistency(oldRoot, tree.Root, 3, 7, proof[:len(proof)-1]); err != nil || isValid {
+		t.Errorf("Expected a truncated proof to be rejected, got %v, %v", isValid, err)
+	}
+	if _, err := tree.ConsistencyProof(5, 3); !errors.Is(err, ErrInvalidTreeSize) {
+		t.Errorf("Expected ErrInvalidTreeSize, got %v", err)
+	}
+	if _, err := VerifyConsistency(oldRoot, tree.Root, 0, 7, proof); !errors.Is(err, ErrInvalidTreeSize) {
+		t.Errorf("Expected ErrInvalidTreeSize, got %v", err)
+	}
+}
+
+func TestConsistencyProofRequiresRFC6962Tree(t *testing.T) {
+	for _, opts := range []TreeOptions{
+		{},
+		{OddNodeStrategy: OddNodePromote},
+		{DomainSeparation: true},
+		{OddNodeStrategy: OddNodePromote, DomainSeparation: true, Hash: sha512.New},
+	} {
+		tree, _ := NewTreeWithOptions(createTestDataBlocks("A", "B", "C"), opts)
+		if _, err := tree.ConsistencyProof(1, 3); !errors.Is(err, ErrNotRFC6962Tree) {
+			t.Errorf("%+v: expected ErrNotRFC6962Tree, got %v", opts, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// scrubEntry is a path seen by Scrub and the data block it contributes
+type scrubEntry struct {
+	path   string
+	isDir  bool
+	isLink bool
+	block  []byte
+}
+
+// Scrub reports whether dir still has the Merkle root expectedRoot, as built
+// by BuildDirectoryTree and BuildMerkleTree with default settings. It is meant
+// for periodic integrity checks of large datasets: no FileInfo metadata or tree
+// nodes are kept, files are hashed in parallel through reusable buffers, and
+// the root is computed level by level. Use WithTreeOptions when the root was
+// built with non-default tree options.
+func Scrub(dir string, expectedRoot []byte, opts ...Option) (bool, error) {
+	cfg := newConfig(opts)
+
+	var entries []scrubEntry
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		if isMetadataFile(filepath.ToSlash(relPath)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		entries = append(entries, scrubEntry{path: filepath.ToSlash(relPath), isDir: d.IsDir(), isLink: d.Type()&fs.ModeSymlink != 0})
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(entries) == 0 {
+		return false, ErrZeroLeaves
+	}
+	// Walk order differs from a plain path sort (e.g. "a-b" and "a/c")
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].path < entries[j].path
+	})
+
+	parents := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		parents[path.Dir(entry.path)] = true
+	}
+
+	buffers := make(chan []byte, cfg.concurrency)
+	for range cfg.concurrency {
+		buffers <- make([]byte, cfg.bufferSize)
+	}
+	err = runParallel(context.Background(), cfg.concurrency, len(entries), func(i int) error {
+		entry := &entries[i]
+		if entry.isDir {
+			entry.block = dirLeafData(entry.path, !parents[entry.path])
+			return nil
+		}
+		if entry.isLink {
+			hash, err := hashSymlink(filepath.Join(dir, filepath.FromSlash(entry.path)))
+			entry.block = hash
+			return err
+		}
+		buf := <-buffers
+		defer func() { buffers <- buf }()
+
+		file, err := os.Open(filepath.Join(dir, filepath.FromSlash(entry.path)))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		hash := sha256.New()
+		if _, err := io.CopyBuffer(hash, file, buf); err != nil {
+			return err
+		}
+		entry.block = hash.Sum(nil)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	blocks := make([][]byte, len(entries))
+	for i, entry := range entries {
+		blocks[i] = entry.block
+	}
+	root, err := computeRoot(hashLeaves(blocks, cfg.treeOptions), cfg.treeOptions)
+	if err != nil {
+		return false, err
+	}
+	return equalHashes(root, expectedRoot), nil
+}
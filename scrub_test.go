@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScrub(t *testing.T) {
+	// "a-b.txt" sorts before "a/" by path but is walked after it
+	dir := createTestDir(t, map[string]string{"a/c.txt": "C", "a-b.txt": "B", "z.txt": "Z"})
+	root := dirRoot(t, dir)
+
+	for _, opts := range [][]Option{nil, {WithConcurrency(1), WithBufferSize(1)}} {
+		isValid, err := Scrub(dir, root, opts...)
+		if err != nil || !isValid {
+			t.Errorf("Expected scrub to match the directory root, got %v, %v", isValid, err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "a", "c.txt"), []byte("bitrot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if isValid, err := Scrub(dir, root); err != nil || isValid {
+		t.Errorf("Expected scrub to detect the change, got %v, %v", isValid, err)
+	}
+
+	t.Run("TreeOptions", func(t *testing.T) {
+		opts := TreeOptions{OddNodeStrategy: OddNodePromote}
+		ds := &DirectorySync{TreeOptions: opts}
+		files, _ := ds.BuildDirectoryTree(dir)
+		tree, _ := ds.BuildMerkleTree(files)
+		if isValid, err := Scrub(dir, tree.Root, WithTreeOptions(opts)); err != nil || !isValid {
+			t.Errorf("Expected scrub with matching tree options to pass, got %v, %v", isValid, err)
+		}
+	})
+}
+
+func BenchmarkScrub(b *testing.B) {
+	src := createManyFiles(b, 500)
+	dst := b.TempDir()
+	if err := (&DirectorySync{SourceDir: src, DestinationDir: dst}).SyncDirectories(); err != nil {
+		b.Fatalf("SyncDirectories failed: %v", err)
+	}
+	ds := &DirectorySync{}
+	files, _ := ds.BuildDirectoryTree(src)
+	tree, _ := ds.BuildMerkleTree(files)
+
+	b.Run("Scrub", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if isValid, err := Scrub(dst, tree.Root); err != nil || !isValid {
+				b.Fatalf("Scrub failed: %v, %v", isValid, err)
+			}
+		}
+	})
+	b.Run("SyncDirectoriesVerify", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, VerifyOnComplete: true}
+			if err := syncer.SyncDirectories(); err != nil {
+				b.Fatalf("SyncDirectories failed: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Serialized trees start with a fixed header followed by an offset table with
+// one entry per level and then every level's hashes back to back:
+//
+//	magic "MRKT" | version u8 | odd node strategy u8 | hash size u16 | level count u32
+//	level count x (offset u64 | node count u64)
+//	level 0 hashes | level 1 hashes | ... | root
+//
+// All integers are big-endian. Hashes have a fixed size, so the node at
+// (level, index) lives at offset[level] + index*hashSize and can be read
+// without loading the rest of the tree.
+const (
+	treeMagic       = "MRKT"
+	treeVersion     = 1
+	treeHeaderSize  = 12
+	treeLevelSize   = 16
+	maxTreeHashSize = 1 << 10
+)
+
+// WriteTo serializes every level of the tree to w in a seekable layout, so
+// LoadProofFor can later read a single proof back. It implements io.WriterTo.
+func (t *MerkleTree) WriteTo(w io.Writer) (int64, error) {
+	if t == nil {
+		return 0, ErrNilTree
+	}
+	if len(t.nodes) == 0 {
+		return 0, ErrZeroLeaves
+	}
+	hashSize := len(t.Root)
+
+	header := make([]byte, 0, treeHeaderSize+treeLevelSize*len(t.nodes))
+	header = append(header, treeMagic...)
+	header = append(header, treeVersion, byte(t.opts.OddNodeStrategy))
+	header = binary.BigEndian.AppendUint16(header, uint16(hashSize))
+	header = binary.BigEndian.AppendUint32(header, uint32(len(t.nodes)))
+	offset := uint64(cap(header))
+	for _, level := range t.nodes {
+		header = binary.BigEndian.AppendUint64(header, offset)
+		header = binary.BigEndian.AppendUint64(header, uint64(len(level)))
+		offset += uint64(len(level) * hashSize)
+	}
+
+	bw := bufio.NewWriter(w)
+	written, err := bw.Write(header)
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+	for _, level := range t.nodes {
+		for _, node := range level {
+			if len(node) != hashSize {
+				return total, fmt.Errorf("%w: hashes of different sizes", ErrInvalidTreeData)
+			}
+			written, err := bw.Write(node)
+			total += int64(written)
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, bw.Flush()
+}
+
+// serializedTree gives random access to a tree written by WriteTo
+type serializedTree struct {
+	r        io.ReaderAt
+	strategy OddNodeStrategy
+	hashSize int
+	offsets  []int64
+	counts   []int
+}
+
+// LoadProofFor reads the proof for the leaf at index from a tree serialized by
+// WriteTo. Only the header, the leaf and its siblings are read, so verifying one
+// leaf does not require loading the whole tree into memory.
+func LoadProofFor(r io.ReaderAt, index int) (*Proof, error) {
+	tree, err := openSerializedTree(r)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= tree.counts[0] {
+		return nil, ErrOutOfBoundary
+	}
+
+	leafHash, err := tree.node(0, index)
+	if err != nil {
+		return nil, err
+	}
+	proof := &Proof{Path: [][]byte{}, LeafHash: leafHash, LeafIndex: index, TreeSize: tree.counts[0]}
+
+	// Walks the levels exactly like GenerateProof
+	currentIndex := index
+	for level := range len(tree.counts) - 1 {
+		siblingIndex := currentIndex ^ 1
+		if siblingIndex >= tree.counts[level] {
+			if tree.strategy == OddNodePromote {
+				currentIndex = currentIndex / 2
+				continue
+			}
+			siblingIndex = currentIndex
+		}
+		sibling, err := tree.node(level, siblingIndex)
+		if err != nil {
+			return nil, err
+		}
+		proof.Path = append(proof.Path, sibling)
+		currentIndex = currentIndex / 2
+	}
+	return proof, nil
+}
+
+// openSerializedTree reads and validates the header and offset table
+func openSerializedTree(r io.ReaderAt) (*serializedTree, error) {
+	header := make([]byte, treeHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("%w: reading header: %v", ErrInvalidTreeData, err)
+	}
+	if string(header[:4]) != treeMagic || header[4] != treeVersion {
+		return nil, fmt.Errorf("%w: unknown format or version", ErrInvalidTreeData)
+	}
+	tree := &serializedTree{
+		r:        r,
+		strategy: OddNodeStrategy(header[5]),
+		hashSize: int(binary.BigEndian.Uint16(header[6:8])),
+	}
+	levels := int(binary.BigEndian.Uint32(header[8:12]))
+	if tree.hashSize == 0 || tree.hashSize > maxTreeHashSize || levels == 0 || levels > 64 {
+		return nil, fmt.Errorf("%w: invalid header", ErrInvalidTreeData)
+	}
+
+	table := make([]byte, levels*treeLevelSize)
+	if _, err := r.ReadAt(table, treeHeaderSize); err != nil {
+		return nil, fmt.Errorf("%w: reading offset table: %v", ErrInvalidTreeData, err)
+	}
+	for level := range levels {
+		entry := table[level*treeLevelSize:]
+		offset := binary.BigEndian.Uint64(entry[:8])
+		count := binary.BigEndian.Uint64(entry[8:16])
+		// Each level halves the one below it, rounding up
+		if level == 0 && (count == 0 || count > 1<<48) || level > 0 && uint64(tree.counts[level-1]+1)/2 != count {
+			return nil, fmt.Errorf("%w: level %d has %d nodes", ErrInvalidTreeData, level, count)
+		}
+		tree.offsets = append(tree.offsets, int64(offset))
+		tree.counts = append(tree.counts, int(count))
+	}
+	if tree.counts[levels-1] != 1 {
+		return nil, fmt.Errorf("%w: top level must hold only the root", ErrInvalidTreeData)
+	}
+	return tree, nil
+}
+
+// node reads the hash at (level, index)
+func (s *serializedTree) node(level, index int) ([]byte, error) {
+	hash := make([]byte, s.hashSize)
+	offset := s.offsets[level] + int64(index)*int64(s.hashSize)
+	if _, err := s.r.ReadAt(hash, offset); err != nil {
+		return nil, fmt.Errorf("%w: reading node %d at level %d: %v", ErrInvalidTreeData, index, level, err)
+	}
+	return hash, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+// countingReaderAt records how many bytes are read through it
+type countingReaderAt struct {
+	r    io.ReaderAt
+	read atomic.Int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func TestLoadProofFor(t *testing.T) {
+	for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodePromote} {
+		for _, size := range []int{1, 2, 5, 8, 13} {
+			t.Run(fmt.Sprintf("%s/%d", strategy, size), func(t *testing.T) {
+				blocks := make([][]byte, size)
+				for i := range blocks {
+					blocks[i] = []byte(fmt.Sprintf("block %d", i))
+				}
+				opts := TreeOptions{OddNodeStrategy: strategy}
+				tree, _ := NewTreeWithOptions(blocks, opts)
+
+				var buf bytes.Buffer
+				n, err := tree.WriteTo(&buf)
+				if err != nil {
+					t.Fatalf("WriteTo failed: %v", err)
+				}
+				if n != int64(buf.Len()) {
+					t.Errorf("WriteTo reported %d bytes, wrote %d", n, buf.Len())
+				}
+
+				for index := range size {
+					proof, err := LoadProofFor(bytes.NewReader(buf.Bytes()), index)
+					if err != nil {
+						t.Fatalf("LoadProofFor(%d) failed: %v", index, err)
+					}
+					wantPath, wantLeaf, _ := tree.GenerateProof(index)
+					if !bytes.Equal(proof.LeafHash, wantLeaf) || !slices.EqualFunc(proof.Path, wantPath, bytes.Equal) {
+						t.Errorf("Loaded proof for %d differs from GenerateProof", index)
+					}
+					isValid, err := VerifyProofWithOptions(tree.Root, proof.Path, proof.LeafHash, proof.LeafIndex, proof.TreeSize, opts)
+					if err != nil || !isValid {
+						t.Errorf("Expected loaded proof for %d to verify, got %v, %v", index, isValid, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestLoadProofForReadsOnlyThePath(t *testing.T) {
+	blocks := make([][]byte, 1024)
+	for i := range blocks {
+		blocks[i] = []byte(fmt.Sprintf("block %d", i))
+	}
+	tree, _ := NewTree(blocks)
+	var buf bytes.Buffer
+	tree.WriteTo(&buf)
+
+	reader := &countingReaderAt{r: bytes.NewReader(buf.Bytes())}
+	if _, err := LoadProofFor(reader, 700); err != nil {
+		t.Fatalf("LoadProofFor failed: %v", err)
+	}
+	// Header, offset table, the leaf and one sibling per level
+	levels := len(tree.nodes)
+	want := int64(treeHeaderSize + levels*treeLevelSize + levels*len(tree.Root))
+	if reader.read.Load() != want {
+		t.Errorf("Expected %d bytes to be read, got %d of %d", want, reader.read.Load(), buf.Len())
+	}
+}
+
+func TestLoadProofForInvalidInput(t *testing.T) {
+	tree, _ := NewTree(createTestDataBlocks("A", "B", "C"))
+	var buf bytes.Buffer
+	tree.WriteTo(&buf)
+	data := buf.Bytes()
+
+	if _, err := LoadProofFor(bytes.NewReader(data), 3); !errors.Is(err, ErrOutOfBoundary) {
+		t.Errorf("Expected ErrOutOfBoundary, got %v", err)
+	}
+	leavesStart := treeHeaderSize + len(tree.nodes)*treeLevelSize
+	testCases := map[string][]byte{
+		"Empty":     nil,
+		"BadMagic":  append([]byte("XXXX"), data[4:]...),
+		"Truncated": data[:leavesStart+len(tree.Root)],
+		"BadCounts": slices.Concat(data[:treeHeaderSize+8], []byte{0, 0, 0, 0, 0, 0, 0, 9}, data[treeHeaderSize+16:]),
+	}
+	for name, corrupt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadProofFor(bytes.NewReader(corrupt), 2)
+			if !errors.Is(err, ErrInvalidTreeData) {
+				t.Errorf("Expected ErrInvalidTreeData, got %v", err)
+			}
+		})
+	}
+}
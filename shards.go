@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ShardProof proves that a leaf belongs to a shard tree whose root is a leaf
+// of a tree built by CombineTrees.
+type ShardProof struct {
+	Leaf  *Proof // The leaf inside its shard, up to the shard root
+	Shard *Proof // The shard root inside the combined tree
+}
+
+// CombineTrees builds a tree over per-shard trees, taking each shard's root as
+// a leaf in shard order, without rehashing the shards' data. All shards must
+// be built with the same options, which the combined tree uses too; otherwise
+// it returns ErrShardOptionsMismatch.
+func CombineTrees(trees []*MerkleTree) (*MerkleTree, error) {
+	if len(trees) == 0 {
+		return nil, ErrZeroLeaves
+	}
+	leaves := make([][]byte, len(trees))
+	for i, tree := range trees {
+		if tree == nil {
+			return nil, ErrNilTree
+		}
+		if tree.opts.OddNodeStrategy != trees[0].opts.OddNodeStrategy || !sameLeafHashing(tree.opts, trees[0].opts) {
+			return nil, fmt.Errorf("%w: shard %d", ErrShardOptionsMismatch, i)
+		}
+		if len(tree.Root) == 0 || len(tree.Root) != len(trees[0].Root) {
+			return nil, fmt.Errorf("%w: shard %d", ErrInvalidLeafHash, i)
+		}
+		leaves[i] = slices.Clone(tree.Root)
+	}
+	return newTreeFromLeaves(leaves, trees[0].opts)
+}
+
+// GenerateShardProof returns the proof that leaf leafIndex of shard belongs
+// to t, a tree built by CombineTrees with shard at shardIndex. It returns
+// ErrShardMismatch if shard's root is not that leaf of t.
+func (t *MerkleTree) GenerateShardProof(shard *MerkleTree, shardIndex, leafIndex int) (*ShardProof, error) {
+	if t == nil || shard == nil {
+		return nil, ErrNilTree
+	}
+	shardProof, err := t.GenerateProofStruct(shardIndex)
+	if err != nil {
+		return nil, err
+	}
+	if !equalHashes(shardProof.LeafHash, shard.Root) {
+		return nil, fmt.Errorf("%w: shard %d", ErrShardMismatch, shardIndex)
+	}
+	leafProof, err := shard.GenerateProofStruct(leafIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &ShardProof{Leaf: leafProof, Shard: shardProof}, nil
+}
+
+// VerifyShardProof checks that p's leaf hashes up to its shard root, and that
+// the shard root hashes up to root, for trees built by NewTree.
+func VerifyShardProof(root []byte, p *ShardProof) (bool, error) {
+	if p == nil || p.Leaf == nil || p.Shard == nil {
+		return false, ErrInvalidProofInputs
+	}
+	if isValid, err := VerifyProofStruct(p.Shard.LeafHash, p.Leaf); err != nil || !isValid {
+		return false, err
+	}
+	return VerifyProofStruct(root, p.Shard)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCombineTrees(t *testing.T) {
+	// Shards of different sizes, so some levels have an odd node
+	var shards []*MerkleTree
+	for shard, size := range []int{4, 1, 3, 5, 2} {
+		blocks := make([][]byte, size)
+		for i := range blocks {
+			blocks[i] = fmt.Appendf(nil, "shard-%d-block-%d", shard, i)
+		}
+		tree, _ := NewTree(blocks)
+		shards = append(shards, tree)
+	}
+
+	combined, err := CombineTrees(shards)
+	if err != nil {
+		t.Fatalf("CombineTrees failed: %v", err)
+	}
+	roots := make([][]byte, len(shards))
+	for i, shard := range shards {
+		roots[i] = shard.Root
+	}
+	expected, _ := NewTreeFromLeafHashes(roots)
+	if !bytes.Equal(combined.Root, expected.Root) {
+		t.Errorf("Expected the combined root to be the tree over the shard roots in order")
+	}
+
+	for shardIndex, shard := range shards {
+		for leafIndex := range shard.Leaves {
+			proof, err := combined.GenerateShardProof(shard, shardIndex, leafIndex)
+			if err != nil {
+				t.Fatalf("%d/%d: GenerateShardProof failed: %v", shardIndex, leafIndex, err)
+			}
+			if isValid, err := VerifyShardProof(combined.Root, proof); err != nil || !isValid {
+				t.Errorf("%d/%d: expected the two-level proof to verify, got %v, %v", shardIndex, leafIndex, isValid, err)
+			}
+		}
+	}
+
+	t.Run("TamperedLeaf", func(t *testing.T) {
+		proof, _ := combined.GenerateShardProof(shards[3], 3, 2)
+		proof.Leaf.LeafHash = shards[3].Leaves[1]
+		if isValid, _ := VerifyShardProof(combined.Root, proof); isValid {
+			t.Error("Expected a different leaf to be rejected")
+		}
+	})
+
+	t.Run("WrongShard", func(t *testing.T) {
+		proof, _ := combined.GenerateShardProof(shards[0], 0, 1)
+		other, _ := combined.GenerateShardProof(shards[2], 2, 0)
+		proof.Shard = other.Shard
+		if isValid, _ := VerifyShardProof(combined.Root, proof); isValid {
+			t.Error("Expected a leaf proven against another shard's root to be rejected")
+		}
+		if _, err := combined.GenerateShardProof(shards[0], 2, 0); !errors.Is(err, ErrShardMismatch) {
+			t.Errorf("Expected ErrShardMismatch, got %v", err)
+		}
+	})
+
+	if _, err := CombineTrees(nil); !errors.Is(err, ErrZeroLeaves) {
+		t.Errorf("Expected ErrZeroLeaves, got %v", err)
+	}
+	for _, opts := range []TreeOptions{
+		{OddNodeStrategy: OddNodePromote},
+		{DomainSeparation: true},
+		{Hash: sha512.New},
+	} {
+		other, _ := NewTreeWithOptions(createTestDataBlocks("X", "Y"), opts)
+		if _, err := CombineTrees([]*MerkleTree{shards[0], other}); !errors.Is(err, ErrShardOptionsMismatch) {
+			t.Errorf("%+v: expected ErrShardOptionsMismatch, got %v", opts, err)
+		}
+	}
+	if _, err := CombineTrees([]*MerkleTree{shards[0], nil}); !errors.Is(err, ErrNilTree) {
+		t.Errorf("Expected ErrNilTree, got %v", err)
+	}
+	if _, err := VerifyShardProof(combined.Root, &ShardProof{}); !errors.Is(err, ErrInvalidProofInputs) {
+		t.Errorf("Expected ErrInvalidProofInputs, got %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/bits"
+	"slices"
+)
+
+// sparseTreeDepth is the number of levels below the root of a
+// SparseMerkleTree: one per bit of a SHA-256 key hash
+const sparseTreeDepth = 256
+
+// sparseHashOptions hashes sparse tree leaves and nodes with domain
+// separation, so a leaf can never be presented as an internal node
+var sparseHashOptions = TreeOptions{DomainSeparation: true}
+
+// sparseEmptyHashes[h] is the hash of an empty subtree of height h, with
+// empty leaves hashing to 32 zero bytes
+var sparseEmptyHashes = func() [][]byte {
+	hashes := make([][]byte, sparseTreeDepth+1)
+	hashes[0] = make([]byte, sha256.Size)
+	for h := 1; h <= sparseTreeDepth; h++ {
+		hashes[h] = sparseHashOptions.hashNode(hashes[h-1], hashes[h-1])
+	}
+	return hashes
+}()
+
+// SparseMerkleTree maps arbitrary keys to values in a fixed-depth tree with
+// one leaf per possible SHA-256 key hash. Absent keys are empty leaves, so a
+// proof can show that a key is stored with a value (VerifyMembership) or that
+// it is not stored at all (VerifyNonMembership). Only non-empty subtrees are
+// kept in memory.
+type SparseMerkleTree struct {
+	root   []byte
+	values map[string][]byte // values by key hash
+	nodes  map[string][]byte // non-empty subtree hashes by sparseNodeKey
+}
+
+// SparseProof proves the contents of one leaf of a SparseMerkleTree.
+type SparseProof struct {
+	// Siblings holds the non-empty sibling hashes from the leaf up to the root
+	Siblings [][]byte
+
+	// NonEmpty has bit h (of byte h/8, least significant first) set when the
+	// sibling at height h is in Siblings; the others are empty subtrees
+	NonEmpty []byte
+}
+
+// NewSparseMerkleTree returns an empty sparse Merkle tree.
+func NewSparseMerkleTree() *SparseMerkleTree {
+	return &SparseMerkleTree{
+		root:   sparseEmptyHashes[sparseTreeDepth],
+		values: make(map[string][]byte),
+		nodes:  make(map[string][]byte),
+	}
+}
+
+// Root returns the root hash. Trees holding the same pairs have the same
+// root, whatever order they were updated in.
+func (s *SparseMerkleTree) Root() []byte {
+	if s == nil {
+		return nil
+	}
+	return slices.Clone(s.root)
+}
+
+// Get returns the value stored for key.
+func (s *SparseMerkleTree) Get(key []byte) ([]byte, bool) {
+	if s == nil {
+		return nil, false
+	}
+	path := sha256.Sum256(key)
+	value, ok := s.values[string(path[:])]
+	return slices.Clone(value), ok
+}
+
+// Update stores value for key, replacing any previous value, and rehashes
+// the key's path to the root. An empty value removes the key.
+func (s *SparseMerkleTree) Update(key, value []byte) error {
+	if s == nil {
+		return ErrNilTree
+	}
+	path := sha256.Sum256(key)
+	node := sparseEmptyHashes[0]
+	if len(value) == 0 {
+		delete(s.values, string(path[:]))
+	} else {
+		s.values[string(path[:])] = slices.Clone(value)
+		node = sparseLeafHash(path, value)
+	}
+
+	for height := 0; height < sparseTreeDepth; height++ {
+		if bytes.Equal(node, sparseEmptyHashes[height]) {
+			delete(s.nodes, sparseNodeKey(path, height))
+		} else {
+			s.nodes[sparseNodeKey(path, height)] = node
+		}
+		sibling := s.node(sparseSiblingPath(path, height), height)
+		if sparseIsRight(path, height) {
+			node = sparseHashOptions.hashNode(sibling, node)
+		} else {
+			node = sparseHashOptions.hashNode(node, sibling)
+		}
+	}
+	s.root = node
+	return nil
+}
+
+// Prove returns the proof for key's leaf, which verifies with
+// VerifyMembership if key is stored and with VerifyNonMembership otherwise.
+func (s *SparseMerkleTree) Prove(key []byte) (*SparseProof, error) {
+	if s == nil {
+		return nil, ErrNilTree
+	}
+	path := sha256.Sum256(key)
+	proof := &SparseProof{NonEmpty: make([]byte, sparseTreeDepth/8)}
+	for height := 0; height < sparseTreeDepth; height++ {
+		sibling := s.node(sparseSiblingPath(path, height), height)
+		if !bytes.Equal(sibling, sparseEmptyHashes[height]) {
+			proof.NonEmpty[height/8] |= 1 << (height % 8)
+			proof.Siblings = append(proof.Siblings, slices.Clone(sibling))
+		}
+	}
+	return proof, nil
+}
+
+// VerifyMembership checks that key is stored with value in the sparse tree
+// with the given root.
+func VerifyMembership(root []byte, key, value []byte, proof *SparseProof) (bool, error) {
+	if len(value) == 0 {
+		return false, ErrInvalidProofInputs
+	}
+	path := sha256.Sum256(key)
+	return verifySparseProof(root, path, sparseLeafHash(path, value), proof)
+}
+
+// VerifyNonMembership checks that key is not stored in the sparse tree with
+// the given root.
+func VerifyNonMembership(root []byte, key []byte, proof *SparseProof) (bool, error) {
+	return verifySparseProof(root, sha256.Sum256(key), sparseEmptyHashes[0], proof)
+}
+
+// verifySparseProof hashes leaf up the path through the proof's siblings
+// and compares the result to root
+func verifySparseProof(root []byte, path [sha256.Size]byte, leaf []byte, proof *SparseProof) (bool, error) {
+	if len(root) == 0 || proof == nil {
+		return false, ErrInvalidProofInputs
+	}
+	if len(proof.NonEmpty) != sparseTreeDepth/8 {
+		return false, nil
+	}
+	nonEmpty := 0
+	for _, b := range proof.NonEmpty {
+		nonEmpty += bits.OnesCount8(b)
+	}
+	if nonEmpty != len(proof.Siblings) {
+		return false, nil
+	}
+
+	node := leaf
+	siblings := proof.Siblings
+	for height := 0; height < sparseTreeDepth; height++ {
+		sibling := sparseEmptyHashes[height]
+		if proof.NonEmpty[height/8]&(1<<(height%8)) != 0 {
+			sibling, siblings = siblings[0], siblings[1:]
+			if len(sibling) == 0 {
+				return false, ErrInvalidProof
+			}
+		}
+		if sparseIsRight(path, height) {
+			node = sparseHashOptions.hashNode(sibling, node)
+		} else {
+			node = sparseHashOptions.hashNode(node, sibling)
+		}
+	}
+	return equalHashes(node, root), nil
+}
+
+// sparseLeafHash hashes a stored pair, binding the value to its key hash
+func sparseLeafHash(path [sha256.Size]byte, value []byte) []byte {
+	return sparseHashOptions.hashLeaf(kvLeafData(path[:], value))
+}
+
+// node returns the hash of the subtree of the given height containing path
+func (s *SparseMerkleTree) node(path [sha256.Size]byte, height int) []byte {
+	if node, ok := s.nodes[sparseNodeKey(path, height)]; ok {
+		return node
+	}
+	return sparseEmptyHashes[height]
+}
+
+// sparseNodeKey identifies the subtree of the given height containing path
+// by its height and the leading path bits shared by all its leaves
+func sparseNodeKey(path [sha256.Size]byte, height int) string {
+	prefixBits := sparseTreeDepth - height
+	key := make([]byte, 2+sha256.Size)
+	key[0], key[1] = byte(height>>8), byte(height)
+	copy(key[2:], path[:prefixBits/8])
+	if prefixBits%8 != 0 {
+		key[2+prefixBits/8] = path[prefixBits/8] & (0xff << (8 - prefixBits%8))
+	}
+	return string(key)
+}
+
+// sparseBit returns the index of the path bit that decides whether the
+// subtree of the given height is its parent's left or right child
+func sparseBit(height int) (byteIndex int, mask byte) {
+	depth := sparseTreeDepth - 1 - height
+	return depth / 8, 1 << (7 - depth%8)
+}
+
+// sparseIsRight reports whether the subtree of the given height containing
+// path is a right child
+func sparseIsRight(path [sha256.Size]byte, height int) bool {
+	byteIndex, mask := sparseBit(height)
+	return path[byteIndex]&mask != 0
+}
+
+// sparseSiblingPath returns a path inside the sibling of the subtree of the
+// given height containing path
+func sparseSiblingPath(path [sha256.Size]byte, height int) [sha256.Size]byte {
+	byteIndex, mask := sparseBit(height)
+	path[byteIndex] ^= mask
+	return path
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestSparseMerkleTreeMembership(t *testing.T) {
+	tree := NewSparseMerkleTree()
+	for i := range 20 {
+		if err := tree.Update(fmt.Appendf(nil, "key-%d", i), fmt.Appendf(nil, "value-%d", i)); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+	}
+	root := tree.Root()
+
+	for i := range 20 {
+		key := fmt.Appendf(nil, "key-%d", i)
+		proof, err := tree.Prove(key)
+		if err != nil {
+			t.Fatalf("Prove failed: %v", err)
+		}
+		if ok, err := VerifyMembership(root, key, fmt.Appendf(nil, "value-%d", i), proof); err != nil || !ok {
+			t.Errorf("Expected %s to be a member, got %v, %v", key, ok, err)
+		}
+		if ok, _ := VerifyMembership(root, key, []byte("other"), proof); ok {
+			t.Errorf("Expected a wrong value for %s not to verify", key)
+		}
+		if ok, _ := VerifyNonMembership(root, key, proof); ok {
+			t.Errorf("Expected non-membership of stored %s not to verify", key)
+		}
+	}
+
+	// A proof only holds for the key it was generated for
+	proof, _ := tree.Prove([]byte("key-0"))
+	if ok, _ := VerifyMembership(root, []byte("key-1"), []byte("value-0"), proof); ok {
+		t.Error("Expected a proof to fail for another key")
+	}
+
+	tampered, _ := tree.Prove([]byte("key-3"))
+	tampered.Siblings[0] = bytes.Repeat([]byte{0xaa}, 32)
+	if ok, _ := VerifyMembership(root, []byte("key-3"), []byte("value-3"), tampered); ok {
+		t.Error("Expected a tampered proof not to verify")
+	}
+	tampered.Siblings = tampered.Siblings[1:]
+	if ok, err := VerifyMembership(root, []byte("key-3"), []byte("value-3"), tampered); ok || err != nil {
+		t.Errorf("Expected a truncated proof to be rejected, got %v, %v", ok, err)
+	}
+}
+
+func TestSparseMerkleTreeNonMembership(t *testing.T) {
+	tree := NewSparseMerkleTree()
+	_ = tree.Update([]byte("present"), []byte("yes"))
+	root := tree.Root()
+
+	proof, err := tree.Prove([]byte("absent"))
+	if err != nil {
+		t.Fatalf("Prove failed: %v", err)
+	}
+	if ok, err := VerifyNonMembership(root, []byte("absent"), proof); err != nil || !ok {
+		t.Errorf("Expected absent key to verify as non-member, got %v, %v", ok, err)
+	}
+	if ok, _ := VerifyMembership(root, []byte("absent"), []byte("yes"), proof); ok {
+		t.Error("Expected membership of an absent key not to verify")
+	}
+
+	empty := NewSparseMerkleTree()
+	proof, _ = empty.Prove([]byte("anything"))
+	if len(proof.Siblings) != 0 {
+		t.Errorf("Expected an empty tree's proof to have no siblings, got %d", len(proof.Siblings))
+	}
+	if ok, err := VerifyNonMembership(empty.Root(), []byte("anything"), proof); err != nil || !ok {
+		t.Errorf("Expected non-membership in an empty tree, got %v, %v", ok, err)
+	}
+
+	if _, err := VerifyNonMembership(root, []byte("absent"), nil); !errors.Is(err, ErrInvalidProofInputs) {
+		t.Errorf("Expected ErrInvalidProofInputs for a nil proof, got %v", err)
+	}
+	if _, err := VerifyMembership(root, []byte("present"), nil, proof); !errors.Is(err, ErrInvalidProofInputs) {
+		t.Errorf("Expected ErrInvalidProofInputs for an empty value, got %v", err)
+	}
+}
+
+func TestSparseMerkleTreeUpdates(t *testing.T) {
+	keys := []string{"alpha", "beta", "gamma", "delta"}
+	build := func(order []string) *SparseMerkleTree {
+		tree := NewSparseMerkleTree()
+		for _, key := range order {
+			_ = tree.Update([]byte(key), []byte("v-"+key))
+		}
+		return tree
+	}
+
+	tree := build(keys)
+	reversed := slices.Clone(keys)
+	slices.Reverse(reversed)
+	if !bytes.Equal(tree.Root(), build(reversed).Root()) {
+		t.Error("Expected the root not to depend on update order")
+	}
+
+	before := tree.Root()
+	_ = tree.Update([]byte("beta"), []byte("changed"))
+	if bytes.Equal(tree.Root(), before) {
+		t.Error("Expected overwriting a value to change the root")
+	}
+	if value, ok := tree.Get([]byte("beta")); !ok || string(value) != "changed" {
+		t.Errorf("Expected beta to be changed, got %q, %v", value, ok)
+	}
+	_ = tree.Update([]byte("beta"), []byte("v-beta"))
+	if !bytes.Equal(tree.Root(), before) {
+		t.Error("Expected restoring a value to restore the root")
+	}
+
+	// Removing keys leaves exactly the tree that never had them
+	_ = tree.Update([]byte("gamma"), nil)
+	_ = tree.Update([]byte("delta"), nil)
+	if !bytes.Equal(tree.Root(), build(keys[:2]).Root()) {
+		t.Error("Expected removals to match a tree built without the keys")
+	}
+	if _, ok := tree.Get([]byte("gamma")); ok {
+		t.Error("Expected gamma to be removed")
+	}
+	proof, _ := tree.Prove([]byte("gamma"))
+	if ok, _ := VerifyNonMembership(tree.Root(), []byte("gamma"), proof); !ok {
+		t.Error("Expected a removed key to verify as non-member")
+	}
+	_ = tree.Update([]byte("alpha"), nil)
+	_ = tree.Update([]byte("beta"), nil)
+	if !bytes.Equal(tree.Root(), NewSparseMerkleTree().Root()) || len(tree.nodes) != 0 {
+		t.Errorf("Expected an emptied tree to match a new one and keep no nodes, got %d", len(tree.nodes))
+	}
+
+	var nilTree *SparseMerkleTree
+	if err := nilTree.Update([]byte("k"), []byte("v")); !errors.Is(err, ErrNilTree) {
+		t.Errorf("Expected ErrNilTree, got %v", err)
+	}
+}
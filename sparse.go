@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+)
+
+// sparseBlockSize is the granularity at which zero runs become holes
+const sparseBlockSize = 4096
+
+// copySparseFile copies src to dst like copyFile, but skips over blocks that are
+// entirely zero with Seek so the filesystem can leave holes instead of
+// allocating them. The file is truncated to the full size at the end, which
+// also recreates a trailing hole.
+func copySparseFile(src, dst string, mode os.FileMode) error {
+	return copySparseFileContext(context.Background(), src, dst, mode)
+}
+
+// copySparseFileContext copies like copySparseFile, stopping with ctx.Err()
+// once ctx is cancelled
+func copySparseFileContext(ctx context.Context, src, dst string, mode os.FileMode) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	destFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	source := &contextReader{ctx: ctx, r: sourceFile}
+	block := make([]byte, sparseBlockSize)
+	zeros := make([]byte, sparseBlockSize)
+	var size int64
+	for {
+		n, err := io.ReadFull(source, block)
+		if n > 0 {
+			if bytes.Equal(block[:n], zeros[:n]) {
+				if _, err := destFile.Seek(int64(n), io.SeekCurrent); err != nil {
+					return err
+				}
+			} else if _, err := destFile.Write(block[:n]); err != nil {
+				return err
+			}
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := destFile.Truncate(size); err != nil {
+		return err
+	}
+
+	// Copy file permissions
+	if mode == 0 {
+		sourceInfo, err := sourceFile.Stat()
+		if err != nil {
+			return err
+		}
+		mode = sourceInfo.Mode()
+	}
+	return os.Chmod(dst, mode)
+}
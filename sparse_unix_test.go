@@ -0,0 +1,60 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// allocatedBytes returns the disk space actually allocated to a file
+func allocatedBytes(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	return info.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+func TestSyncPreserveSparse(t *testing.T) {
+	src := t.TempDir()
+	srcPath := filepath.Join(src, "disk.img")
+	const apparentSize = 8 << 20
+
+	file, err := os.Create(srcPath)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	file.Write([]byte("header"))
+	file.WriteAt([]byte("middle"), apparentSize/2)
+	file.Truncate(apparentSize)
+	file.Close()
+	if allocatedBytes(t, srcPath) >= apparentSize {
+		t.Skip("Filesystem does not support sparse files")
+	}
+
+	dst := t.TempDir()
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, PreserveSparse: true}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	dstPath := filepath.Join(dst, "disk.img")
+	info, err := os.Stat(dstPath)
+	if err != nil {
+		t.Fatalf("Destination missing: %v", err)
+	}
+	if info.Size() != apparentSize {
+		t.Errorf("Expected apparent size %d, got %d", apparentSize, info.Size())
+	}
+	if allocated := allocatedBytes(t, dstPath); allocated > 1<<20 {
+		t.Errorf("Expected destination to stay sparse, %d bytes allocated", allocated)
+	}
+	if !bytes.Equal(dirRoot(t, src), dirRoot(t, dst)) {
+		t.Errorf("Destination root differs from source root after sparse copy")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// symlinkHashPrefix separates link hashes from the hash of a file whose
+// contents happen to equal the link target
+const symlinkHashPrefix = "symlink:"
+
+// isSymlink reports whether an entry was recorded as a symbolic link
+func isSymlink(file FileInfo) bool {
+	return file.Mode&os.ModeSymlink != 0
+}
+
+// hashSymlink hashes the target of the link at path without following it
+func hashSymlink(path string) ([]byte, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return nil, err
+	}
+	return hashLinkTarget(target), nil
+}
+
+// hashLinkTarget hashes a link target the way hashSymlink does
+func hashLinkTarget(target string) []byte {
+	hash := sha256.Sum256([]byte(symlinkHashPrefix + filepath.ToSlash(target)))
+	return hash[:]
+}
+
+// copySymlink recreates the link at src as dst with the same target,
+// replacing whatever dst currently is
+func copySymlink(src, dst string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	return os.Symlink(target, dst)
+}
+
+// linkStaysInside reports whether a link created at linkPath with target
+// resolves, without following other links, to a path inside root
+func linkStaysInside(root, linkPath, target string) bool {
+	if filepath.IsAbs(target) {
+		return false
+	}
+	rel, err := filepath.Rel(root, filepath.Join(filepath.Dir(linkPath), target))
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// checkLinkFreeParents returns ErrUnsafePath if a directory between root and
+// the entry at the slash-separated relPath is a symbolic link, since writing
+// the entry would follow it, possibly out of root
+func checkLinkFreeParents(root, relPath string) error {
+	parent := path.Dir(relPath)
+	if parent == "." {
+		return nil
+	}
+	dir := root
+	for _, part := range strings.Split(parent, "/") {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is below the link %s", ErrUnsafePath, relPath, filepath.ToSlash(part))
+		}
+	}
+	return nil
+}
+
+// symlinkLoops reports whether following a link to the directory target would
+// revisit a directory it was reached through. linkDir is the resolved directory
+// holding the link and ancestors the resolved directories of the links already
+// followed to reach it.
+func symlinkLoops(target, linkDir string, ancestors []string) bool {
+	for _, dir := range append(ancestors, linkDir) {
+		if dir == target || strings.HasPrefix(dir, target+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
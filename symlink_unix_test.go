@@ -0,0 +1,366 @@
+//go:build unix
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// createSymlinkFixture builds a tree with a link to a file, a link to a
+// directory, a self-referencing link and a link back to the root
+func createSymlinkFixture(t *testing.T) string {
+	t.Helper()
+	dir := createTestDir(t, map[string]string{"file.txt": "F", "sub/inner.txt": "I"})
+	links := map[string]string{
+		"file-link": "file.txt",
+		"sub-link":  "sub",
+		"self-link": "self-link",
+		"sub/up":    "..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Failed to create link %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestBuildDirectoryTreeRecordsSymlinks(t *testing.T) {
+	dir := createSymlinkFixture(t)
+	ds := &DirectorySync{}
+	files, err := ds.BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+
+	want := []string{"file-link", "file.txt", "self-link", "sub", "sub-link", "sub/inner.txt", "sub/up"}
+	if got := filePaths(files); !slices.Equal(got, want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	byPath := make(map[string]FileInfo)
+	for _, file := range files {
+		byPath[file.Path] = file
+	}
+	for _, name := range []string{"file-link", "sub-link", "self-link", "sub/up"} {
+		if !isSymlink(byPath[name]) || byPath[name].Hash == nil {
+			t.Errorf("Expected %s to be recorded as a hashed link, got %+v", name, byPath[name])
+		}
+	}
+	if slices.Equal(byPath["file-link"].Hash, byPath["file.txt"].Hash) {
+		t.Errorf("Expected a link to be hashed by its target, not the target's contents")
+	}
+
+	// Links are recreated as links, so the destination gets the same root
+	dst := t.TempDir()
+	syncer := &DirectorySync{SourceDir: dir, DestinationDir: dst, VerifyOnComplete: true}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "sub-link")); err != nil || target != "sub" {
+		t.Errorf("Expected sub-link to point to sub, got %q, %v", target, err)
+	}
+	if ok, err := Scrub(dst, dirRoot(t, dir)); err != nil || !ok {
+		t.Errorf("Expected Scrub to accept the synced tree, got %v, %v", ok, err)
+	}
+}
+
+func TestBuildDirectoryTreeFollowSymlinks(t *testing.T) {
+	dir := createSymlinkFixture(t)
+	ds := &DirectorySync{FollowSymlinks: true}
+	files, err := ds.BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+
+	// The dangling self link stays a link and both links back to the root are cut
+	want := []string{"file-link", "file.txt", "self-link", "sub", "sub-link", "sub-link/inner.txt", "sub/inner.txt"}
+	if got := filePaths(files); !slices.Equal(got, want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	slices.Sort(ds.DirectoryLoops)
+	if !slices.Equal(ds.DirectoryLoops, []string{"sub-link/up", "sub/up"}) {
+		t.Errorf("Expected both links to the root to be reported as loops, got %v", ds.DirectoryLoops)
+	}
+
+	byPath := make(map[string]FileInfo)
+	for _, file := range files {
+		byPath[file.Path] = file
+	}
+	if isSymlink(byPath["file-link"]) || !slices.Equal(byPath["file-link"].Hash, byPath["file.txt"].Hash) {
+		t.Errorf("Expected a followed file link to be hashed by its contents")
+	}
+	if !byPath["sub-link"].IsDir {
+		t.Errorf("Expected a followed directory link to be recorded as a directory")
+	}
+	if !isSymlink(byPath["self-link"]) {
+		t.Errorf("Expected the self-referencing link to be recorded as a link")
+	}
+}
+
+// serveFakeRemote answers remote sync requests on conn with a crafted listing
+// of files, whose contents and link targets come from contents
+func serveFakeRemote(conn net.Conn, files []FileInfo, contents map[string]string) {
+	defer conn.Close()
+	tree, _ := (&DirectorySync{}).BuildMerkleTree(files)
+	dec, enc := gob.NewDecoder(conn), gob.NewEncoder(conn)
+	for {
+		var req remoteRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		switch req.Op {
+		case remoteOpRoot:
+			enc.Encode(remoteResponse{Root: tree.Root})
+		case remoteOpList:
+			enc.Encode(remoteResponse{Files: files})
+		case remoteOpFile:
+			if strings.HasSuffix(req.Path, ".txt") {
+				enc.Encode(remoteResponse{Data: []byte(contents[req.Path])})
+				enc.Encode(remoteResponse{Done: true})
+			} else {
+				enc.Encode(remoteResponse{Target: contents[req.Path], Done: true})
+			}
+		}
+	}
+}
+
+func TestSyncFromRemoteRejectsEscapingLinks(t *testing.T) {
+	outside := t.TempDir()
+	payload := sha256.Sum256([]byte("owned"))
+	testCases := []struct {
+		name   string
+		target string
+	}{
+		{"AbsoluteTarget", outside},
+		{"RelativeTargetLeavingRoot", "../" + filepath.Base(outside)},
+		{"TargetInsideRoot", "sub"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// outside must be a sibling of the local directory for the relative target
+			local := filepath.Join(filepath.Dir(outside), "local-"+tc.name)
+			if err := os.Mkdir(local, 0755); err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(local)
+
+			files := []FileInfo{
+				{Path: "evil", Mode: os.ModeSymlink | 0777, Hash: hashLinkTarget(tc.target)},
+				{Path: "evil/owned.txt", Mode: 0644, Size: 5, Hash: payload[:]},
+				{Path: "sub", Mode: os.ModeDir | 0755, IsDir: true},
+			}
+			client, server := net.Pipe()
+			defer client.Close()
+			go serveFakeRemote(server, files, map[string]string{"evil": tc.target, "evil/owned.txt": "owned"})
+
+			err := SyncFromRemote(local, client)
+			if err == nil || !strings.Contains(err.Error(), ErrUnsafePath.Error()) {
+				t.Errorf("Expected ErrUnsafePath, got %v", err)
+			}
+			for _, path := range []string{filepath.Join(outside, "owned.txt"), filepath.Join(local, "sub", "owned.txt")} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("Expected nothing written through the link at %s, got %v", path, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncReplacesEntriesChangingType(t *testing.T) {
+	t.Run("DirectoryBecomesLink", func(t *testing.T) {
+		src := createTestDir(t, map[string]string{"sub/x.txt": "X"})
+		if err := os.Symlink("sub", filepath.Join(src, "a")); err != nil {
+			t.Fatal(err)
+		}
+		dst := createTestDir(t, map[string]string{"a/x.txt": "old", "sub/x.txt": "X"})
+
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+		if data, err := os.ReadFile(filepath.Join(dst, "sub", "x.txt")); err != nil || string(data) != "X" {
+			t.Errorf("Expected sub/x.txt to survive the replacement, got %q, %v", data, err)
+		}
+		if info, err := os.Lstat(filepath.Join(dst, "a")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Expected a to become a link, got %v", err)
+		}
+		if !slices.Equal(dirRoot(t, dst), dirRoot(t, src)) {
+			t.Error("Expected the synced roots to match")
+		}
+	})
+
+	t.Run("LinkBecomesDirectory", func(t *testing.T) {
+		outside := t.TempDir()
+		src := createTestDir(t, map[string]string{"a/x.txt": "X"})
+		dst := t.TempDir()
+		if err := os.Symlink(outside, filepath.Join(dst, "a")); err != nil {
+			t.Fatal(err)
+		}
+
+		ds := &DirectorySync{}
+		sourceFiles, _ := ds.BuildDirectoryTree(src)
+		destFiles, _ := ds.BuildDirectoryTree(dst)
+		diff, _ := ds.CompareTreesDetailed(sourceFiles, destFiles)
+		if !slices.Equal(filePaths(diff.Modified), []string{"a"}) {
+			t.Errorf("Expected the type change to be reported as modified, got %v", filePaths(diff.Modified))
+		}
+
+		syncer := &DirectorySync{SourceDir: src, DestinationDir: dst}
+		if err := syncer.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(outside, "x.txt")); !os.IsNotExist(err) {
+			t.Errorf("Expected nothing copied through the old link, got %v", err)
+		}
+		if info, err := os.Lstat(filepath.Join(dst, "a")); err != nil || !info.IsDir() {
+			t.Errorf("Expected a to become a directory, got %v", err)
+		}
+		if !slices.Equal(dirRoot(t, dst), dirRoot(t, src)) {
+			t.Error("Expected the synced roots to match")
+		}
+	})
+
+	t.Run("WriteBelowLink", func(t *testing.T) {
+		outside := t.TempDir()
+		dst := t.TempDir()
+		if err := os.Symlink(outside, filepath.Join(dst, "a")); err != nil {
+			t.Fatal(err)
+		}
+		syncer := &DirectorySync{SourceDir: t.TempDir(), DestinationDir: dst}
+		if err := syncer.copyToDestination(context.Background(), FileInfo{Path: "a/x.txt", Mode: 0644}); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("Expected ErrUnsafePath, got %v", err)
+		}
+	})
+}
+
+func TestExportChecksumsSkipsSymlinks(t *testing.T) {
+	src := createSymlinkFixture(t)
+	var buf bytes.Buffer
+	syncer := &DirectorySync{SourceDir: src}
+	if err := syncer.ExportChecksums(&buf); err != nil {
+		t.Fatalf("ExportChecksums failed: %v", err)
+	}
+
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		paths = append(paths, line[strings.Index(line, "  ")+2:])
+	}
+	if want := []string{"file.txt", "sub/inner.txt"}; !slices.Equal(paths, want) {
+		t.Errorf("Expected checksums for %v only, got %v", want, paths)
+	}
+}
+
+func TestExportAndApplyPatchWithSymlinks(t *testing.T) {
+	src := createSymlinkFixture(t)
+	if err := os.Remove(filepath.Join(src, "self-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing.txt", filepath.Join(src, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	dst := createTestDir(t, map[string]string{"file-link": "was a file"})
+
+	var patch bytes.Buffer
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst}
+	if err := syncer.ExportPatch(&patch); err != nil {
+		t.Fatalf("ExportPatch failed: %v", err)
+	}
+	if err := ApplyPatch(dst, &patch); err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	for _, name := range []string{"file-link", "sub-link", "sub/up", "dangling"} {
+		want, _ := os.Readlink(filepath.Join(src, name))
+		if got, err := os.Readlink(filepath.Join(dst, name)); err != nil || got != want {
+			t.Errorf("Expected %s to link to %s, got %q, %v", name, want, got, err)
+		}
+	}
+	if !slices.Equal(dirRoot(t, dst), dirRoot(t, src)) {
+		t.Error("Expected the patched root to match the source")
+	}
+}
+
+func TestApplyPatchRejectsUnsafeLinks(t *testing.T) {
+	writePatch := func(manifest string, headers ...*tar.Header) *bytes.Buffer {
+		var patch bytes.Buffer
+		tw := tar.NewWriter(&patch)
+		if err := writeTarEntry(tw, patchManifestName, 0644, int64(len(manifest)), strings.NewReader(manifest)); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+		for _, header := range headers {
+			if err := tw.WriteHeader(header); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tw.Close()
+		return &patch
+	}
+
+	t.Run("EscapingTarget", func(t *testing.T) {
+		patch := writePatch(fmt.Sprintf(`{"files":[{"path":"out","mode":%d}]}`, os.ModeSymlink|0777),
+			&tar.Header{Name: patchFilePrefix + "out", Linkname: "../outside", Typeflag: tar.TypeSymlink})
+		if err := ApplyPatch(t.TempDir(), patch); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("Expected ErrUnsafePath, got %v", err)
+		}
+	})
+
+	t.Run("WriteBelowLink", func(t *testing.T) {
+		outside := t.TempDir()
+		dst := t.TempDir()
+		if err := os.Symlink(outside, filepath.Join(dst, "a")); err != nil {
+			t.Fatal(err)
+		}
+		patch := writePatch(`{"directories":[{"path":"a/sub","mode":493}]}`)
+		if err := ApplyPatch(dst, patch); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("Expected ErrUnsafePath, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(outside, "sub")); !os.IsNotExist(err) {
+			t.Errorf("Expected nothing to be created outside the destination, got %v", err)
+		}
+	})
+}
+
+func TestMergeLeavesTypeConflictsAlone(t *testing.T) {
+	outside := t.TempDir()
+	src := createTestDir(t, map[string]string{"a.txt": "A"})
+	if err := os.Symlink(outside, filepath.Join(src, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	dst := createTestDir(t, map[string]string{"sub/evil.txt": "E"})
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, Mode: SyncMerge}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if !slices.Equal(syncer.Conflicts, []string{"sub"}) {
+		t.Errorf("Expected sub to be reported as a conflict, got %v", syncer.Conflicts)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "evil.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written through the source link, got %v", err)
+	}
+	if info, err := os.Lstat(filepath.Join(dst, "sub")); err != nil || !info.IsDir() {
+		t.Errorf("Expected the destination directory to be kept, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "sub", "evil.txt")); err != nil {
+		t.Errorf("Expected sub/evil.txt to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "a.txt")); err != nil {
+		t.Errorf("Expected a.txt to be merged: %v", err)
+	}
+
+	err := syncer.copyToSource(context.Background(), FileInfo{Path: "sub/evil.txt", Mode: 0644})
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Expected ErrUnsafePath writing below a source link, got %v", err)
+	}
+}
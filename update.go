@@ -0,0 +1,75 @@
+package main
+
+// AppendLeaf hashes dataBlock, adds it as the last leaf and updates only the
+// nodes on its path to the root, keeping the tree (and its proofs) identical
+// to one built from scratch with the same blocks. Each append costs
+// O(log n) hashes, plus an occasional O(n) copy when a level's backing array
+// grows, which amortizes to O(1) per append.
+func (t *MerkleTree) AppendLeaf(dataBlock []byte) error {
+	if t == nil {
+		return ErrNilTree
+	}
+	if len(t.nodes) == 0 {
+		return ErrZeroLeaves
+	}
+	t.nodes[0] = append(t.nodes[0], t.opts.hashLeaf(dataBlock))
+	t.Leaves = t.nodes[0]
+	t.recomputePath(len(t.Leaves) - 1)
+	return nil
+}
+
+// UpdateLeaf replaces the data block at index and rehashes only the nodes on
+// its path to the root, costing O(log n) hashes instead of a full rebuild.
+func (t *MerkleTree) UpdateLeaf(index int, newDataBlock []byte) error {
+	if t == nil {
+		return ErrNilTree
+	}
+	if index < 0 || index >= len(t.Leaves) {
+		return ErrOutOfBoundary
+	}
+	// Leaves and nodes[0] share their backing array
+	t.nodes[0][index] = t.opts.hashLeaf(newDataBlock)
+	t.Leaves = t.nodes[0]
+	t.recomputePath(index)
+	return nil
+}
+
+// recomputePath rehashes the ancestors of the leaf at index, adding nodes and
+// levels when the leaf is new.
+func (t *MerkleTree) recomputePath(index int) {
+	if t.leafIndexesMu != nil {
+		t.leafIndexesMu.Lock()
+		t.leafIndexes = nil
+		t.leafIndexesMu.Unlock()
+	}
+
+	level := 0
+	for ; len(t.nodes[level]) > 1; level++ {
+		parentIndex := index / 2
+		parent := t.parentNode(level, parentIndex)
+		if level+1 == len(t.nodes) {
+			t.nodes = append(t.nodes, nil)
+		}
+		if parentIndex < len(t.nodes[level+1]) {
+			t.nodes[level+1][parentIndex] = parent
+		} else {
+			t.nodes[level+1] = append(t.nodes[level+1], parent)
+		}
+		index = parentIndex
+	}
+	t.Root = t.nodes[level][0]
+}
+
+// parentNode computes the node at parentIndex on the level above level,
+// following the tree's odd node strategy.
+func (t *MerkleTree) parentNode(level, parentIndex int) []byte {
+	children := t.nodes[level]
+	left := children[2*parentIndex]
+	if 2*parentIndex+1 < len(children) {
+		return t.opts.hashNode(left, children[2*parentIndex+1])
+	}
+	if t.opts.OddNodeStrategy == OddNodePromote {
+		return left
+	}
+	return t.opts.hashNode(left, left)
+}
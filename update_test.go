@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestAppendLeaf(t *testing.T) {
+	for _, opts := range []TreeOptions{{}, {OddNodeStrategy: OddNodePromote, DomainSeparation: true}} {
+		t.Run(opts.OddNodeStrategy.String(), func(t *testing.T) {
+			blocks := [][]byte{[]byte("block 0")}
+			tree, _ := NewTreeWithOptions(blocks, opts)
+
+			for size := 2; size <= 20; size++ {
+				block := []byte(fmt.Sprintf("block %d", size-1))
+				blocks = append(blocks, block)
+				if err := tree.AppendLeaf(block); err != nil {
+					t.Fatalf("AppendLeaf failed: %v", err)
+				}
+
+				fresh, _ := NewTreeWithOptions(blocks, opts)
+				if !bytes.Equal(tree.Root, fresh.Root) {
+					t.Fatalf("Size %d: appended root differs from a fresh build", size)
+				}
+				if len(tree.nodes) != len(fresh.nodes) {
+					t.Fatalf("Size %d: expected %d levels, got %d", size, len(fresh.nodes), len(tree.nodes))
+				}
+				for i := range size {
+					proof, leafHash, err := tree.GenerateProof(i)
+					if err != nil {
+						t.Fatalf("GenerateProof(%d) failed: %v", i, err)
+					}
+					isValid, err := VerifyProofWithOptions(tree.Root, proof, leafHash, i, size, opts)
+					if err != nil || !isValid {
+						t.Errorf("Size %d: expected leaf %d to verify, got %v, %v", size, i, isValid, err)
+					}
+				}
+			}
+		})
+	}
+
+	var nilTree *MerkleTree
+	if err := nilTree.AppendLeaf([]byte("x")); !errors.Is(err, ErrNilTree) {
+		t.Errorf("Expected ErrNilTree, got %v", err)
+	}
+}
+
+func TestUpdateLeaf(t *testing.T) {
+	blocks := createTestDataBlocks("A", "B", "C", "D", "E")
+	for index := range blocks {
+		tree, _ := NewTree(blocks)
+		if err := tree.UpdateLeaf(index, []byte("changed")); err != nil {
+			t.Fatalf("UpdateLeaf(%d) failed: %v", index, err)
+		}
+
+		modified := slices.Clone(blocks)
+		modified[index] = []byte("changed")
+		fresh, _ := NewTree(modified)
+		if !bytes.Equal(tree.Root, fresh.Root) {
+			t.Errorf("Index %d: updated root differs from a fresh build", index)
+		}
+		if !slices.EqualFunc(tree.Leaves, fresh.Leaves, bytes.Equal) {
+			t.Errorf("Index %d: updated leaves differ from a fresh build", index)
+		}
+		proof, leafHash, _ := tree.GenerateProof(index)
+		if isValid, _ := VerifyProof(tree.Root, proof, leafHash, index); !isValid {
+			t.Errorf("Index %d: expected the updated leaf to verify", index)
+		}
+	}
+
+	tree, _ := NewTree(blocks)
+	for _, index := range []int{-1, len(blocks)} {
+		if err := tree.UpdateLeaf(index, []byte("x")); !errors.Is(err, ErrOutOfBoundary) {
+			t.Errorf("Expected ErrOutOfBoundary for %d, got %v", index, err)
+		}
+	}
+}
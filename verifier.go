@@ -0,0 +1,98 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+)
+
+// Verifier verifies proofs for trees built with a fixed set of options and can
+// memoize successful verifications, so repeated checks of the same
+// (root, leaf, index) claim skip the hashing. Failed verifications are never
+// cached. A Verifier is safe for concurrent use.
+type Verifier struct {
+	opts TreeOptions
+
+	mu        sync.Mutex
+	cacheSize int
+	order     *list.List // most recently used keys at the front
+	entries   map[[sha256.Size]byte]*list.Element
+	hits      atomic.Int64
+}
+
+// NewVerifier creates a Verifier for trees built with opts. cacheSize bounds how
+// many successful verifications are remembered; zero disables caching.
+func NewVerifier(opts TreeOptions, cacheSize int) *Verifier {
+	return &Verifier{
+		opts:      opts,
+		cacheSize: max(cacheSize, 0),
+		order:     list.New(),
+		entries:   make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+// VerifyProof verifies a proof like VerifyProofWithOptions, answering from the
+// cache when the same root, leaf hash, index and tree size verified before.
+func (v *Verifier) VerifyProof(expectedRoot []byte, proofPath [][]byte, leafHash []byte, leafIndex int, treeSize int) (bool, error) {
+	key := verificationKey(expectedRoot, leafHash, leafIndex, treeSize)
+	if v.lookup(key) {
+		v.hits.Add(1)
+		return true, nil
+	}
+
+	isValid, err := VerifyProofWithOptions(expectedRoot, proofPath, leafHash, leafIndex, treeSize, v.opts)
+	if err != nil || !isValid {
+		return isValid, err
+	}
+	v.store(key)
+	return true, nil
+}
+
+// CacheHits returns how many verifications were answered from the cache.
+func (v *Verifier) CacheHits() int64 {
+	return v.hits.Load()
+}
+
+// lookup reports whether key is cached, marking it as recently used.
+func (v *Verifier) lookup(key [sha256.Size]byte) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	element, ok := v.entries[key]
+	if ok {
+		v.order.MoveToFront(element)
+	}
+	return ok
+}
+
+// store caches key, evicting the least recently used entry when full.
+func (v *Verifier) store(key [sha256.Size]byte) {
+	if v.cacheSize == 0 {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.entries[key]; ok {
+		return
+	}
+	if v.order.Len() >= v.cacheSize {
+		oldest := v.order.Back()
+		v.order.Remove(oldest)
+		delete(v.entries, oldest.Value.([sha256.Size]byte))
+	}
+	v.entries[key] = v.order.PushFront(key)
+}
+
+// verificationKey hashes the inputs identifying a verification. Lengths are
+// included so different splits of the same bytes cannot collide.
+func verificationKey(root, leafHash []byte, leafIndex, treeSize int) [sha256.Size]byte {
+	buf := make([]byte, 0, 32+len(root)+len(leafHash))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(root)))
+	buf = append(buf, root...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(leafHash)))
+	buf = append(buf, leafHash...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(leafIndex))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(treeSize))
+	return sha256.Sum256(buf)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVerifierCache(t *testing.T) {
+	tree, _ := NewTree(createTestDataBlocks("A", "B", "C", "D", "E"))
+	proof, leafHash, _ := tree.GenerateProof(3)
+	verifier := NewVerifier(TreeOptions{}, 2)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		isValid, err := verifier.VerifyProof(tree.Root, proof, leafHash, 3, len(tree.Leaves))
+		if err != nil || !isValid {
+			t.Fatalf("Attempt %d: expected valid proof, got %v, %v", attempt, isValid, err)
+		}
+	}
+	if hits := verifier.CacheHits(); hits != 1 {
+		t.Errorf("Expected second verification to hit the cache, got %d hits", hits)
+	}
+
+	t.Run("TamperingBypassesCache", func(t *testing.T) {
+		tamperedLeaf := append([]byte{}, leafHash...)
+		tamperedLeaf[0] ^= 0xff
+		for range 2 {
+			isValid, err := verifier.VerifyProof(tree.Root, proof, tamperedLeaf, 3, len(tree.Leaves))
+			if err != nil || isValid {
+				t.Errorf("Expected tampered leaf to fail, got %v, %v", isValid, err)
+			}
+		}
+		isValid, _ := verifier.VerifyProof(tree.Root, proof, leafHash, 2, len(tree.Leaves))
+		if isValid {
+			t.Errorf("Expected wrong index to fail")
+		}
+		if hits := verifier.CacheHits(); hits != 1 {
+			t.Errorf("Failed verifications must not be cached, got %d hits", hits)
+		}
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		for _, index := range []int{0, 1, 2} {
+			p, h, _ := tree.GenerateProof(index)
+			verifier.VerifyProof(tree.Root, p, h, index, len(tree.Leaves))
+		}
+		// Index 3 was least recently used and is evicted by a cache of size 2
+		before := verifier.CacheHits()
+		verifier.VerifyProof(tree.Root, proof, leafHash, 3, len(tree.Leaves))
+		if verifier.CacheHits() != before {
+			t.Errorf("Expected evicted entry to miss the cache")
+		}
+	})
+
+	t.Run("CachingDisabled", func(t *testing.T) {
+		uncached := NewVerifier(TreeOptions{}, 0)
+		for range 2 {
+			uncached.VerifyProof(tree.Root, proof, leafHash, 3, len(tree.Leaves))
+		}
+		if uncached.CacheHits() != 0 {
+			t.Errorf("Expected no cache hits with caching disabled")
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// fileVerifyError carries the path whose verification failed out of the worker
+// pool. A nil err means the proof did not match.
+type fileVerifyError struct {
+	path string
+	err  error
+}
+
+func (e *fileVerifyError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("proof mismatch for %s", e.path)
+	}
+	return fmt.Sprintf("error verifying %s: %v", e.path, e.err)
+}
+
+// VerifyAllFiles checks every entry of files against root using its proof,
+// where files is the source tree's full listing (as returned by
+// BuildDirectoryTree) and proofs[i] is the proof for leaf i of the tree
+// BuildMerkleTree builds from it, which leaves directories out when
+// IgnoreDirectories is set. File contents
+// are re-hashed from SourceDir across HashConcurrency workers, and the check
+// stops at the first failure. It returns true and "" when every proof holds,
+// otherwise false and the path that failed, with a non-nil error only if the
+// path could not be read or verified at all.
+func (ds *DirectorySync) VerifyAllFiles(root []byte, files []FileInfo, proofs [][][]byte) (bool, string, error) {
+	// Index the entries the way BuildMerkleTree does
+	files = ds.comparedEntries(files)
+	if len(proofs) != len(files) {
+		return false, "", fmt.Errorf("%w: got %d proofs for %d files", ErrProofCount, len(proofs), len(files))
+	}
+
+	empty := emptyDirs(files)
+	err := runParallel(context.Background(), ds.hashConcurrency(), len(files), func(i int) error {
+		file := files[i]
+		if !file.IsDir {
+			hash, err := ds.hashEntry(filepath.Join(ds.SourceDir, filepath.FromSlash(file.Path)), file)
+			if err != nil {
+				return &fileVerifyError{path: file.Path, err: err}
+			}
+			file.Hash = hash
+		}
+		leafHash := ds.TreeOptions.hashLeaf(ds.encodeLeaf(file, empty))
+		isValid, err := VerifyProofWithOptions(root, proofs[i], leafHash, i, len(files), ds.TreeOptions)
+		if err != nil || !isValid {
+			return &fileVerifyError{path: file.Path, err: err}
+		}
+		return nil
+	})
+
+	var failure *fileVerifyError
+	if errors.As(err, &failure) {
+		return false, failure.path, failure.err
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return true, "", nil
+}
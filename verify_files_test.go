@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// treeWithProofs scans dir and returns its listing, root and every leaf's proof
+func treeWithProofs(t testing.TB, dir string) ([]FileInfo, []byte, [][][]byte) {
+	t.Helper()
+	return treeWithProofsFor(t, &DirectorySync{}, dir)
+}
+
+// treeWithProofsFor is treeWithProofs building the tree with ds's settings
+func treeWithProofsFor(t testing.TB, ds *DirectorySync, dir string) ([]FileInfo, []byte, [][][]byte) {
+	t.Helper()
+	files, err := ds.BuildDirectoryTree(dir)
+	if err != nil {
+		t.Fatalf("BuildDirectoryTree failed: %v", err)
+	}
+	tree, err := ds.BuildMerkleTree(files)
+	if err != nil {
+		t.Fatalf("BuildMerkleTree failed: %v", err)
+	}
+	proofs := make([][][]byte, len(tree.Leaves))
+	for i := range tree.Leaves {
+		proofs[i], _, _ = tree.GenerateProof(i)
+	}
+	return files, tree.Root, proofs
+}
+
+func TestVerifyAllFiles(t *testing.T) {
+	dir := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "B", "sub/c.txt": "C", "d.txt": "D"})
+	files, root, proofs := treeWithProofs(t, dir)
+	ds := &DirectorySync{SourceDir: dir, HashConcurrency: 3}
+
+	isValid, path, err := ds.VerifyAllFiles(root, files, proofs)
+	if err != nil || !isValid || path != "" {
+		t.Fatalf("Expected all files to verify, got %v, %q, %v", isValid, path, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "sub", "c.txt"), []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	isValid, path, err = ds.VerifyAllFiles(root, files, proofs)
+	if err != nil || isValid || path != "sub/c.txt" {
+		t.Errorf("Expected sub/c.txt to fail verification, got %v, %q, %v", isValid, path, err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "sub", "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	isValid, path, err = ds.VerifyAllFiles(root, files, proofs)
+	if err == nil || isValid || path != "sub/c.txt" {
+		t.Errorf("Expected a read error for sub/c.txt, got %v, %q, %v", isValid, path, err)
+	}
+
+	if _, _, err := ds.VerifyAllFiles(root, files, proofs[1:]); !errors.Is(err, ErrProofCount) {
+		t.Errorf("Expected ErrProofCount, got %v", err)
+	}
+}
+
+func TestVerifyAllFilesIgnoreDirectories(t *testing.T) {
+	dir := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "B", "sub/deeper/c.txt": "C"})
+	ds := &DirectorySync{SourceDir: dir, IgnoreDirectories: true}
+	files, root, proofs := treeWithProofsFor(t, ds, dir)
+	if len(proofs) != 3 {
+		t.Fatalf("Expected one proof per file, got %d", len(proofs))
+	}
+
+	isValid, path, err := ds.VerifyAllFiles(root, files, proofs)
+	if err != nil || !isValid || path != "" {
+		t.Errorf("Expected all files to verify without their directories, got %v, %q, %v", isValid, path, err)
+	}
+}
+
+func BenchmarkVerifyAllFiles(b *testing.B) {
+	dir := createManyFiles(b, 500)
+	files, root, proofs := treeWithProofs(b, dir)
+	ds := &DirectorySync{SourceDir: dir}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if isValid, path, err := ds.VerifyAllFiles(root, files, proofs); !isValid {
+			b.Fatalf("Verification failed at %q: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setxattr is syscall.Setxattr, replaceable in tests
+var setxattr = syscall.Setxattr
+
+// setRootXattr stores root in the stamped-root extended attribute of dir. It
+// does nothing if the file system doesn't support user extended attributes.
+func setRootXattr(dir string, root []byte) error {
+	if err := setxattr(dir, rootXattrName, root, 0); err != nil && !errors.Is(err, syscall.ENOTSUP) {
+		return err
+	}
+	return nil
+}
+
+// ReadStampedRoot returns the Merkle root stamped on dir by a sync with
+// StampRootXattr set.
+func ReadStampedRoot(dir string) ([]byte, error) {
+	size, err := syscall.Getxattr(dir, rootXattrName, nil)
+	if err != nil {
+		return nil, err
+	}
+	root := make([]byte, size)
+	size, err = syscall.Getxattr(dir, rootXattrName, root)
+	if err != nil {
+		return nil, err
+	}
+	return root[:size], nil
+}
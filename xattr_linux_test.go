@@ -0,0 +1,71 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestSyncStampRootXattr(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A", "sub/b.txt": "B"})
+	dst := t.TempDir()
+	if err := syscall.Setxattr(dst, rootXattrName, []byte("probe"), 0); errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("Filesystem does not support user extended attributes")
+	}
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, StampRootXattr: true}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	stamped, err := ReadStampedRoot(dst)
+	if err != nil {
+		t.Fatalf("ReadStampedRoot failed: %v", err)
+	}
+	if want := dirRoot(t, src); !bytes.Equal(stamped, want) {
+		t.Errorf("Stamped root %x, expected source root %x", stamped, want)
+	}
+
+	// A later sync restamps the destination with the new root
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	syncer = &DirectorySync{SourceDir: src, DestinationDir: dst, StampRootXattr: true}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	stamped, _ = ReadStampedRoot(dst)
+	if want := dirRoot(t, src); !bytes.Equal(stamped, want) {
+		t.Errorf("Expected restamped root %x, got %x", want, stamped)
+	}
+
+	if _, err := ReadStampedRoot(src); err == nil {
+		t.Errorf("Expected an error reading an unstamped directory")
+	}
+}
+
+func TestSyncStampRootXattrUnsupported(t *testing.T) {
+	src := createTestDir(t, map[string]string{"a.txt": "A"})
+	dst := t.TempDir()
+
+	defer func(orig func(string, string, []byte, int) error) { setxattr = orig }(setxattr)
+	setxattr = func(string, string, []byte, int) error { return syscall.ENOTSUP }
+
+	syncer := &DirectorySync{SourceDir: src, DestinationDir: dst, StampRootXattr: true}
+	if err := syncer.SyncDirectories(); err != nil {
+		t.Fatalf("Expected sync to ignore unsupported xattrs, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "a.txt")); err != nil {
+		t.Errorf("Expected a.txt to be synced: %v", err)
+	}
+
+	setxattr = func(string, string, []byte, int) error { return syscall.EACCES }
+	syncer = &DirectorySync{SourceDir: src, DestinationDir: dst, StampRootXattr: true}
+	if err := syncer.SyncDirectories(); err == nil {
+		t.Errorf("Expected other xattr errors to fail the sync")
+	}
+}
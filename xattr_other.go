@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+// setRootXattr does nothing on platforms without extended attribute support
+func setRootXattr(dir string, root []byte) error {
+	return nil
+}
+
+// ReadStampedRoot returns ErrXattrUnsupported, since roots are never stamped
+// on this platform.
+func ReadStampedRoot(dir string) ([]byte, error) {
+	return nil, ErrXattrUnsupported
+}